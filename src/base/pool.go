@@ -1,17 +1,152 @@
 package base
 
-func makeBufferUnit(sz int) []byte {
-	buf := make([]byte, sz)
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
 
-	return buf
+// size classes of buffers in power of 2, from 1 KB to 16 MB
+const (
+	LEN_MIN_CLASS = 1 << 10
+	LEN_MAX_CLASS = 1 << 24
+)
+
+// events given to the hook of the pool
+const (
+	POOL_GET   = iota // buffer taken from the pool
+	POOL_ALLOC        // buffer allocated newly, no one to reuse or too big
+	POOL_PUT          // buffer given back to the pool
+	POOL_DROP         // buffer not fit to any class, left to GC
+)
+
+var PoolEventText = map[int]string{
+	POOL_GET:   "get",
+	POOL_ALLOC: "alloc",
+	POOL_PUT:   "put",
+	POOL_DROP:  "drop",
+}
+
+// hook called at every event with the size of the buffer, for metrics
+type PoolHook func(event int, size int)
+
+// pool of buffers by size class, a buffer of n bytes is taken from
+// the smallest class not less than n
+type BufferPool struct {
+	Min     int // size of the smallest class
+	Max     int // size of the largest class
+	Gets    int64
+	Allocs  int64
+	Puts    int64
+	Drops   int64
+	classes []sync.Pool
+	hook    atomic.Value // PoolHook
+}
+
+// pool shared by the protocols for frame reads and writes
+var DefaultPool = NewBufferPool(LEN_MIN_CLASS, LEN_MAX_CLASS)
+
+func NewBufferPool(min int, max int) *BufferPool {
+	bp := &BufferPool{Min: classSize(min, 1), Max: max}
+
+	for size := bp.Min; size <= max; size <<= 1 {
+		bp.classes = append(bp.classes, sync.Pool{})
+	}
+	bp.Max = bp.Min << uint(len(bp.classes)-1)
+
+	return bp
+}
+
+func (bp *BufferPool) String() string {
+	str := fmt.Sprintf("[BufferPool] Classes: %d (%d - %d)", len(bp.classes), bp.Min, bp.Max)
+	str += fmt.Sprintf("\tGets: %d", atomic.LoadInt64(&bp.Gets))
+	str += fmt.Sprintf("\tAllocs: %d", atomic.LoadInt64(&bp.Allocs))
+	str += fmt.Sprintf("\tPuts: %d", atomic.LoadInt64(&bp.Puts))
+	str += fmt.Sprintf("\tDrops: %d", atomic.LoadInt64(&bp.Drops))
+	return str
+}
+
+// set the hook for metrics, nil to remove it
+func (bp *BufferPool) SetHook(hook PoolHook) {
+	bp.hook.Store(hook)
+}
+
+func (bp *BufferPool) event(event int, size int) {
+	switch event {
+	case POOL_GET:
+		atomic.AddInt64(&bp.Gets, 1)
+	case POOL_ALLOC:
+		atomic.AddInt64(&bp.Allocs, 1)
+	case POOL_PUT:
+		atomic.AddInt64(&bp.Puts, 1)
+	case POOL_DROP:
+		atomic.AddInt64(&bp.Drops, 1)
+	}
+
+	if hook, ok := bp.hook.Load().(PoolHook); ok && hook != nil {
+		hook(event, size)
+	}
+}
+
+// smallest power of 2 times of min not less than n
+func classSize(n int, min int) int {
+	size := min
+	for size < n {
+		size <<= 1
+	}
+	return size
 }
 
-func makeBufferPool(n int, sz int) [][]byte {
-	pool := make([][]byte, n)
+// index of the class of the size, -1 if none
+func (bp *BufferPool) classOf(size int) int {
+	i := 0
+	for c := bp.Min; c <= bp.Max; c <<= 1 {
+		if c == size {
+			return i
+		}
+		i++
+	}
+	return -1
+}
+
+// get a buffer of n bytes, its capacity is the size of the class
+func (bp *BufferPool) Get(n int) []byte {
+	bp.event(POOL_GET, n)
+
+	if n > bp.Max {
+		bp.event(POOL_ALLOC, n)
+		return make([]byte, n)
+	}
+
+	size := classSize(n, bp.Min)
+	if p, ok := bp.classes[bp.classOf(size)].Get().(*[]byte); ok {
+		return (*p)[:n]
+	}
+
+	bp.event(POOL_ALLOC, size)
+	return make([]byte, n, size)
+}
 
-	for i := 0; i < n; i++ {
-		pool[i] = makeBufferUnit(sz)
+// give the buffer back to be reused, it must not be used any more
+func (bp *BufferPool) Put(buf []byte) {
+	i := bp.classOf(cap(buf))
+	if i < 0 {
+		if buf != nil {
+			bp.event(POOL_DROP, cap(buf))
+		}
+		return
 	}
 
-	return pool
+	bp.event(POOL_PUT, cap(buf))
+	buf = buf[:cap(buf)]
+	bp.classes[i].Put(&buf)
+}
+
+// get and put of the default pool
+func GetBuffer(n int) []byte {
+	return DefaultPool.Get(n)
+}
+
+func PutBuffer(buf []byte) {
+	DefaultPool.Put(buf)
 }
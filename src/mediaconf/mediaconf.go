@@ -10,29 +10,35 @@ package mediaconf
 
 import (
 	"bufio"
+	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
-	"mime/multipart"
 	"net/http"
 	"net/url"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
 
 	"golang.org/x/net/websocket"
 
 	"github.com/bradfitz/http2"
 	"github.com/fatih/color"
 
-	pf "stoney/httpserver/src/protofile"
+	bp "stoney/httpserver/src/base"
+	pb "stoney/httpserver/src/protobase"
 	ph "stoney/httpserver/src/protohttp"
-	pt "stoney/httpserver/src/prototcp"
 	pw "stoney/httpserver/src/protows"
 
 	sb "stoney/httpserver/src/streambase"
+	si "stoney/httpserver/src/streaminfo"
 	sr "stoney/httpserver/src/streamring"
 )
 
@@ -162,7 +168,7 @@ func ParseMonitorCommand(cmdstr string, r *bufio.Reader) error {
 	// GET ops
 	case "show":
 		if ntok < 2 {
-			fmt.Printf("usage: show [config|dir|network|channel|ring|array|actor]\n")
+			fmt.Printf("usage: show [config|dir|network|channel|ring|stats|array|actor]\n")
 			return err
 		}
 
@@ -174,7 +180,7 @@ func ParseMonitorCommand(cmdstr string, r *bufio.Reader) error {
 		case "network":
 		case "channel":
 		case "array":
-		case "ring":
+		case "ring", "stats":
 			id := "0"
 			if ntok < 3 {
 				id, err = PromptReadLineWithDefault("\tring id", id, r)
@@ -205,7 +211,7 @@ func ParseMonitorCommand(cmdstr string, r *bufio.Reader) error {
 	// POST ops
 	case "start":
 		if ntok < 2 {
-			fmt.Printf("usage: start [http_reader|tcp_caster/server|dir_reader|file_reader/writer] [params ...]\n")
+			fmt.Printf("usage: start [http_reader/caster|tcp_caster/server|dir_reader|file_reader/writer] [params ...]\n")
 			return err
 		}
 
@@ -237,6 +243,30 @@ func ParseMonitorCommand(cmdstr string, r *bufio.Reader) error {
 				id = toks[3]
 			}
 			params.Add("id", id)
+		case "http_caster":
+			url := "http://localhost:8000/stream/relay"
+			if ntok < 3 {
+				url, err = PromptReadLineWithDefault("\turl to cast", url, r)
+				if err != nil {
+					return err
+				}
+			} else {
+				url = toks[2]
+			}
+			if url == "q" { // command cancel
+				return err
+			}
+			params.Add("url", url)
+			id := "0"
+			if ntok < 4 {
+				id, err = PromptReadLineWithDefault("\tring id", id, r)
+				if err != nil {
+					return err
+				}
+			} else {
+				id = toks[3]
+			}
+			params.Add("id", id)
 		case "dir_reader":
 			file := "static/image/*.jpg"
 			if ntok < 3 {
@@ -383,11 +413,11 @@ func ParseMonitorCommand(cmdstr string, r *bufio.Reader) error {
 
 		switch toks[1] {
 		case "show":
-			fmt.Printf("usage: show [config|dir|network|ring|array]\n")
+			fmt.Printf("usage: show [config|dir|network|ring|stats|array]\n")
 		case "close":
 			fmt.Printf("usage: close [ring|array]\n")
 		case "start":
-			fmt.Printf("usage: start [http_reader|dir_reader|file_reader/writer|tcp_caster/server]\n")
+			fmt.Printf("usage: start [http_reader/caster|dir_reader|file_reader/writer|tcp_caster/server]\n")
 		case "stop":
 			fmt.Printf("usage: stop [actor] [id]\n")
 		default:
@@ -425,12 +455,62 @@ func ParseMonitorCommand(cmdstr string, r *bufio.Reader) error {
 }
 
 //---------------------------------------------------------------------------
-//	multipart reader entry, mainly from camera
+//	multipart reader entry, mainly from camera, reconnecting with backoff
+//	until the actor(base) is closed, nil base for running forever
 //---------------------------------------------------------------------------
-func (sc *ServerConfig) StreamReader(ring *sr.StreamRing, url string) error {
+func (sc *ServerConfig) StreamReader(base *pb.ProtoBase, ring *sr.StreamRing, url string) error {
 	log.Printf("start %s for %s\n", ph.STR_HTTP_READER, url)
 	defer log.Printf("end %s for %s\n", ph.STR_HTTP_READER, url)
 
+	var err error
+
+	if base == nil {
+		base = pb.NewProtoBase()
+		base.SetStatusRun()
+	}
+	defer base.SetStatusIdle()
+
+	// keep the ring in use while reconnecting not to stop the players
+	err = ring.SetStatusUsing()
+	if err != nil {
+		log.Println(sb.RedString("ErrStatus/StreamReader"))
+		return err
+	}
+	defer ring.SetStatusIdle()
+
+	backoff := pb.NewBackoff(pb.TIME_MIN_BACKOFF, pb.TIME_MAX_BACKOFF)
+
+	for base.IsRun() && ring.IsUsing() {
+		var n int
+		n, err = sc.readStreamOnce(base, ring, url)
+		if !base.IsRun() || !ring.IsUsing() {
+			break
+		}
+
+		// connected and read well, start again from the min
+		if n > 0 {
+			backoff.Reset()
+		}
+		if err == nil {
+			err = io.EOF
+		}
+		base.SetError(err)
+
+		wait := backoff.Next()
+		log.Printf("reconnect to %s after %v by %v\n", url, wait, err)
+		if !base.SleepWhileRun(wait) {
+			break
+		}
+		base.AddReconnect()
+	}
+
+	return err
+}
+
+//---------------------------------------------------------------------------
+// read the stream into the ring until it ends, and return the count of parts
+//---------------------------------------------------------------------------
+func (sc *ServerConfig) readStreamOnce(base *pb.ProtoBase, ring *sr.StreamRing, url string) (int, error) {
 	var err error
 	var res *http.Response
 
@@ -443,36 +523,116 @@ func (sc *ServerConfig) StreamReader(ring *sr.StreamRing, url string) error {
 	}
 	if err != nil {
 		log.Println(sb.RedString(err))
-		return err
+		return 0, err
 	}
 	defer res.Body.Close()
 
+	if res.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("%s", res.Status)
+	}
+
+	// the boundary may be changed by the new response
 	boundary, err := ph.GetTypeBoundary(res.Header.Get("Content-Type"))
 	if err != nil {
 		log.Println(err)
-		return err
+		return 0, err
 	}
+	ring.SetBoundary(boundary)
+
+	// unblock the read when the actor is closed
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for base.IsRun() {
+			select {
+			case <-done:
+				return
+			case <-time.After(time.Second):
+			}
+		}
+		res.Body.Close()
+	}()
 
-	ring.Boundary = boundary
-	mr := multipart.NewReader(res.Body, ring.Boundary)
+	mr := sr.NewPartReader(res.Body, boundary)
 
-	err = ph.ReadMultipartToRing(mr, ring)
-
-	return err
+	return ph.ReadPartsToRing(mr, ring)
 }
 
 //---------------------------------------------------------------------------
-// http caster client
+// http caster client relaying the ring to the server, reconnecting with
+// backoff until the actor(base) is closed, nil base for running forever
 //---------------------------------------------------------------------------
-func (sc *ServerConfig) StreamCaster(url string) error {
+func (sc *ServerConfig) StreamCaster(base *pb.ProtoBase, ring *sr.StreamRing, url string) error {
 	log.Printf("start %s for %s\n", ph.STR_HTTP_CASTER, url)
 	defer log.Printf("end %s for %s\n", ph.STR_HTTP_CASTER, url)
 
-	hp := ph.NewProtoHttp()
-	client := ph.NewClient()
-	return hp.RequestPost(client)
+	var err error
 
-	// TODO: sending data
+	if base == nil {
+		base = pb.NewProtoBase()
+		base.SetStatusRun()
+	}
+	defer base.SetStatusIdle()
+
+	// canceled as soon as the actor is closed
+	ctx, cancel := base.NewContext()
+	defer cancel()
+
+	client := ph.NewStreamClient()
+	backoff := pb.NewBackoff(pb.TIME_MIN_BACKOFF, pb.TIME_MAX_BACKOFF)
+
+	for base.IsRun() {
+		// wait for the source ring to be cast
+		if ring.IsUsing() {
+			rr := ring.NewRingReader()
+			err = ph.PostReaderInMultipart(ctx, client, url, rr)
+			if rr.Reads > 0 {
+				backoff.Reset()
+			}
+			rr.Close()
+		} else {
+			err = sb.ErrStatus
+		}
+		if !base.IsRun() {
+			break
+		}
+
+		if err == nil {
+			err = io.EOF
+		}
+		base.SetError(err)
+
+		wait := backoff.Next()
+		log.Printf("reconnect to %s after %v by %v\n", url, wait, err)
+		if !base.SleepWhileRun(wait) {
+			break
+		}
+		base.AddReconnect()
+	}
+
+	return err
+}
+
+//---------------------------------------------------------------------------
+// combiner of rings run as an actor until it is closed
+//---------------------------------------------------------------------------
+func (sc *ServerConfig) StreamCombiner(base *pb.ProtoBase, rc *sr.RingCombiner) error {
+	log.Printf("start %s\n", rc)
+	defer log.Printf("end %s\n", rc)
+
+	var err error
+
+	// canceled as soon as the actor is closed
+	ctx, cancel := base.NewContext()
+	defer cancel()
+
+	err = rc.Run(ctx)
+	if err != nil {
+		log.Println(err)
+	}
+	base.SetStatusIdle()
+
+	return err
 }
 
 //---------------------------------------------------------------------------
@@ -497,8 +657,9 @@ func (sc *ServerConfig) StreamPlayer(ring *sr.StreamRing, url string) error {
 		return err
 	}
 
-	ring.Boundary, err = ph.GetTypeBoundary(res.Header.Get("Content-Type"))
-	mr := multipart.NewReader(res.Body, ring.Boundary)
+	boundary, err := ph.GetTypeBoundary(res.Header.Get("Content-Type"))
+	ring.SetBoundary(boundary)
+	mr := sr.NewPartReader(res.Body, boundary)
 
 	err = ph.ReadMultipartToRing(mr, ring)
 
@@ -513,14 +674,31 @@ func (sc *ServerConfig) StreamServer(ring *sr.StreamRing) error {
 	defer log.Printf("end %s\n", ph.STR_HTTP_SERVER)
 
 	http.HandleFunc("/", sc.IndexHandler)
-	http.HandleFunc("/hello", sc.HelloHandler)     // view
-	http.HandleFunc("/media", sc.MediaHandler)     // on-demand
-	http.HandleFunc("/stream", sc.StreamHandler)   // live
-	http.HandleFunc("/search", sc.SearchHandler)   // server info
-	http.HandleFunc("/command", sc.CommandHandler) // server control & monitor
+	http.HandleFunc("/hello", sc.HelloHandler)        // view
+	http.HandleFunc("/media", sc.MediaHandler)        // on-demand
+	http.HandleFunc("/media/", sc.MediaHandler)       // on-demand, files under the root
+	http.HandleFunc("/stream", sc.StreamHandler)      // live
+	http.HandleFunc("/stream/", sc.RingHandler)       // live by the ring name
+	http.HandleFunc("/snapshot/", sc.SnapshotHandler) // still of the ring
+	http.HandleFunc("/upload", sc.UploadHandler)      // into the media store
+	http.HandleFunc("/search", sc.SearchHandler)      // channels, rings and recordings
+	http.HandleFunc("/command", sc.CommandHandler)    // server control & monitor, in text
+
+	http.HandleFunc(STR_API_PREFIX+"rings", sc.ApiRingsHandler) // server control in JSON
+	http.HandleFunc(STR_API_PREFIX+"rings/", sc.ApiRingsHandler)
+	http.HandleFunc(STR_API_PREFIX+"actors", sc.ApiActorsHandler)
+	http.HandleFunc(STR_API_PREFIX+"actors/", sc.ApiActorsHandler)
+	http.HandleFunc(STR_API_PREFIX+"channels", sc.ApiChannelsHandler)
+	http.HandleFunc(STR_API_PREFIX+"channels/", sc.ApiChannelsHandler)
 
 	http.Handle("/websocket", websocket.Handler(sc.WebsocketHandler))
 
+	// remove the named rings not used for a while
+	go sc.Rings.CollectLoop(context.Background())
+
+	// restore the rings saved at the last shutdown, saved again by Shutdown
+	sc.LoadSnapshots()
+
 	// CAUTION: don't use /static not /static/ as the prefix
 	http.Handle("/static/", http.StripPrefix("/static/", FileServer("./static")))
 
@@ -554,6 +732,112 @@ func (sc *ServerConfig) StreamServer(ring *sr.StreamRing) error {
 	return nil
 }
 
+//---------------------------------------------------------------------------
+// snapshot file of the named ring escaped not to collide with other names,
+// ex) 100/110/111 -> 100%2F110%2F111.snap, a_b -> a_b.snap
+//---------------------------------------------------------------------------
+func (sc *ServerConfig) snapshotPath(name string) string {
+	return filepath.Join(sc.SnapDir, url.PathEscape(name)+".snap")
+}
+
+//---------------------------------------------------------------------------
+// load the snapshots of the rings configured, the broken one is ignored
+//---------------------------------------------------------------------------
+func (sc *ServerConfig) LoadSnapshots() {
+	if sc.SnapDir == "" {
+		return
+	}
+
+	for _, name := range sc.Rings.Names() {
+		ring, err := sc.Rings.Get(name)
+		if err != nil {
+			continue
+		}
+
+		path := sc.snapshotPath(name)
+		err = ring.LoadSnapshot(path)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				log.Printf("snapshot %s is ignored: %v\n", path, err)
+			}
+			continue
+		}
+		log.Printf("ring %s is restored from %s\n", name, path)
+	}
+}
+
+//---------------------------------------------------------------------------
+// save the snapshots of the rings
+//---------------------------------------------------------------------------
+func (sc *ServerConfig) SaveSnapshots() error {
+	var err error
+
+	if sc.SnapDir == "" {
+		return err
+	}
+
+	err = os.MkdirAll(sc.SnapDir, 0755)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	for _, name := range sc.Rings.Names() {
+		ring, err := sc.Rings.Get(name)
+		if err != nil {
+			continue
+		}
+
+		err = ring.SaveSnapshot(sc.snapshotPath(name))
+		if err != nil {
+			continue
+		}
+		log.Printf("ring %s is saved\n", name)
+	}
+
+	return nil
+}
+
+//---------------------------------------------------------------------------
+// wait for the signal to shut down the server, ex) ^C, kill
+//---------------------------------------------------------------------------
+func (sc *ServerConfig) WaitShutdown() os.Signal {
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigc)
+
+	return <-sigc
+}
+
+//---------------------------------------------------------------------------
+// shut down the servers not to change the rings any more, and save them,
+// the requests not finished until ctx is done are closed
+//---------------------------------------------------------------------------
+func (sc *ServerConfig) Shutdown(ctx context.Context) error {
+	sc.serversMu.Lock()
+	servers := sc.servers
+	sc.servers = nil
+	sc.serversMu.Unlock()
+
+	for _, srv := range servers {
+		err := srv.Shutdown(ctx)
+		if err != nil {
+			log.Println(err)
+			srv.Close() // streaming without end
+		}
+	}
+
+	return sc.SaveSnapshots()
+}
+
+// keep the server to be shut down
+func (sc *ServerConfig) addServer(srv *http.Server) {
+	sc.serversMu.Lock()
+	defer sc.serversMu.Unlock()
+
+	sc.servers = append(sc.servers, srv)
+}
+
 //---------------------------------------------------------------------------
 // index file handler
 //---------------------------------------------------------------------------
@@ -595,21 +879,6 @@ func (sc *ServerConfig) HelloHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-//---------------------------------------------------------------------------
-// handle /media to provide on-demand media file request
-//---------------------------------------------------------------------------
-func (sc *ServerConfig) MediaHandler(w http.ResponseWriter, r *http.Request) {
-	log.Printf("handle /media %s for %s to %s\n", r.Method, r.RequestURI, r.Host)
-	defer r.Body.Close()
-
-	_, err := os.Stat(r.URL.Path[1:])
-	if err != nil {
-		ph.WriteResponseMessage(w, 404, r.URL.Path+" is Not Found")
-	} else {
-		ph.WriteResponseMessage(w, 200, r.URL.Path)
-	}
-}
-
 //---------------------------------------------------------------------------
 // websocket handler
 //---------------------------------------------------------------------------
@@ -620,6 +889,7 @@ func (sc *ServerConfig) WebsocketHandler(ws *websocket.Conn) {
 	var err error
 
 	wp := pw.NewProtoWs()
+	wp.Rings = sc.Rings
 
 	err = wp.HandleRequest(ws, sc.Array[0])
 	if err != nil {
@@ -628,23 +898,6 @@ func (sc *ServerConfig) WebsocketHandler(ws *websocket.Conn) {
 	}
 }
 
-//---------------------------------------------------------------------------
-// handle /search access
-//---------------------------------------------------------------------------
-func (sc *ServerConfig) SearchHandler(w http.ResponseWriter, r *http.Request) {
-	log.Printf("handle /search %s for %s to %s\n", r.Method, r.RequestURI, r.Host)
-	defer r.Body.Close()
-
-	var err error
-
-	err = ph.WriteResponseMessage(w, 200, "/search: Not yet implemented")
-	if err != nil {
-		log.Println(err)
-	}
-
-	return
-}
-
 //---------------------------------------------------------------------------
 // handle /command access
 //---------------------------------------------------------------------------
@@ -684,134 +937,84 @@ func (sc *ServerConfig) CommandHandler(w http.ResponseWriter, r *http.Request) {
 				} else {
 					str = fmt.Sprintf("error> id(%s)", id)
 				}
+			case "stats":
+				id := query.Get("id")
+				i, err := strconv.Atoi(id)
+				if err == nil && i < len(sc.Array) {
+					stats := sc.Array[i].Stats()
+					str = fmt.Sprintf("[%d] %s", i, stats.String())
+				} else {
+					str = fmt.Sprintf("error> id(%s)", id)
+				}
 			case "array":
 				for i := range sc.Array {
 					str += fmt.Sprintf("[%d] %s\n", i, sc.Array[i].BaseString())
 				}
+			case "rings":
+				str = fmt.Sprint(sc.Rings)
+			case "pool":
+				str = fmt.Sprint(bp.DefaultPool)
 			case "actor":
-				for key, actor := range sc.Actors {
-					if actor.Status == sb.STATUS_IDLE {
-						delete(sc.Actors, key)
-					}
+				for _, actor := range sc.RemoveIdleActors() {
 					str += fmt.Sprintf("%s\n", actor)
 				}
 			default:
-				str = "what obj? [config|network|ring|array|actor]"
+				str = "what obj? [config|network|ring|stats|array|rings|pool|actor]"
 			}
 		default:
 			str = "what op? [show]"
 		}
 
-	// control part
+	// control part, on top of the operations of the JSON API
 	case "POST":
 		switch op {
 		case "start":
-			switch obj {
-			case "http_reader":
-				url := query.Get("url")
-				id := query.Get("id")
-				i, err := strconv.Atoi(id)
-				if err == nil && i < len(sc.Array) {
-					np := ph.NewProtoHttpWithUrl(url)
-					sc.Actors[np.Base.Id] = np.Base
-					go sc.StreamReader(sc.Array[i], url)
-					str = fmt.Sprintf("order to start %s (%s -> %s)", obj, url, id)
-				} else {
-					str = fmt.Sprintf("error: %s (%s -> %s)", obj, url, id)
-				}
-			case "dir_reader":
-				file := query.Get("file")
-				id := query.Get("id")
-				i, err := strconv.Atoi(id)
-				if err == nil && i < len(sc.Array) {
-					np := pf.NewProtoFile(file)
-					sc.Actors[np.Base.Id] = np.Base
-					go np.DirReader(sc.Array[i], true)
-					str = fmt.Sprintf("order to start %s (%s, %s)", obj, file, id)
-				} else {
-					str = fmt.Sprintf("error: %s (%s -> %s)", obj, file, id)
-				}
-			case "file_reader":
-				file := query.Get("file")
-				id := query.Get("id")
-				i, err := strconv.Atoi(id)
-				if err == nil && i < len(sc.Array) {
-					np := pf.NewProtoFile(file)
-					sc.Actors[np.Base.Id] = np.Base
-					go np.StreamReader(sc.Array[i])
-					str = fmt.Sprintf("order to start %s (%s, %s)", obj, file, id)
-				} else {
-					str = fmt.Sprintf("error: %s (%s -> %s)", obj, file, id)
-				}
-			case "file_writer":
-				id := query.Get("id")
-				file := query.Get("file")
-				i, err := strconv.Atoi(id)
-				if err == nil && i < len(sc.Array) {
-					np := pf.NewProtoFile(file)
-					sc.Actors[np.Base.Id] = np.Base
-					go np.StreamWriter(sc.Array[i])
-					str = fmt.Sprintf("order to start %s (%s, %s)", obj, file, id)
-				} else {
-					str = fmt.Sprintf("error: %s (%s -> %s)", obj, file, id)
-				}
-			case "tcp_server":
-				port := query.Get("port")
-				id := query.Get("id")
-				i, err := strconv.Atoi(id)
-				if err == nil && i < len(sc.Array) {
-					np := pt.NewProtoTcp("localhost", port, "T-Rx")
-					sc.Actors[np.Base.Id] = np.Base
-					go np.StreamServer(sc.Array[i])
-					str = fmt.Sprintf("order to start %s (%s, %s)", obj, port, id)
-				} else {
-					str = fmt.Sprintf("error: %s (%s -> %s)", obj, port, id)
-				}
-			case "tcp_caster":
-				port := query.Get("port")
-				id := query.Get("id")
-				i, err := strconv.Atoi(id)
-				if err == nil && i < len(sc.Array) {
-					np := pt.NewProtoTcp("localhost", port, "T-Rx")
-					sc.Actors[np.Base.Id] = np.Base
-					go np.StreamCaster()
-					str = fmt.Sprintf("order to start %s (%s, %s)", obj, port, id)
-				} else {
-					str = fmt.Sprintf("error: %s (%s -> %s)", obj, port, id)
-				}
-			default:
-				str = "what obj to start? [http_reader|dir_reader|file_reader/writer|tcp_caster/server]"
-			}
+			str = sc.commandStart(obj, query)
 
 		case "stop":
 			switch obj {
 			case "actor":
 				id := query.Get("id")
-				actor := sc.Actors[id]
-				if actor != nil {
-					actor.SetStatusClose()
+				err = sc.StopActor(id)
+				if err == nil {
 					str = fmt.Sprintf("%s %s is closed", obj, id)
-				} else {
+				} else if GetApiError(err).Status == http.StatusNotFound {
 					str = fmt.Sprintf("%s %s not exist", obj, id)
+				} else {
+					str = fmt.Sprintf("error: %s (%s) %s", obj, id, err)
+				}
+			case "spill":
+				id := query.Get("id")
+				stop := ""
+				_, err = sc.UpdateRing(id, &RingRequest{Spill: &stop})
+				if err == nil {
+					str = fmt.Sprintf("%s of ring %s is stopped", obj, id)
+				} else {
+					str = fmt.Sprintf("error: %s (%s) %s", obj, id, err)
 				}
 			default:
-				str = "what obj to stop? [ring|array]"
+				str = "what obj to stop? [actor|spill]"
 			}
 
 		case "close":
 			switch obj {
 			case "ring":
-				id := query.Get("id")
-				i, err := strconv.Atoi(id)
-				if err == nil && i < len(sc.Array) {
-					ring = sc.Array[i]
-				} else {
-					str = "error: invalid ring number: " + id
+				// the named ring is removed, ex) name=lobby-cam
+				name := query.Get("name")
+				if name != "" {
+					err = sc.RemoveRing(name)
+					if err != nil {
+						str = "error: " + err.Error()
+					} else {
+						str = "removed the ring: " + name
+					}
 					break
 				}
-				err = ring.SetStatusIdle()
+
+				id := query.Get("id")
+				_, err = sc.UpdateRing(id, &RingRequest{Status: "idle"})
 				if err != nil {
-					str = fmt.Sprint(err)
+					str = "error: " + err.Error()
 				} else {
 					str = "set to stop the ring: " + id
 				}
@@ -824,8 +1027,33 @@ func (sc *ServerConfig) CommandHandler(w http.ResponseWriter, r *http.Request) {
 				str = "what obj to close? [ring|array]"
 			}
 
+		case "resize":
+			switch obj {
+			case "ring":
+				// ex) name=lobby-cam&num=60 or id=0&num=60
+				num, err := strconv.Atoi(query.Get("num"))
+				if err != nil || num == 0 {
+					str = "error: invalid number of slots: " + query.Get("num")
+					break
+				}
+
+				key := query.Get("name")
+				if key == "" {
+					key = query.Get("id")
+				}
+
+				_, err = sc.UpdateRing(key, &RingRequest{Num: num})
+				if err != nil {
+					str = fmt.Sprintf("error: %s %s", obj, err)
+				} else {
+					str = fmt.Sprintf("resized the ring %s to %d slots", key, num)
+				}
+			default:
+				str = "what obj to resize? [ring]"
+			}
+
 		default:
-			str = "what op? [start|stop]"
+			str = "what op? [start|stop|close|resize]"
 		}
 
 	default:
@@ -838,6 +1066,103 @@ func (sc *ServerConfig) CommandHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+//---------------------------------------------------------------------------
+// start the obj of /command by the API, and return the answer in text
+//---------------------------------------------------------------------------
+func (sc *ServerConfig) commandStart(obj string, query url.Values) string {
+	id := query.Get("id")
+
+	req := &ActorRequest{
+		Type: obj,
+		Ring: id,
+		Url:  query.Get("url"),
+		File: query.Get("file"),
+		Port: query.Get("port"),
+	}
+
+	// the parameters told in the answer
+	var from, to, sep string
+	switch obj {
+	case "http_reader":
+		from, to, sep = req.Url, id, " -> "
+	case "http_caster":
+		// ex) url=http://host:8000/stream/relay&id=0 or name=lobby-cam
+		if name := query.Get("name"); name != "" {
+			req.Ring = name
+		}
+		from, to, sep = req.Ring, req.Url, " -> "
+	case "dir_reader", "file_reader", "file_writer":
+		from, to, sep = req.File, id, ", "
+	case "tcp_server", "tcp_caster":
+		from, to, sep = req.Port, id, ", "
+	case "combiner":
+		// ex) mode=mosaic&ids=0,1&out=wall&fps=10
+		req.Mode = query.Get("mode")
+		req.Rings = strings.Split(query.Get("ids"), ",")
+		req.Out = query.Get("out")
+		req.Fps, _ = strconv.Atoi(query.Get("fps"))
+		from, to, sep = query.Get("ids"), req.Out, " -> "
+	case "spill":
+		path := query.Get("path")
+		if path == "" {
+			return fmt.Sprintf("error: %s (%s -> %s)", obj, path, id)
+		}
+		_, err := sc.UpdateRing(id, &RingRequest{Spill: &path})
+		if err != nil {
+			return fmt.Sprintf("error: %s (%s -> %s) %s", obj, path, id, err)
+		}
+		return fmt.Sprintf("order to start %s (%s, %s)", obj, path, id)
+	default:
+		return "what obj to start? [http_reader/caster|dir_reader|file_reader/writer|spill|combiner|tcp_caster/server]"
+	}
+
+	_, err := sc.StartActor(req)
+	if err != nil {
+		return fmt.Sprintf("error: %s (%s%s%s) %s", obj, from, sep, to, err)
+	}
+
+	return fmt.Sprintf("order to start %s (%s%s%s)", obj, from, sep, to)
+}
+
+//---------------------------------------------------------------------------
+// get the status for the seek failed, not found if nothing is kept and
+// not satisfiable for the time out of the ring
+//---------------------------------------------------------------------------
+func GetSeekStatus(err error) int {
+	if err == sb.ErrEmpty {
+		return http.StatusNotFound
+	}
+	return http.StatusRequestedRangeNotSatisfiable
+}
+
+//---------------------------------------------------------------------------
+// get the reorder stage in front of the ring, nil if not asked,
+// ex) ?reorder=timestamp&latency=200ms, sequence by X-Sequence of the parts
+//---------------------------------------------------------------------------
+func GetReorderFromQuery(query url.Values, ring *sr.StreamRing) (*sr.RingReorder, error) {
+	var err error
+
+	name := query.Get("reorder")
+	if name == "" {
+		return nil, nil
+	}
+
+	order, err := sr.GetOrderByName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var latency time.Duration
+	if str := query.Get("latency"); str != "" {
+		latency, err = time.ParseDuration(str)
+		if err != nil || latency < 0 {
+			return nil, fmt.Errorf("invalid latency: %s", str)
+		}
+	}
+
+	return sr.NewRingReorder(ring, order, latency), nil
+}
+
 //---------------------------------------------------------------------------
 // handle /stream access
 //---------------------------------------------------------------------------
@@ -846,38 +1171,129 @@ func (sc *ServerConfig) StreamHandler(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
 	var err error
-	/*
-		query := r.URL.Query()
-		trk := query.Get("track")
-	*/
-	ring := sc.Array[0]
+
+	query := r.URL.Query()
+
+	sq, err := si.GetStreamRequestFromQuery(r.URL.RawQuery)
+	if err != nil {
+		ph.WriteResponseMessage(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	tracks, ok := sc.Sources[sq.Source]
+	if !ok {
+		ph.WriteResponseMessage(w, http.StatusNotFound, "no source: "+sq.Source)
+		return
+	}
+
+	// subset of the tracks, ex) ?track=111,112, all of them if not given
+	var ids []string
+	if trk := query.Get("track"); trk != "" {
+		ids = strings.Split(trk, ",")
+	}
+
+	rings, err := tracks.GetRings(ids...)
+	if err != nil {
+		ph.WriteResponseMessage(w, http.StatusNotFound, "no track: "+query.Get("track"))
+		return
+	}
 
 	switch r.Method {
 	case "POST": // for Caster
-		ring.Boundary, err = ph.GetTypeBoundary(r.Header.Get("Content-Type"))
+		boundary, err := ph.GetTypeBoundary(r.Header.Get("Content-Type"))
+		if err != nil {
+			ph.WriteResponseMessage(w, http.StatusBadRequest, err.Error())
+			break
+		}
+		for _, ring := range rings {
+			ring.SetBoundary(boundary)
+		}
+
+		// reordered only into the track given, ex) ?track=111&reorder=timestamp
+		ro, err := GetReorderFromQuery(query, rings[0])
+		if err != nil || (ro != nil && len(ids) != 1) {
+			ph.WriteResponseMessage(w, http.StatusBadRequest, "invalid reorder: "+query.Get("reorder"))
+			break
+		}
 
-		err = ph.ResponsePost(w, ring.Boundary)
+		err = ph.ResponsePost(w, boundary)
 		if err != nil {
 			log.Println(err)
 			break
 		}
 
-		mr := multipart.NewReader(r.Body, ring.Boundary)
+		mr := sr.NewPartReader(r.Body, boundary)
 
-		err = ph.ReadMultipartToRing(mr, ring)
+		// into the track given, or demultiplexed by the type of parts
+		if ro != nil {
+			err = ph.ReadMultipartToReorder(mr, ro)
+		} else if len(ids) == 1 {
+			err = ph.ReadMultipartToRing(mr, rings[0])
+		} else {
+			err = ph.ReadMultipartToTracks(mr, tracks)
+		}
+		ph.ClosePost(w)
 		if err != nil {
 			log.Println(err)
 			break
 		}
 
 	case "GET": // for Player
-		err = ph.ResponseGet(w, ring.Boundary)
+		// adapted to the player, ex) ?fps=5&maxkbps=500
+		th, err := ph.GetThrottleFromQuery(query, sc.Transcode)
+		if err != nil {
+			ph.WriteResponseMessage(w, http.StatusBadRequest, err.Error())
+			break
+		}
+
+		// start from the time given, ex) ?from=-5s
+		var ts int64
+		from := query.Get("from")
+		if from != "" {
+			ts, err = ph.GetTimestampFromParam(from)
+			if err != nil {
+				ph.WriteResponseMessage(w, http.StatusBadRequest, "invalid from: "+from)
+				break
+			}
+		}
+
+		// the tracks without the time are left out
+		var rrs []*sr.RingReader
+		var serr error
+		for _, ring := range rings {
+			if !ring.IsReadable() {
+				continue
+			}
+
+			rr := ring.NewRingReader()
+			if from != "" {
+				if err := rr.SeekTime(ts); err != nil {
+					serr = err
+					rr.Close()
+					continue
+				}
+			}
+			defer rr.Close()
+			rrs = append(rrs, rr)
+		}
+		if len(rrs) == 0 && serr != nil {
+			ph.WriteResponseMessage(w, GetSeekStatus(serr), "no stream at "+from)
+			break
+		}
+		if len(rrs) == 0 {
+			ph.WriteResponseMessage(w, http.StatusNotFound, "no stream in the tracks")
+			break
+		}
+
+		boundary := rrs[0].Ring.GetBoundary()
+
+		err = ph.ResponseGet(w, boundary)
 		if err != nil {
 			log.Println(err)
 			break
 		}
 
-		err = ph.WriteRingInMultipart(w, ring)
+		err = ph.WriteReadersInMultipartThrottled(r.Context(), w, rrs, boundary, th)
 		if err != nil {
 			log.Println(err)
 			break
@@ -890,6 +1306,218 @@ func (sc *ServerConfig) StreamHandler(w http.ResponseWriter, r *http.Request) {
 	return
 }
 
+//---------------------------------------------------------------------------
+// handle /stream/<name> access to the named ring, ex) /stream/lobby-cam
+// - POST : publish to the ring created if not exist, ex) ?num=30&size=1048576
+//          with the policy against lossless readers, ex) ?policy=block-writer&timeout=2s
+//          reordered for the latency if asked, ex) ?reorder=sequence&latency=200ms
+// - GET : play the ring from the time if given, ex) ?from=-5s&fps=5
+// - DELETE : remove the ring
+//---------------------------------------------------------------------------
+func (sc *ServerConfig) RingHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("handle /stream/ %s for %s to %s\n", r.Method, r.RequestURI, r.Host)
+	defer r.Body.Close()
+
+	var err error
+
+	query := r.URL.Query()
+
+	name, err := sr.GetRingNameFromPath(r.URL.Path, sr.STR_STREAM_PREFIX)
+	if err != nil {
+		ph.WriteResponseMessage(w, http.StatusBadRequest, "invalid ring name: "+r.URL.Path)
+		return
+	}
+
+	switch r.Method {
+	case "POST": // for Caster
+		boundary, err := ph.GetTypeBoundary(r.Header.Get("Content-Type"))
+		if err != nil {
+			ph.WriteResponseMessage(w, http.StatusBadRequest, err.Error())
+			break
+		}
+
+		num, _ := strconv.Atoi(query.Get("num"))
+		size, _ := strconv.Atoi(query.Get("size"))
+		policy, timeout, err := GetRingPolicy(query.Get("policy"), query.Get("timeout"), sr.POLICY_DROP_OLDEST)
+		if err != nil {
+			ph.WriteResponseMessage(w, http.StatusBadRequest, err.Error())
+			break
+		}
+
+		ring, _, err := sc.Rings.GetOrCreateWithSize(name, num, size)
+		if err != nil {
+			ph.WriteResponseMessage(w, http.StatusBadRequest, err.Error())
+			break
+		}
+		// taken before the answer not to be shared with another caster
+		err = ring.SetStatusUsing()
+		if err != nil {
+			ph.WriteResponseMessage(w, http.StatusConflict, "ring in use: "+name)
+			break
+		}
+		defer ring.SetStatusIdle()
+
+		ring.SetBoundary(boundary)
+		if query.Get("timeout") != "" && query.Get("policy") == "" {
+			policy = ring.GetPolicy()
+		}
+		if query.Get("policy") != "" || query.Get("timeout") != "" {
+			ring.SetPolicy(policy, timeout)
+		}
+		ro, err := GetReorderFromQuery(query, ring)
+		if err != nil {
+			ph.WriteResponseMessage(w, http.StatusBadRequest, err.Error())
+			break
+		}
+
+		err = ph.ResponsePost(w, boundary)
+		if err != nil {
+			log.Println(err)
+			break
+		}
+
+		mr := sr.NewPartReader(r.Body, boundary)
+
+		if ro != nil {
+			err = ph.ReadPartsToReorder(mr, ro)
+		} else {
+			_, err = ph.ReadPartsToRing(mr, ring)
+		}
+		ph.ClosePost(w)
+		if err != nil {
+			log.Println(err)
+			break
+		}
+
+	case "GET": // for Player
+		ring, err := sc.Rings.Get(name)
+		if err != nil {
+			ph.WriteResponseMessage(w, http.StatusNotFound, "no ring: "+name)
+			break
+		}
+
+		th, err := ph.GetThrottleFromQuery(query, sc.Transcode)
+		if err != nil {
+			ph.WriteResponseMessage(w, http.StatusBadRequest, err.Error())
+			break
+		}
+
+		var ts int64
+		from := query.Get("from")
+		if from != "" {
+			ts, err = ph.GetTimestampFromParam(from)
+			if err != nil {
+				ph.WriteResponseMessage(w, http.StatusBadRequest, "invalid from: "+from)
+				break
+			}
+		}
+
+		rr := ring.NewRingReader()
+		defer rr.Close()
+		if from != "" {
+			err = rr.SeekTime(ts)
+			if err != nil {
+				ph.WriteResponseMessage(w, GetSeekStatus(err), "no stream at "+from)
+				break
+			}
+		}
+
+		err = ph.ResponseGet(w, ring.GetBoundary())
+		if err != nil {
+			log.Println(err)
+			break
+		}
+
+		err = ph.WriteReaderInMultipartThrottled(r.Context(), w, rr, th)
+		if err != nil {
+			log.Println(err)
+			break
+		}
+
+	case "DELETE":
+		err = sc.Rings.Remove(name)
+		if err != nil {
+			ph.WriteResponseMessage(w, http.StatusNotFound, "no ring: "+name)
+			break
+		}
+		ph.WriteResponseMessage(w, http.StatusOK, "removed the ring: "+name)
+
+	default:
+		log.Println("Unknown request method: ", r.Method)
+	}
+
+	return
+}
+
+//---------------------------------------------------------------------------
+// handle /snapshot/<name> access to the newest slot of the ring as a still
+// - If-None-Match : not modified if the tag(epoch-sequence) is still the newest
+// - wait=1 : block until the newer slot than the tag or seq given, ex) ?seq=12
+//---------------------------------------------------------------------------
+func (sc *ServerConfig) SnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("handle /snapshot/ %s for %s to %s\n", r.Method, r.RequestURI, r.Host)
+
+	var err error
+
+	if r.Method != "GET" && r.Method != "HEAD" {
+		ph.WriteResponseMessage(w, http.StatusMethodNotAllowed, "not allowed: "+r.Method)
+		return
+	}
+
+	query := r.URL.Query()
+
+	name, err := sr.GetRingNameFromPath(r.URL.Path, sr.STR_SNAPSHOT_PREFIX)
+	if err != nil {
+		ph.WriteResponseMessage(w, http.StatusBadRequest, "invalid ring name: "+r.URL.Path)
+		return
+	}
+
+	ring, err := sc.Rings.Get(name)
+	if err != nil {
+		ph.WriteResponseMessage(w, http.StatusNotFound, "no ring: "+name)
+		return
+	}
+
+	// the slot given by the client, of no use once the ring is reset or restarted
+	epoch := ring.GetEpoch()
+	match := r.Header.Get("If-None-Match")
+	after, _ := ph.GetSeqFromTags(match, epoch)
+	if str := query.Get("seq"); str != "" {
+		after, err = strconv.ParseInt(str, 10, 64)
+		if err != nil {
+			ph.WriteResponseMessage(w, http.StatusBadRequest, "invalid seq: "+str)
+			return
+		}
+	}
+
+	slot := sr.NewStreamSlotFromPool(ring.Size)
+	defer slot.Free()
+
+	if query.Get("wait") == "1" {
+		err = ring.WaitSlotLatestTo(r.Context(), after, TIME_MAX_SNAPSHOT_WAIT, slot)
+		if err == sb.ErrTimeout {
+			err = ring.ReadSlotLatestTo(slot)
+		}
+	} else {
+		err = ring.ReadSlotLatestTo(slot)
+	}
+	if err != nil {
+		ph.WriteResponseMessage(w, http.StatusNotFound, "no slot in ring: "+name)
+		return
+	}
+
+	if match != "" && slot.Seq <= after && ring.GetEpoch() == epoch {
+		w.Header().Set("ETag", ph.GetSlotTag(epoch, slot))
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	err = ph.ResponseSlot(w, r.Method, epoch, slot)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
 //---------------------------------------------------------------------------
 // serve http access
 //---------------------------------------------------------------------------
@@ -905,7 +1533,11 @@ func (sc *ServerConfig) ServeHttp(wg *sync.WaitGroup) {
 		//WriteTimeout: 30 * time.Second,
 	}
 
-	log.Fatal(srv.ListenAndServe())
+	sc.addServer(srv)
+	err := srv.ListenAndServe()
+	if err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
 }
 
 //---------------------------------------------------------------------------
@@ -923,7 +1555,11 @@ func (sc *ServerConfig) ServeHttps(wg *sync.WaitGroup) {
 		//WriteTimeout: 30 * time.Second,
 	}
 
-	log.Fatal(srv.ListenAndServeTLS("sec/cert.pem", "sec/key.pem"))
+	sc.addServer(srv)
+	err := srv.ListenAndServeTLS("sec/cert.pem", "sec/key.pem")
+	if err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
 }
 
 //---------------------------------------------------------------------------
@@ -942,7 +1578,11 @@ func (sc *ServerConfig) ServeHttp2(wg *sync.WaitGroup) {
 	}
 
 	http2.ConfigureServer(srv, &http2.Server{})
-	log.Fatal(srv.ListenAndServeTLS("sec/cert.pem", "sec/key.pem"))
+	sc.addServer(srv)
+	err := srv.ListenAndServeTLS("sec/cert.pem", "sec/key.pem")
+	if err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
 }
 
 //---------------------------------------------------------------------------
@@ -961,7 +1601,11 @@ func (sc *ServerConfig) ServeWs(wg *sync.WaitGroup) {
 		//WriteTimeout: 30 * time.Second,
 	}
 
-	log.Fatal(srv.ListenAndServe())
+	sc.addServer(srv)
+	err := srv.ListenAndServe()
+	if err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
 }
 
 //---------------------------------------------------------------------------
@@ -979,7 +1623,11 @@ func (sc *ServerConfig) ServeWss(wg *sync.WaitGroup) {
 		//WriteTimeout: 30 * time.Second,
 	}
 
-	log.Fatal(srv.ListenAndServeTLS("sec/cert.pem", "sec/key.pem"))
+	sc.addServer(srv)
+	err := srv.ListenAndServeTLS("sec/cert.pem", "sec/key.pem")
+	if err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
 }
 
 //---------------------------------------------------------------------------
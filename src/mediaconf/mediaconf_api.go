@@ -0,0 +1,892 @@
+//=========================================================================
+// Author : Stoney Kang, sikang99@gmail.com, 2015
+// JSON API of the server control, versioned by the path, ex) /api/v1/rings
+// - rings    : GET, POST to create, GET/PATCH/DELETE of a ring by the name
+// - actors   : GET, POST to start, GET/DELETE of an actor by the id
+// - channels : GET, GET of a channel by the id
+// - errors are given in JSON with the status, ex) {"status":404,"error":"..."}
+//=========================================================================
+
+package mediaconf
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	pb "stoney/httpserver/src/protobase"
+	pf "stoney/httpserver/src/protofile"
+	ph "stoney/httpserver/src/protohttp"
+	pt "stoney/httpserver/src/prototcp"
+
+	sb "stoney/httpserver/src/streambase"
+	sr "stoney/httpserver/src/streamring"
+)
+
+//---------------------------------------------------------------------------
+const (
+	STR_API_PREFIX = "/api/v1/"
+
+	LEN_MAX_API_BODY = 64 * sb.KBYTE // max size of a request body
+)
+
+//===========================================================================
+// error of the API with the status of HTTP
+//---------------------------------------------------------------------------
+type ApiError struct {
+	Status  int    `json:"status"`
+	Message string `json:"error"`
+}
+
+func (e *ApiError) Error() string {
+	return e.Message
+}
+
+func NewApiError(status int, format string, args ...interface{}) error {
+	return &ApiError{Status: status, Message: fmt.Sprintf(format, args...)}
+}
+
+//---------------------------------------------------------------------------
+// get the error of the API for the error, by the kind of the common errors
+//---------------------------------------------------------------------------
+func GetApiError(err error) *ApiError {
+	if ae, ok := err.(*ApiError); ok {
+		return ae
+	}
+
+	status := http.StatusInternalServerError
+	switch err {
+	case sb.ErrFound:
+		status = http.StatusNotFound
+	case sb.ErrValue, sb.ErrParse, sb.ErrSize, sb.ErrSupport:
+		status = http.StatusBadRequest
+	case sb.ErrStatus:
+		status = http.StatusConflict
+	}
+
+	return &ApiError{Status: status, Message: err.Error()}
+}
+
+//---------------------------------------------------------------------------
+// write the error in JSON
+//---------------------------------------------------------------------------
+func WriteApiError(w http.ResponseWriter, err error) error {
+	ae := GetApiError(err)
+	return ph.WriteResponseJson(w, ae.Status, ae)
+}
+
+// the methods allowed are given with the error
+
+func writeApiNotAllowed(w http.ResponseWriter, r *http.Request, allow string) {
+	w.Header().Set("Allow", allow)
+	WriteApiError(w, NewApiError(http.StatusMethodNotAllowed, "not allowed: %s", r.Method))
+}
+
+//---------------------------------------------------------------------------
+// read the request body in JSON, unknown fields are not allowed
+//---------------------------------------------------------------------------
+func ReadApiRequest(r *http.Request, v interface{}) error {
+	dec := json.NewDecoder(io.LimitReader(r.Body, LEN_MAX_API_BODY))
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(v)
+	if err != nil {
+		return NewApiError(http.StatusBadRequest, "invalid body: %s", err)
+	}
+
+	return nil
+}
+
+//---------------------------------------------------------------------------
+// get the name of the item following the prefix, empty for the collection
+//---------------------------------------------------------------------------
+func getApiItem(path string, prefix string) string {
+	return strings.Trim(strings.TrimPrefix(path, prefix), "/")
+}
+
+//===========================================================================
+// ring information
+//---------------------------------------------------------------------------
+type RingInfo struct {
+	Name      string       `json:"name"`
+	Status    string       `json:"status"`
+	Num       int          `json:"num"` // number of slots used
+	Max       int          `json:"max"` // number of slots allocated
+	Size      int          `json:"size"`
+	Mode      string       `json:"mode"` // copy, shared or bytes
+	Seq       int64        `json:"seq"`
+	Policy    string       `json:"policy"`
+	Timeout   string       `json:"timeout,omitempty"`   // max time to block the writer
+	Retention string       `json:"retention,omitempty"` // time window of slots kept
+	Boundary  string       `json:"boundary"`
+	Desc      string       `json:"desc"`
+	Pinned    bool         `json:"pinned"` // not to be removed
+	Spill     bool         `json:"spill"`
+	Stats     sr.RingStats `json:"stats"`
+}
+
+//---------------------------------------------------------------------------
+// request for a ring, to create by POST or to change by PATCH
+//---------------------------------------------------------------------------
+type RingRequest struct {
+	Name      string  `json:"name,omitempty"`      // to create only
+	Num       int     `json:"num,omitempty"`       // number of slots
+	Size      int     `json:"size,omitempty"`      // to create only
+	Mode      string  `json:"mode,omitempty"`      // to create only, copy, shared or bytes
+	Status    string  `json:"status,omitempty"`    // idle to close, using to open
+	Spill     *string `json:"spill,omitempty"`     // directory to spill, empty to stop
+	Policy    string  `json:"policy,omitempty"`    // against lossless readers, ex) block-writer
+	Timeout   string  `json:"timeout,omitempty"`   // max time to block the writer, ex) 2s
+	Retention string  `json:"retention,omitempty"` // time window of slots kept, ex) 30s, 0 for no limit
+}
+
+//---------------------------------------------------------------------------
+// get the information of the ring
+//---------------------------------------------------------------------------
+func (sc *ServerConfig) GetRingInfo(name string, ring *sr.StreamRing) *RingInfo {
+	ri := &RingInfo{
+		Name:   name,
+		Pinned: sc.Rings.IsPinned(name),
+		Stats:  ring.Stats(),
+	}
+
+	ring.Lock()
+	ri.Status = sb.StatusText[ring.Status]
+	ri.Num = ring.Num
+	ri.Max = ring.NumMax
+	ri.Size = ring.Size
+	ri.Mode = sr.RingModeText[ring.GetMode()]
+	ri.Seq = ring.Seq
+	ri.Policy = sr.PolicyText[ring.Policy]
+	if ring.Timeout > 0 {
+		ri.Timeout = ring.Timeout.String()
+	}
+	if ring.Retention > 0 {
+		ri.Retention = ring.Retention.String()
+	}
+	ri.Boundary = ring.Boundary
+	ri.Desc = ring.Desc
+	ri.Spill = ring.Spill != nil
+	ring.Unlock()
+
+	return ri
+}
+
+//---------------------------------------------------------------------------
+// list the rings in the order of the name
+//---------------------------------------------------------------------------
+func (sc *ServerConfig) ListRings() []RingInfo {
+	infos := []RingInfo{}
+	for _, name := range sc.Rings.Names() {
+		ring, err := sc.Rings.Get(name)
+		if err != nil {
+			continue // removed in the meantime
+		}
+		infos = append(infos, *sc.GetRingInfo(name, ring))
+	}
+	return infos
+}
+
+//---------------------------------------------------------------------------
+// create the ring of the name, in conflict if it exists
+//---------------------------------------------------------------------------
+func (sc *ServerConfig) CreateRing(req *RingRequest) (*RingInfo, error) {
+	if req.Name == "" {
+		return nil, NewApiError(http.StatusBadRequest, "no name of the ring")
+	}
+	if err := sr.CheckRingName(req.Name); err != nil {
+		return nil, NewApiError(http.StatusBadRequest, "invalid name: %s", req.Name)
+	}
+
+	// checked before created not to leave the ring half made
+	policy, timeout, err := GetRingPolicy(req.Policy, req.Timeout, sr.POLICY_DROP_OLDEST)
+	if err != nil {
+		return nil, NewApiError(http.StatusBadRequest, "%s", err)
+	}
+	keep, err := GetRingRetention(req.Retention, sc.Rings.Retention)
+	if err != nil {
+		return nil, NewApiError(http.StatusBadRequest, "%s", err)
+	}
+	mode := -1
+	if req.Mode != "" {
+		mode, err = sr.GetRingModeByName(req.Mode)
+		if err != nil {
+			return nil, NewApiError(http.StatusBadRequest, "%s", err)
+		}
+	}
+
+	ring, created, err := sc.Rings.GetOrCreateWithMode(req.Name, mode, req.Num, req.Size)
+	if err != nil {
+		return nil, err
+	}
+	if !created {
+		return nil, NewApiError(http.StatusConflict, "ring %s exists", req.Name)
+	}
+	ring.SetPolicy(policy, timeout)
+	ring.SetRetention(keep)
+
+	return sc.GetRingInfo(req.Name, ring), nil
+}
+
+//---------------------------------------------------------------------------
+// change the ring of the name by the request
+//---------------------------------------------------------------------------
+func (sc *ServerConfig) UpdateRing(name string, req *RingRequest) (*RingInfo, error) {
+	var err error
+
+	ring, err := sc.Rings.Get(name)
+	if err != nil {
+		return nil, NewApiError(http.StatusNotFound, "no ring named %s", name)
+	}
+
+	if req.Name != "" && req.Name != name || req.Size != 0 || req.Mode != "" {
+		return nil, NewApiError(http.StatusBadRequest, "name, size and mode can not be changed")
+	}
+
+	// all are checked before any is changed, not to be applied in part
+	policy, timeout, err := GetRingPolicy(req.Policy, req.Timeout, ring.GetPolicy())
+	if err != nil {
+		return nil, NewApiError(http.StatusBadRequest, "%s", err)
+	}
+
+	keep, err := GetRingRetention(req.Retention, 0)
+	if err != nil {
+		return nil, NewApiError(http.StatusBadRequest, "%s", err)
+	}
+
+	if req.Num != 0 {
+		if req.Num < 2 || req.Num > sr.NUM_MAX_SLOTS {
+			return nil, NewApiError(http.StatusBadRequest, "%s %d is invalid, use a number between 2 - %d", name, req.Num, sr.NUM_MAX_SLOTS)
+		}
+		if ring.IsBytes() {
+			return nil, NewApiError(http.StatusBadRequest, "%s %s", name, sb.ErrSupport)
+		}
+	}
+
+	status := strings.ToLower(req.Status)
+	if status != "" && status != "idle" && status != "using" {
+		return nil, NewApiError(http.StatusBadRequest, "invalid status: %s", req.Status)
+	}
+
+	var spill *pf.SpillFile
+	if req.Spill != nil {
+		if *req.Spill == "" {
+			if ring.Spill == nil {
+				return nil, NewApiError(http.StatusConflict, "no spill of the ring")
+			}
+		} else {
+			spill, err = pf.NewSpillFile(*req.Spill, ring.GetBoundary(), pf.NUM_DEF_SEG_SLOTS, pf.NUM_DEF_SEG_FILES)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// the status is the only one that may fail by others, so it goes first
+	switch status {
+	case "idle":
+		err = ring.SetStatusIdle()
+	case "using":
+		err = ring.SetStatusUsing()
+	}
+	if err != nil {
+		if spill != nil {
+			spill.Close()
+		}
+		return nil, NewApiError(http.StatusConflict, "%s %s", name, err)
+	}
+
+	if req.Policy != "" || req.Timeout != "" {
+		ring.SetPolicy(policy, timeout)
+	}
+
+	if req.Retention != "" {
+		ring.SetRetention(keep)
+	}
+
+	if req.Num != 0 {
+		ring.Resize(req.Num)
+	}
+
+	if req.Spill != nil {
+		sc.setRingSpill(ring, spill)
+	}
+
+	return sc.GetRingInfo(name, ring), nil
+}
+
+//---------------------------------------------------------------------------
+// get the policy by the name and the timeout in duration, ex) block-writer, 2s,
+// the policy given as def if no name
+//---------------------------------------------------------------------------
+func GetRingPolicy(name string, timeout string, def int) (int, time.Duration, error) {
+	var err error
+
+	policy := def
+	if name != "" {
+		policy, err = sr.GetPolicyByName(name)
+		if err != nil {
+			return def, 0, err
+		}
+	}
+
+	var d time.Duration
+	if timeout != "" {
+		d, err = time.ParseDuration(timeout)
+		if err != nil || d < 0 {
+			return def, 0, fmt.Errorf("invalid timeout: %s", timeout)
+		}
+	}
+
+	return policy, d, nil
+}
+
+//---------------------------------------------------------------------------
+// get the time window of slots kept in duration, ex) 30s, def if empty
+//---------------------------------------------------------------------------
+func GetRingRetention(str string, def time.Duration) (time.Duration, error) {
+	if str == "" {
+		return def, nil
+	}
+
+	d, err := time.ParseDuration(str)
+	if err != nil || d < 0 {
+		return def, fmt.Errorf("invalid retention: %s", str)
+	}
+
+	return d, nil
+}
+
+//---------------------------------------------------------------------------
+// replace the spill of the ring by the one given, or stop it if nil
+//---------------------------------------------------------------------------
+func (sc *ServerConfig) setRingSpill(ring *sr.StreamRing, spill *pf.SpillFile) {
+	sp := ring.Spill
+	// not to be kept as a nil of the type, not nil in the interface
+	if spill != nil {
+		ring.SetSpill(spill)
+	} else {
+		ring.SetSpill(nil)
+	}
+
+	if sf, ok := sp.(*pf.SpillFile); ok && sf != spill {
+		sf.Close()
+	}
+}
+
+//---------------------------------------------------------------------------
+// remove the ring of the name, not the rings pinned by the server
+//---------------------------------------------------------------------------
+func (sc *ServerConfig) RemoveRing(name string) error {
+	if sc.Rings.IsPinned(name) {
+		return NewApiError(http.StatusConflict, "ring %s is pinned, set it idle instead", name)
+	}
+
+	err := sc.Rings.Remove(name)
+	if err != nil {
+		return NewApiError(http.StatusNotFound, "no ring named %s", name)
+	}
+
+	return nil
+}
+
+//===========================================================================
+// actor information
+//---------------------------------------------------------------------------
+type ActorInfo struct {
+	Id         string     `json:"id"`
+	Status     string     `json:"status"`
+	Desc       string     `json:"desc"`
+	Reconnects int        `json:"reconnects,omitempty"`
+	LastError  string     `json:"error,omitempty"` // last error to reconnect by
+	LastTime   *time.Time `json:"time,omitempty"`
+}
+
+//---------------------------------------------------------------------------
+// request to start an actor, the fields used depend on the type
+// - http_reader, http_caster : url, ring
+// - dir_reader, file_reader, file_writer : file, ring
+// - tcp_server, tcp_caster : port, ring
+// - combiner : mode, rings, out, fps
+//---------------------------------------------------------------------------
+type ActorRequest struct {
+	Type  string   `json:"type"`
+	Ring  string   `json:"ring,omitempty"` // name of the ring, ex) 0, lobby-cam
+	Url   string   `json:"url,omitempty"`
+	File  string   `json:"file,omitempty"`
+	Port  string   `json:"port,omitempty"`
+	Mode  string   `json:"mode,omitempty"`
+	Rings []string `json:"rings,omitempty"`
+	Out   string   `json:"out,omitempty"`
+	Fps   int      `json:"fps,omitempty"`
+}
+
+var ActorTypes = []string{"http_reader", "http_caster", "dir_reader", "file_reader", "file_writer", "tcp_server", "tcp_caster", "combiner"}
+
+//---------------------------------------------------------------------------
+// get the information of the actor
+//---------------------------------------------------------------------------
+func GetActorInfo(actor *pb.ProtoBase) *ActorInfo {
+	lastError, lastTime := actor.GetLastError()
+
+	ai := &ActorInfo{
+		Id:         actor.Id,
+		Status:     sb.StatusText[actor.GetStatus()],
+		Desc:       actor.Desc,
+		Reconnects: actor.GetReconnects(),
+		LastError:  lastError,
+	}
+	if !lastTime.IsZero() {
+		ai.LastTime = &lastTime
+	}
+	return ai
+}
+
+//---------------------------------------------------------------------------
+// keep the actor started by its id
+//---------------------------------------------------------------------------
+func (sc *ServerConfig) AddActor(actor *pb.ProtoBase) {
+	sc.actorsMu.Lock()
+	defer sc.actorsMu.Unlock()
+
+	sc.actors[actor.Id] = actor
+}
+
+//---------------------------------------------------------------------------
+// get the actor of the id, nil if none
+//---------------------------------------------------------------------------
+func (sc *ServerConfig) GetActor(id string) *pb.ProtoBase {
+	sc.actorsMu.Lock()
+	defer sc.actorsMu.Unlock()
+
+	return sc.actors[id]
+}
+
+//---------------------------------------------------------------------------
+// get the actors kept, in no order
+//---------------------------------------------------------------------------
+func (sc *ServerConfig) GetActors() []*pb.ProtoBase {
+	sc.actorsMu.Lock()
+	defer sc.actorsMu.Unlock()
+
+	actors := make([]*pb.ProtoBase, 0, len(sc.actors))
+	for _, actor := range sc.actors {
+		actors = append(actors, actor)
+	}
+	return actors
+}
+
+//---------------------------------------------------------------------------
+// forget the actors over and idle, and get all the actors before it
+//---------------------------------------------------------------------------
+func (sc *ServerConfig) RemoveIdleActors() []*pb.ProtoBase {
+	sc.actorsMu.Lock()
+	defer sc.actorsMu.Unlock()
+
+	actors := make([]*pb.ProtoBase, 0, len(sc.actors))
+	for id, actor := range sc.actors {
+		if actor.GetStatus() == sb.STATUS_IDLE {
+			delete(sc.actors, id)
+		}
+		actors = append(actors, actor)
+	}
+	return actors
+}
+
+//---------------------------------------------------------------------------
+// list the actors in the order of the id, when they are started
+//---------------------------------------------------------------------------
+func (sc *ServerConfig) ListActors() []ActorInfo {
+	infos := []ActorInfo{}
+	for _, actor := range sc.GetActors() {
+		infos = append(infos, *GetActorInfo(actor))
+	}
+
+	// ids are made of the time in nano seconds
+	sort.Slice(infos, func(i, j int) bool {
+		a, b := infos[i].Id, infos[j].Id
+		return len(a) < len(b) || len(a) == len(b) && a < b
+	})
+
+	return infos
+}
+
+//---------------------------------------------------------------------------
+// start the actor of the request, and return it
+//---------------------------------------------------------------------------
+func (sc *ServerConfig) StartActor(req *ActorRequest) (*pb.ProtoBase, error) {
+	var err error
+
+	need := func(name string, value string) error {
+		if value == "" {
+			return NewApiError(http.StatusBadRequest, "no %s for %s", name, req.Type)
+		}
+		return nil
+	}
+
+	// the ring to act on, except the combiner making its own
+	var ring *sr.StreamRing
+	if req.Type != "combiner" {
+		if err = need("ring", req.Ring); err != nil {
+			return nil, err
+		}
+		ring, err = sc.Rings.Get(req.Ring)
+		if err != nil {
+			return nil, NewApiError(http.StatusNotFound, "no ring named %s", req.Ring)
+		}
+	}
+
+	var actor *pb.ProtoBase
+
+	switch req.Type {
+	case "http_reader", "http_caster":
+		if err = need("url", req.Url); err != nil {
+			return nil, err
+		}
+		np := ph.NewProtoHttpWithUrl(req.Url)
+		actor = np.Base
+		actor.SetStatusRun()
+		if req.Type == "http_reader" {
+			actor.Desc = fmt.Sprintf("http reader (%s -> %s)", req.Url, req.Ring)
+			go sc.StreamReader(actor, ring, req.Url)
+		} else {
+			actor.Desc = fmt.Sprintf("http caster (%s -> %s)", req.Ring, req.Url)
+			go sc.StreamCaster(actor, ring, req.Url)
+		}
+
+	case "dir_reader", "file_reader", "file_writer":
+		if err = need("file", req.File); err != nil {
+			return nil, err
+		}
+		np := pf.NewProtoFile(req.File)
+		actor = np.Base
+		actor.Desc = fmt.Sprintf("%s (%s, %s)", strings.Replace(req.Type, "_", " ", 1), req.File, req.Ring)
+		switch req.Type {
+		case "dir_reader":
+			go np.DirReader(ring, true)
+		case "file_reader":
+			go np.StreamReader(ring)
+		case "file_writer":
+			go func() {
+				np.StreamWriter(ring)
+				sc.InvalidateRecordings()
+			}()
+		}
+
+	case "tcp_server", "tcp_caster":
+		if err = need("port", req.Port); err != nil {
+			return nil, err
+		}
+		np := pt.NewProtoTcp("localhost", req.Port, "T-Rx")
+		actor = np.Base
+		actor.Desc = fmt.Sprintf("%s (%s, %s)", strings.Replace(req.Type, "_", " ", 1), req.Port, req.Ring)
+		if req.Type == "tcp_server" {
+			np.Rings = sc.Rings
+			go np.StreamServer(ring)
+		} else {
+			go np.StreamCaster()
+		}
+
+	case "combiner":
+		mode := sr.COMBINE_INTERLEAVE
+		if req.Mode != "" {
+			mode, err = sr.GetCombineByName(req.Mode)
+			if err != nil {
+				return nil, NewApiError(http.StatusBadRequest, "%s", err)
+			}
+		}
+
+		if len(req.Rings) == 0 {
+			return nil, NewApiError(http.StatusBadRequest, "no rings for %s", req.Type)
+		}
+		var ins []*sr.StreamRing
+		for _, name := range req.Rings {
+			in, err := sc.Rings.Get(name)
+			if err != nil {
+				return nil, NewApiError(http.StatusNotFound, "no ring named %s", name)
+			}
+			ins = append(ins, in)
+		}
+
+		out, _, err := sc.Rings.GetOrCreate(req.Out)
+		if err != nil {
+			return nil, NewApiError(http.StatusBadRequest, "invalid out: %s", req.Out)
+		}
+
+		rc := sr.NewRingCombiner(mode, out, ins...)
+		if req.Fps > 0 {
+			rc.Fps = req.Fps
+		}
+
+		actor = pb.NewProtoBase()
+		actor.Desc = fmt.Sprintf("%s combiner (%s -> %s)", sr.CombineText[mode], strings.Join(req.Rings, ","), req.Out)
+		actor.SetStatusRun()
+		go sc.StreamCombiner(actor, rc)
+
+	default:
+		return nil, NewApiError(http.StatusBadRequest, "invalid type: %s, one of %s", req.Type, strings.Join(ActorTypes, ","))
+	}
+
+	sc.AddActor(actor)
+
+	return actor, nil
+}
+
+//---------------------------------------------------------------------------
+// stop the actor of the id, ErrStatus if not running
+//---------------------------------------------------------------------------
+func (sc *ServerConfig) StopActor(id string) error {
+	actor := sc.GetActor(id)
+	if actor == nil {
+		return NewApiError(http.StatusNotFound, "no actor %s", id)
+	}
+
+	err := actor.SetStatusClose()
+	if err != nil {
+		return NewApiError(http.StatusConflict, "actor %s is not running", id)
+	}
+
+	return nil
+}
+
+//===========================================================================
+// channel information with the sources and tracks
+//---------------------------------------------------------------------------
+type ChannelInfo struct {
+	Id      string       `json:"id"`
+	Name    string       `json:"name"`
+	Desc    string       `json:"desc"`
+	Status  string       `json:"status"`
+	Time    time.Time    `json:"time"`
+	Sources []SourceInfo `json:"sources"`
+}
+
+type SourceInfo struct {
+	Id     string      `json:"id"`
+	Desc   string      `json:"desc"`
+	Status string      `json:"status,omitempty"` // using if any track is
+	Time   time.Time   `json:"time"`
+	Tracks []TrackInfo `json:"tracks"`
+}
+
+type TrackInfo struct {
+	Id     string `json:"id"`
+	Type   string `json:"type"`
+	Desc   string `json:"desc"`
+	Ring   string `json:"ring"` // name of the ring, ex) 100/110/111
+	Status string `json:"status,omitempty"`
+}
+
+//---------------------------------------------------------------------------
+// list the channels of the station
+//---------------------------------------------------------------------------
+func (sc *ServerConfig) ListChannels() []ChannelInfo {
+	infos := []ChannelInfo{}
+
+	for _, chn := range sc.Station {
+		ci := ChannelInfo{
+			Id:      chn.Id,
+			Name:    chn.Name,
+			Desc:    chn.Desc,
+			Status:  sb.StatusText[chn.Status],
+			Time:    chn.Time,
+			Sources: []SourceInfo{},
+		}
+
+		for i := range chn.Srcs {
+			src := &chn.Srcs[i]
+			st := sc.Sources[src.Id]
+
+			so := SourceInfo{
+				Id:     src.Id,
+				Desc:   src.Desc,
+				Time:   src.Time,
+				Tracks: []TrackInfo{},
+			}
+			if st != nil {
+				so.Status = sb.StatusText[sb.STATUS_IDLE]
+				if st.IsUsing() {
+					so.Status = sb.StatusText[sb.STATUS_USING]
+				}
+			}
+
+			for j := range src.Trks {
+				trk := &src.Trks[j]
+
+				ti := TrackInfo{
+					Id:   trk.Id,
+					Type: trk.Type,
+					Desc: trk.Desc,
+					Ring: sr.GetRingPath(chn.Id, src.Id, trk.Id),
+				}
+				if st != nil {
+					if ring, err := st.GetRing(trk.Id); err == nil {
+						ti.Status = sb.StatusText[ring.GetStatus()]
+					}
+				}
+
+				so.Tracks = append(so.Tracks, ti)
+			}
+
+			ci.Sources = append(ci.Sources, so)
+		}
+
+		infos = append(infos, ci)
+	}
+
+	return infos
+}
+
+//===========================================================================
+// handle /api/v1/rings and /api/v1/rings/<name>
+//---------------------------------------------------------------------------
+func (sc *ServerConfig) ApiRingsHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("handle /api/v1/rings %s for %s to %s\n", r.Method, r.RequestURI, r.Host)
+	defer r.Body.Close()
+
+	var err error
+	var ri *RingInfo
+
+	name := getApiItem(r.URL.Path, STR_API_PREFIX+"rings")
+
+	switch {
+	case name == "" && (r.Method == "GET" || r.Method == "HEAD"):
+		ph.WriteResponseJson(w, http.StatusOK, sc.ListRings())
+		return
+
+	case name == "" && r.Method == "POST":
+		req := &RingRequest{}
+		if err = ReadApiRequest(r, req); err == nil {
+			ri, err = sc.CreateRing(req)
+		}
+		if err == nil {
+			w.Header().Set("Location", STR_API_PREFIX+"rings/"+ri.Name)
+			ph.WriteResponseJson(w, http.StatusCreated, ri)
+			return
+		}
+
+	case name == "":
+		writeApiNotAllowed(w, r, "GET, HEAD, POST")
+		return
+
+	case r.Method == "GET" || r.Method == "HEAD":
+		ring, gerr := sc.Rings.Get(name)
+		if gerr == nil {
+			ph.WriteResponseJson(w, http.StatusOK, sc.GetRingInfo(name, ring))
+			return
+		}
+		err = NewApiError(http.StatusNotFound, "no ring named %s", name)
+
+	case r.Method == "PATCH":
+		req := &RingRequest{}
+		if err = ReadApiRequest(r, req); err == nil {
+			ri, err = sc.UpdateRing(name, req)
+		}
+		if err == nil {
+			ph.WriteResponseJson(w, http.StatusOK, ri)
+			return
+		}
+
+	case r.Method == "DELETE":
+		if err = sc.RemoveRing(name); err == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+	default:
+		writeApiNotAllowed(w, r, "GET, HEAD, PATCH, DELETE")
+		return
+	}
+
+	WriteApiError(w, err)
+}
+
+//---------------------------------------------------------------------------
+// handle /api/v1/actors and /api/v1/actors/<id>
+//---------------------------------------------------------------------------
+func (sc *ServerConfig) ApiActorsHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("handle /api/v1/actors %s for %s to %s\n", r.Method, r.RequestURI, r.Host)
+	defer r.Body.Close()
+
+	var err error
+	var actor *pb.ProtoBase
+
+	id := getApiItem(r.URL.Path, STR_API_PREFIX+"actors")
+
+	switch {
+	case id == "" && (r.Method == "GET" || r.Method == "HEAD"):
+		ph.WriteResponseJson(w, http.StatusOK, sc.ListActors())
+		return
+
+	case id == "" && r.Method == "POST":
+		req := &ActorRequest{}
+		if err = ReadApiRequest(r, req); err == nil {
+			actor, err = sc.StartActor(req)
+		}
+		if err == nil {
+			w.Header().Set("Location", STR_API_PREFIX+"actors/"+actor.Id)
+			ph.WriteResponseJson(w, http.StatusCreated, GetActorInfo(actor))
+			return
+		}
+
+	case id == "":
+		writeApiNotAllowed(w, r, "GET, HEAD, POST")
+		return
+
+	case r.Method == "GET" || r.Method == "HEAD":
+		if actor = sc.GetActor(id); actor != nil {
+			ph.WriteResponseJson(w, http.StatusOK, GetActorInfo(actor))
+			return
+		}
+		err = NewApiError(http.StatusNotFound, "no actor %s", id)
+
+	case r.Method == "DELETE":
+		if err = sc.StopActor(id); err == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+	default:
+		writeApiNotAllowed(w, r, "GET, HEAD, DELETE")
+		return
+	}
+
+	WriteApiError(w, err)
+}
+
+//---------------------------------------------------------------------------
+// handle /api/v1/channels and /api/v1/channels/<id>, read only
+//---------------------------------------------------------------------------
+func (sc *ServerConfig) ApiChannelsHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("handle /api/v1/channels %s for %s to %s\n", r.Method, r.RequestURI, r.Host)
+	defer r.Body.Close()
+
+	if r.Method != "GET" && r.Method != "HEAD" {
+		writeApiNotAllowed(w, r, "GET, HEAD")
+		return
+	}
+
+	infos := sc.ListChannels()
+
+	id := getApiItem(r.URL.Path, STR_API_PREFIX+"channels")
+	if id == "" {
+		ph.WriteResponseJson(w, http.StatusOK, infos)
+		return
+	}
+
+	for i := range infos {
+		if infos[i].Id == id {
+			ph.WriteResponseJson(w, http.StatusOK, &infos[i])
+			return
+		}
+	}
+
+	WriteApiError(w, NewApiError(http.StatusNotFound, "no channel %s", id))
+}
+
+// ---------------------------------E-----N-----D--------------------------------
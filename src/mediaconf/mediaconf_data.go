@@ -7,6 +7,9 @@ package mediaconf
 
 import (
 	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
 	"time"
 
 	pb "stoney/httpserver/src/protobase"
@@ -16,6 +19,27 @@ import (
 	sr "stoney/httpserver/src/streamring"
 )
 
+//---------------------------------------------------------------------------
+const (
+	TIME_MAX_SNAPSHOT_WAIT = 10 * time.Second // max time to long-poll a newer still
+	TIME_MAX_SHUTDOWN      = 5 * time.Second  // max time to wait for the requests at shutdown
+
+	LEN_MAX_UPLOAD       = 32 * sb.MBYTE // max size of a file uploaded
+	NUM_MAX_UPLOAD_FILES = 32            // max files in an upload
+	STR_UPLOAD_DIR       = "upload"      // directory under the media root by default
+
+	TIME_DEF_MEDIA_INTERVAL = time.Second           // interval of images streamed in a directory
+	TIME_MIN_MEDIA_INTERVAL = 10 * time.Millisecond // min interval not to be flooded
+	STR_DEF_MEDIA_PATTERN   = "*.jpg"               // images streamed in a directory by default
+	STR_MEDIA_ROOT          = "media"               // directory of the media store by default
+
+	NUM_DEF_SEARCH_LIMIT = 50   // results in a page by default
+	NUM_MAX_SEARCH_LIMIT = 1000 // max results in a page
+
+	STR_RECORD_DIR        = "record"         // directory of the recordings under the media root
+	TIME_MAX_RECORD_CACHE = 10 * time.Second // max time to keep the recordings listed, for the files growing
+)
+
 //---------------------------------------------------------------------------
 var index_tmpl = `<!DOCTYPE html>
 <html>
@@ -65,19 +89,29 @@ var hello_tmpl = `<!DOCTYPE html>
 // - http://stackoverflow.com/questions/31014838/parsing-json-into-a-struct
 //-----------------------------------------------------------------------------
 type ServerConfig struct {
-	Title    string `json:"title"`
-	Image    string
-	Url      string
-	Addr     string
-	Host     string
-	Port     string
-	PortS    string
-	Port2    string
-	Mode     string
-	Array    []*sr.StreamRing
-	Station  []*si.Channel
-	Actors   map[string]*pb.ProtoBase
-	NotiChan chan []byte
+	Title     string `json:"title"`
+	Image     string
+	Url       string
+	Addr      string
+	Host      string
+	Port      string
+	PortS     string
+	Port2     string
+	Mode      string
+	Array     []*sr.StreamRing
+	Station   []*si.Channel
+	Sources   map[string]*sr.StreamTracks // rings of tracks by source id
+	Rings     *sr.RingRegistry            // rings by name or channel/source/track
+	actors    map[string]*pb.ProtoBase    // actors by id, use the accessors
+	actorsMu  sync.Mutex
+	Root      string // root directory of the media store
+	UploadMax int64  // max size of a file uploaded
+	SnapDir   string // directory of ring snapshots, none if empty
+	Transcode bool   // players may ask JPEG re-encoded, ex) ?quality=30&scale=2
+	NotiChan  chan []byte
+	records   recordCache    // recordings listed for the search
+	servers   []*http.Server // servers listening, closed by Shutdown
+	serversMu sync.Mutex
 	// http://giantmachines.tumblr.com/post/52184842286/golang-http-client-with-timeouts
 	ConnectTimeout   time.Duration
 	ReadWriteTimeout time.Duration
@@ -100,7 +134,9 @@ func (sc *ServerConfig) String() string {
 func NewServerConfig() *ServerConfig {
 	sc := &ServerConfig{
 		NotiChan: make(chan []byte, 2),
-		Actors:   make(map[string]*pb.ProtoBase),
+		actors:   make(map[string]*pb.ProtoBase),
+		Sources:  make(map[string]*sr.StreamTracks),
+		Rings:    sr.NewRingRegistry(3, sb.MBYTE, sr.TIME_DEF_IDLE),
 	}
 
 	sc.Title = "Happy Media System"
@@ -110,8 +146,35 @@ func NewServerConfig() *ServerConfig {
 	sc.Port = sb.STR_DEF_PORT
 	sc.PortS = sb.STR_DEF_PTLS
 	sc.Port2 = sb.STR_DEF_PORT2
+	sc.Root = STR_MEDIA_ROOT
+	sc.UploadMax = LEN_MAX_UPLOAD
 
 	sc.Array = sr.NewStreamArrayWithSize(3, 3, sb.MBYTE)
+	for i, ring := range sc.Array {
+		sc.Rings.Add(strconv.Itoa(i), ring)
+	}
+
+	// video, audio and text tracks for each source of the default channel
+	chn := si.NewChannel(1, 3)
+	for i := range chn.Srcs {
+		src := &chn.Srcs[i]
+		src.Trks[0].Type = si.STR_TRACK_VIDEO
+		src.Trks[1].Type = si.STR_TRACK_AUDIO
+		src.Trks[2].Type = si.STR_TRACK_TEXT
+		sc.Sources[src.Id] = sr.NewStreamTracks(src, 3, sb.MBYTE)
+	}
+	sc.Station = append(sc.Station, chn)
+
+	// the first ring is the video track of the default source
+	sc.Sources[si.ID_DEF_SOURCE].BindRing(si.ID_DEF_TRACK, sc.Array[0])
+
+	// the rings of tracks by path too, ex) /stream/100/110/111
+	for _, src := range chn.Srcs {
+		rings, _ := sc.Sources[src.Id].GetRings()
+		for i, ring := range rings {
+			sc.Rings.Add(sr.GetRingPath(chn.Id, src.Id, src.Trks[i].Id), ring)
+		}
+	}
 
 	return sc
 }
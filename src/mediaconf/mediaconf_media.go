@@ -0,0 +1,288 @@
+//=========================================================================
+// Author : Stoney Kang, sikang99@gmail.com, 2015
+// On-demand media of the files under the media root, ex) /media/photo/a.jpg
+// - files are served with byte ranges and validators (ETag, Last-Modified)
+// - directories are listed in JSON, or streamed as images, ex) ?as=mjpeg
+// - paths can not go out of the root even by links, hidden files and
+//   keys or certificates are not served
+//=========================================================================
+
+package mediaconf
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	ph "stoney/httpserver/src/protohttp"
+
+	sb "stoney/httpserver/src/streambase"
+)
+
+//---------------------------------------------------------------------------
+// entry of a directory listed
+//---------------------------------------------------------------------------
+type MediaEntry struct {
+	Name     string    `json:"name"`
+	Path     string    `json:"path"` // relative to the media root
+	Dir      bool      `json:"dir"`
+	Type     string    `json:"type,omitempty"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+//---------------------------------------------------------------------------
+// check the path has a hidden name, ex) the temporary files of the upload
+//---------------------------------------------------------------------------
+func IsHiddenPath(rel string) bool {
+	for _, name := range strings.Split(path.Clean("/"+rel), "/") {
+		if strings.HasPrefix(name, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// extensions of keys and certificates, never served
+var secretExts = []string{".pem", ".key", ".crt", ".cer", ".der", ".csr", ".p12", ".pfx", ".jks"}
+
+//---------------------------------------------------------------------------
+// check the path is of a key or certificate
+//---------------------------------------------------------------------------
+func IsSecretPath(rel string) bool {
+	ext := strings.ToLower(filepath.Ext(rel))
+	for _, secret := range secretExts {
+		if ext == secret {
+			return true
+		}
+	}
+	return false
+}
+
+//---------------------------------------------------------------------------
+// resolve the links of the file in the media root, to be served
+// - ErrFound : not found, out of the root, hidden or secret
+//---------------------------------------------------------------------------
+func (sc *ServerConfig) ResolveMediaPath(file string) (string, error) {
+	root, err := filepath.EvalSymlinks(sc.Root)
+	if err != nil {
+		return "", sb.ErrFound
+	}
+	real, err := filepath.EvalSymlinks(file)
+	if err != nil {
+		return "", sb.ErrFound
+	}
+
+	rel, err := filepath.Rel(root, real)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		log.Printf("%s is out of the media root\n", file)
+		return "", sb.ErrFound
+	}
+	if IsHiddenPath(filepath.ToSlash(rel)) || IsSecretPath(real) {
+		return "", sb.ErrFound
+	}
+
+	return real, nil
+}
+
+//---------------------------------------------------------------------------
+// get the entity tag of the file by its size and modified time
+//---------------------------------------------------------------------------
+func GetMediaTag(fi os.FileInfo) string {
+	return fmt.Sprintf("\"%x-%x\"", fi.ModTime().UnixNano(), fi.Size())
+}
+
+//---------------------------------------------------------------------------
+// handle /media to provide on-demand media file request
+// - as       : mjpeg to stream the images of a directory
+// - pat      : pattern of the images, ex) ?pat=*.png
+// - interval : time between the images, ex) ?interval=500ms
+// - loop     : repeat the images, ex) ?loop=false
+//---------------------------------------------------------------------------
+func (sc *ServerConfig) MediaHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("handle /media %s for %s to %s\n", r.Method, r.RequestURI, r.Host)
+	defer r.Body.Close()
+
+	if r.Method != "GET" && r.Method != "HEAD" {
+		ph.WriteResponseMessage(w, http.StatusMethodNotAllowed, "not allowed: "+r.Method)
+		return
+	}
+
+	rel := path.Clean("/" + strings.TrimPrefix(r.URL.Path, "/media"))
+	if IsHiddenPath(rel) || IsSecretPath(rel) {
+		ph.WriteResponseMessage(w, http.StatusNotFound, r.URL.Path+" is Not Found")
+		return
+	}
+
+	file, err := sc.GetMediaPath(rel)
+	if err != nil {
+		ph.WriteResponseMessage(w, http.StatusBadRequest, "invalid path: "+r.URL.Path)
+		return
+	}
+	file, err = sc.ResolveMediaPath(file)
+	if err != nil {
+		ph.WriteResponseMessage(w, http.StatusNotFound, r.URL.Path+" is Not Found")
+		return
+	}
+
+	fi, err := os.Stat(file)
+	if err != nil {
+		ph.WriteResponseMessage(w, http.StatusNotFound, r.URL.Path+" is Not Found")
+		return
+	}
+
+	as := r.URL.Query().Get("as")
+	switch {
+	case fi.IsDir() && as == "mjpeg":
+		sc.streamMediaDir(w, r, file)
+	case fi.IsDir() && as == "":
+		sc.listMediaDir(w, r, file, rel)
+	case fi.IsDir():
+		ph.WriteResponseMessage(w, http.StatusBadRequest, "not supported: "+as)
+	case as != "":
+		ph.WriteResponseMessage(w, http.StatusBadRequest, r.URL.Path+" is not a directory")
+	default:
+		sc.serveMediaFile(w, r, file, fi)
+	}
+}
+
+//---------------------------------------------------------------------------
+// serve the file with ranges and conditions given in the request
+//---------------------------------------------------------------------------
+func (sc *ServerConfig) serveMediaFile(w http.ResponseWriter, r *http.Request, file string, fi os.FileInfo) {
+	f, err := os.Open(file)
+	if err != nil {
+		log.Println(err)
+		ph.WriteResponseMessage(w, http.StatusNotFound, r.URL.Path+" is Not Found")
+		return
+	}
+	defer f.Close()
+
+	// ServeContent checks If-None-Match and If-Range with the tag set
+	w.Header().Set("ETag", GetMediaTag(fi))
+	w.Header().Set("Server", ph.STR_HTTP_SERVER)
+
+	http.ServeContent(w, r, fi.Name(), fi.ModTime(), f)
+}
+
+//---------------------------------------------------------------------------
+// list the entries of the directory in JSON, sorted by the name
+//---------------------------------------------------------------------------
+func (sc *ServerConfig) listMediaDir(w http.ResponseWriter, r *http.Request, dir string, rel string) {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		log.Println(err)
+		ph.WriteResponseMessage(w, http.StatusInternalServerError, "can not read dir: "+rel)
+		return
+	}
+
+	entries := []MediaEntry{}
+	for _, fi := range fis {
+		if strings.HasPrefix(fi.Name(), ".") || IsSecretPath(fi.Name()) {
+			continue
+		}
+		// links out of the root are not shown
+		if fi.Mode()&os.ModeSymlink != 0 {
+			real, err := sc.ResolveMediaPath(filepath.Join(dir, fi.Name()))
+			if err != nil {
+				continue
+			}
+			fi, err = os.Stat(real)
+			if err != nil {
+				continue
+			}
+		}
+
+		me := MediaEntry{
+			Name:     fi.Name(),
+			Path:     path.Join(rel, fi.Name()),
+			Dir:      fi.IsDir(),
+			Modified: fi.ModTime(),
+		}
+		if !fi.IsDir() {
+			me.Type = mime.TypeByExtension(filepath.Ext(fi.Name()))
+			me.Size = fi.Size()
+		}
+
+		entries = append(entries, me)
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+	ph.WriteResponseJson(w, http.StatusOK, entries)
+}
+
+//---------------------------------------------------------------------------
+// stream the images of the directory in multipart one by one
+//---------------------------------------------------------------------------
+func (sc *ServerConfig) streamMediaDir(w http.ResponseWriter, r *http.Request, dir string) {
+	var err error
+
+	query := r.URL.Query()
+
+	pat := query.Get("pat")
+	if pat == "" {
+		pat = STR_DEF_MEDIA_PATTERN
+	}
+	// only the images in the directory, not to match the others
+	if strings.ContainsAny(pat, "/\\") || strings.HasPrefix(pat, ".") ||
+		!strings.HasPrefix(mime.TypeByExtension(filepath.Ext(pat)), "image/") {
+		ph.WriteResponseMessage(w, http.StatusBadRequest, "invalid pat: "+pat)
+		return
+	}
+
+	interval := TIME_DEF_MEDIA_INTERVAL
+	if str := query.Get("interval"); str != "" {
+		interval, err = time.ParseDuration(str)
+		if err != nil || interval < TIME_MIN_MEDIA_INTERVAL {
+			ph.WriteResponseMessage(w, http.StatusBadRequest, "invalid interval: "+str)
+			return
+		}
+	}
+
+	loop := true
+	if str := query.Get("loop"); str != "" {
+		loop, err = strconv.ParseBool(str)
+		if err != nil {
+			ph.WriteResponseMessage(w, http.StatusBadRequest, "invalid loop: "+str)
+			return
+		}
+	}
+
+	// check before the response, no way to report errors after it
+	matches, err := filepath.Glob(filepath.Join(dir, pat))
+	if err != nil {
+		ph.WriteResponseMessage(w, http.StatusBadRequest, "invalid pat: "+pat)
+		return
+	}
+	var files []string
+	for _, match := range matches {
+		if real, err := sc.ResolveMediaPath(match); err == nil {
+			files = append(files, real)
+		}
+	}
+	if files == nil {
+		ph.WriteResponseMessage(w, http.StatusNotFound, "no image of "+pat)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+	ph.ResponseGet(w, sb.STR_DEF_BDRY)
+	if r.Method == "HEAD" {
+		return
+	}
+
+	err = ph.WriteFilesInMultipartWithInterval(r.Context(), w, files, sb.STR_DEF_BDRY, interval, loop)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// ---------------------------------E-----N-----D--------------------------------
@@ -0,0 +1,448 @@
+//=========================================================================
+// Author : Stoney Kang, sikang99@gmail.com, 2015
+// Search of what the server has: channels, sources, tracks, rings and recordings
+// - filtered by kind, name, type, status and time, ex) ?kind=ring&status=using
+// - paged by offset and limit, ex) ?offset=50&limit=50
+//=========================================================================
+
+package mediaconf
+
+import (
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	pf "stoney/httpserver/src/protofile"
+	ph "stoney/httpserver/src/protohttp"
+
+	sb "stoney/httpserver/src/streambase"
+	si "stoney/httpserver/src/streaminfo"
+	sr "stoney/httpserver/src/streamring"
+)
+
+//---------------------------------------------------------------------------
+const (
+	STR_KIND_CHANNEL   = "channel"
+	STR_KIND_SOURCE    = "source"
+	STR_KIND_TRACK     = "track"
+	STR_KIND_RING      = "ring"
+	STR_KIND_RECORDING = "recording"
+)
+
+var SearchKinds = []string{STR_KIND_CHANNEL, STR_KIND_SOURCE, STR_KIND_TRACK, STR_KIND_RING, STR_KIND_RECORDING}
+
+//---------------------------------------------------------------------------
+// result of the search
+//---------------------------------------------------------------------------
+type SearchResult struct {
+	Kind   string        `json:"kind"`
+	Id     string        `json:"id"`               // name of the ring, path of the recording
+	Parent string        `json:"parent,omitempty"` // channel of the source, source of the track
+	Name   string        `json:"name,omitempty"`
+	Desc   string        `json:"desc,omitempty"`
+	Type   string        `json:"type,omitempty"` // kind of the track, content type of others
+	Status string        `json:"status,omitempty"`
+	Url    string        `json:"url,omitempty"` // to play or get
+	Start  *time.Time    `json:"start,omitempty"`
+	End    *time.Time    `json:"end,omitempty"` // none if live
+	Size   int64         `json:"size,omitempty"`
+	Stats  *sr.RingStats `json:"stats,omitempty"`
+}
+
+//---------------------------------------------------------------------------
+// page of the results
+//---------------------------------------------------------------------------
+type SearchPage struct {
+	Total   int            `json:"total"` // results matched in all pages
+	Offset  int            `json:"offset"`
+	Limit   int            `json:"limit"`
+	Results []SearchResult `json:"results"`
+}
+
+//---------------------------------------------------------------------------
+// conditions of the search, empty ones match all
+//---------------------------------------------------------------------------
+type SearchQuery struct {
+	Kinds  map[string]bool
+	Name   string // part of the id, name or desc in any case
+	Type   string // content type or kind of the track, ex) image/jpeg, video
+	Status string // ex) using, idle
+	From   time.Time
+	To     time.Time
+	Offset int
+	Limit  int
+}
+
+//---------------------------------------------------------------------------
+// get the time given in RFC3339, unix seconds or a duration from now,
+// ex) 2015-10-21T07:28:00Z, 1445412480, -10m
+//---------------------------------------------------------------------------
+func GetSearchTime(str string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, str); err == nil {
+		return t, nil
+	}
+	if sec, err := strconv.ParseInt(str, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	if d, err := time.ParseDuration(str); err == nil {
+		return now.Add(d), nil
+	}
+	return time.Time{}, sb.ErrValue
+}
+
+//---------------------------------------------------------------------------
+// get the search from the query
+// - kind : kinds in comma, ex) ?kind=ring,recording
+// - name, type, status, from, to : conditions
+// - offset, limit : the page
+//---------------------------------------------------------------------------
+func GetSearchQuery(query url.Values) (*SearchQuery, error) {
+	var err error
+
+	sq := &SearchQuery{
+		Name:   strings.ToLower(query.Get("name")),
+		Type:   query.Get("type"),
+		Status: query.Get("status"),
+		Limit:  NUM_DEF_SEARCH_LIMIT,
+	}
+
+	if str := query.Get("kind"); str != "" {
+		sq.Kinds = make(map[string]bool)
+		for _, kind := range strings.Split(str, ",") {
+			if !isSearchKind(kind) {
+				return nil, fmt.Errorf("invalid kind: %s", kind)
+			}
+			sq.Kinds[kind] = true
+		}
+	}
+
+	now := time.Now()
+	if str := query.Get("from"); str != "" {
+		sq.From, err = GetSearchTime(str, now)
+		if err != nil {
+			return nil, fmt.Errorf("invalid from: %s", str)
+		}
+	}
+	if str := query.Get("to"); str != "" {
+		sq.To, err = GetSearchTime(str, now)
+		if err != nil {
+			return nil, fmt.Errorf("invalid to: %s", str)
+		}
+	}
+
+	if str := query.Get("offset"); str != "" {
+		sq.Offset, err = strconv.Atoi(str)
+		if err != nil || sq.Offset < 0 {
+			return nil, fmt.Errorf("invalid offset: %s", str)
+		}
+	}
+	if str := query.Get("limit"); str != "" {
+		sq.Limit, err = strconv.Atoi(str)
+		if err != nil || sq.Limit < 1 || sq.Limit > NUM_MAX_SEARCH_LIMIT {
+			return nil, fmt.Errorf("invalid limit: %s", str)
+		}
+	}
+
+	return sq, nil
+}
+
+func isSearchKind(kind string) bool {
+	for _, k := range SearchKinds {
+		if kind == k {
+			return true
+		}
+	}
+	return false
+}
+
+//---------------------------------------------------------------------------
+// check the kind is searched
+//---------------------------------------------------------------------------
+func (sq *SearchQuery) HasKind(kind string) bool {
+	return sq.Kinds == nil || sq.Kinds[kind]
+}
+
+//---------------------------------------------------------------------------
+// check the result meets the conditions
+//---------------------------------------------------------------------------
+func (sq *SearchQuery) Match(res *SearchResult, now time.Time) bool {
+	if !sq.HasKind(res.Kind) {
+		return false
+	}
+
+	if sq.Name != "" {
+		str := strings.ToLower(res.Id + "\n" + res.Name + "\n" + res.Desc)
+		if !strings.Contains(str, sq.Name) {
+			return false
+		}
+	}
+
+	if sq.Type != "" && !matchSearchType(sq.Type, res.Type) {
+		return false
+	}
+
+	if sq.Status != "" && !strings.EqualFold(sq.Status, res.Status) {
+		return false
+	}
+
+	// the span of the result overlaps the range, live ones until now
+	if !sq.From.IsZero() || !sq.To.IsZero() {
+		if res.Start == nil {
+			return false
+		}
+		end := now
+		if res.End != nil {
+			end = *res.End
+		}
+		if !sq.From.IsZero() && end.Before(sq.From) {
+			return false
+		}
+		if !sq.To.IsZero() && res.Start.After(sq.To) {
+			return false
+		}
+	}
+
+	return true
+}
+
+//---------------------------------------------------------------------------
+// match the type wanted to the content type or the kind of the track
+// ex) video matches image/jpeg, image/jpeg matches the video track
+//---------------------------------------------------------------------------
+func matchSearchType(want string, ctype string) bool {
+	if ctype == "" {
+		return false
+	}
+
+	// kind of the track
+	if !strings.Contains(ctype, "/") {
+		return ctype == want || ctype == si.GetTrackType(want)
+	}
+
+	if strings.Contains(want, "/") {
+		mt, _, err := mime.ParseMediaType(ctype)
+		if err != nil {
+			mt = ctype
+		}
+		return strings.EqualFold(mt, want)
+	}
+
+	return si.GetTrackType(ctype) == want || strings.HasPrefix(ctype, want+"/")
+}
+
+//---------------------------------------------------------------------------
+// search all and return the page of the results matched
+//---------------------------------------------------------------------------
+func (sc *ServerConfig) Search(sq *SearchQuery) *SearchPage {
+	now := time.Now()
+
+	var all []SearchResult
+	if sq.HasKind(STR_KIND_CHANNEL) || sq.HasKind(STR_KIND_SOURCE) || sq.HasKind(STR_KIND_TRACK) {
+		all = append(all, sc.searchStation()...)
+	}
+	if sq.HasKind(STR_KIND_RING) {
+		all = append(all, sc.searchRings()...)
+	}
+	if sq.HasKind(STR_KIND_RECORDING) {
+		all = append(all, sc.searchRecordings()...)
+	}
+
+	page := &SearchPage{Offset: sq.Offset, Limit: sq.Limit, Results: []SearchResult{}}
+	for i := range all {
+		if !sq.Match(&all[i], now) {
+			continue
+		}
+		if page.Total >= sq.Offset && len(page.Results) < sq.Limit {
+			page.Results = append(page.Results, all[i])
+		}
+		page.Total++
+	}
+
+	return page
+}
+
+//---------------------------------------------------------------------------
+// channels of the station, their sources and tracks
+//---------------------------------------------------------------------------
+func (sc *ServerConfig) searchStation() []SearchResult {
+	var results []SearchResult
+
+	for _, ci := range sc.ListChannels() {
+		start := ci.Time
+		results = append(results, SearchResult{
+			Kind:   STR_KIND_CHANNEL,
+			Id:     ci.Id,
+			Name:   ci.Name,
+			Desc:   ci.Desc,
+			Status: ci.Status,
+			Start:  &start,
+		})
+
+		for _, so := range ci.Sources {
+			start := so.Time
+			results = append(results, SearchResult{
+				Kind:   STR_KIND_SOURCE,
+				Id:     so.Id,
+				Parent: ci.Id,
+				Desc:   so.Desc,
+				Status: so.Status,
+				Start:  &start,
+			})
+
+			for _, ti := range so.Tracks {
+				results = append(results, SearchResult{
+					Kind:   STR_KIND_TRACK,
+					Id:     ti.Id,
+					Parent: so.Id,
+					Desc:   ti.Desc,
+					Type:   ti.Type,
+					Status: ti.Status,
+					Url:    sr.STR_STREAM_PREFIX + ti.Ring,
+					Start:  &start,
+				})
+			}
+		}
+	}
+
+	return results
+}
+
+//---------------------------------------------------------------------------
+// rings by the name with their stats, the time of the newest frame
+//---------------------------------------------------------------------------
+func (sc *ServerConfig) searchRings() []SearchResult {
+	var results []SearchResult
+
+	for _, name := range sc.Rings.Names() {
+		ring, err := sc.Rings.Get(name)
+		if err != nil {
+			continue // removed in the meantime
+		}
+
+		stats := ring.Stats()
+		res := SearchResult{
+			Kind:   STR_KIND_RING,
+			Id:     name,
+			Desc:   ring.Desc,
+			Status: sb.StatusText[ring.GetStatus()],
+			Url:    "/stream/" + name,
+			Stats:  &stats,
+		}
+
+		ctype, ts, err := ring.GetSlotLatestInfo()
+		if err == nil {
+			last := time.Unix(0, 0).Add(sb.GetDuration(ts))
+			res.Type = ctype
+			res.Start = &last
+			res.End = &last
+		}
+
+		results = append(results, res)
+	}
+
+	return results
+}
+
+//---------------------------------------------------------------------------
+// recordings listed not to walk the directory at every search, listed again
+// when invalidated by the writes of the server or too old
+//---------------------------------------------------------------------------
+type recordCache struct {
+	sync.Mutex
+	recs []pf.Recording
+	time time.Time // time listed, zero if invalidated
+}
+
+//---------------------------------------------------------------------------
+// let the recordings be listed again at the next search
+//---------------------------------------------------------------------------
+func (sc *ServerConfig) InvalidateRecordings() {
+	sc.records.Lock()
+	defer sc.records.Unlock()
+
+	sc.records.time = time.Time{}
+}
+
+//---------------------------------------------------------------------------
+// list the recordings in the record directory of the media root, shared
+// by the searches and not to be changed
+//---------------------------------------------------------------------------
+func (sc *ServerConfig) listRecordings() []pf.Recording {
+	sc.records.Lock()
+	defer sc.records.Unlock()
+
+	rc := &sc.records
+	if !rc.time.IsZero() && time.Since(rc.time) < TIME_MAX_RECORD_CACHE {
+		return rc.recs
+	}
+
+	dir := filepath.Join(sc.Root, STR_RECORD_DIR)
+	recs, err := pf.ListRecordings(dir)
+	if err != nil && !os.IsNotExist(err) {
+		log.Println(err)
+	}
+
+	rc.recs = recs
+	rc.time = time.Now()
+
+	return recs
+}
+
+//---------------------------------------------------------------------------
+// recordings under the media root
+//---------------------------------------------------------------------------
+func (sc *ServerConfig) searchRecordings() []SearchResult {
+	var results []SearchResult
+
+	for _, rec := range sc.listRecordings() {
+		path := STR_RECORD_DIR + "/" + rec.Path
+		start, end := rec.Start, rec.End
+		results = append(results, SearchResult{
+			Kind:  STR_KIND_RECORDING,
+			Id:    path,
+			Name:  rec.Name,
+			Type:  rec.Type,
+			Url:   "/media/" + path,
+			Start: &start,
+			End:   &end,
+			Size:  rec.Size,
+		})
+	}
+
+	return results
+}
+
+//---------------------------------------------------------------------------
+// handle /search to find what is streaming or recorded, in JSON
+//---------------------------------------------------------------------------
+func (sc *ServerConfig) SearchHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("handle /search %s for %s to %s\n", r.Method, r.RequestURI, r.Host)
+	defer r.Body.Close()
+
+	if r.Method != "GET" && r.Method != "HEAD" {
+		ph.WriteResponseMessage(w, http.StatusMethodNotAllowed, "not allowed: "+r.Method)
+		return
+	}
+
+	sq, err := GetSearchQuery(r.URL.Query())
+	if err != nil {
+		ph.WriteResponseMessage(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+	err = ph.WriteResponseJson(w, http.StatusOK, sc.Search(sq))
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// ---------------------------------E-----N-----D--------------------------------
@@ -6,8 +6,31 @@
 package mediaconf
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	pb "stoney/httpserver/src/protobase"
+	pf "stoney/httpserver/src/protofile"
+	ph "stoney/httpserver/src/protohttp"
+	sb "stoney/httpserver/src/streambase"
+	sm "stoney/httpserver/src/streamimage"
+	sr "stoney/httpserver/src/streamring"
 )
 
 //------------------------------------------------------------------
@@ -24,3 +47,898 @@ func TestInfo(t *testing.T) {
 func TestServer(t *testing.T) {
 
 }
+
+//------------------------------------------------------------------
+// test for the reader reconnecting to the camera rebooted
+//------------------------------------------------------------------
+func TestStreamReader(t *testing.T) {
+	// camera sending 3 parts and closing at every connection
+	var conns int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conns++
+		mw := multipart.NewWriter(w)
+		w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary="+mw.Boundary())
+		for i := 0; i < 3; i++ {
+			data := []byte(fmt.Sprintf("conn %d part %d", conns, i))
+			hdr := make(textproto.MIMEHeader)
+			hdr.Set(sb.STR_HDR_CONTENT_TYPE, "text/plain")
+			hdr.Set(sb.STR_HDR_CONTENT_LENGTH, strconv.Itoa(len(data)))
+			pw, _ := mw.CreatePart(hdr)
+			pw.Write(data)
+		}
+		mw.Close()
+	}))
+	defer ts.Close()
+
+	sc := NewServerConfig()
+	ring := sc.Array[0]
+	base := pb.NewProtoBase()
+	base.SetStatusRun()
+
+	done := make(chan error)
+	go func() { done <- sc.StreamReader(base, ring, ts.URL) }()
+
+	time.Sleep(1500 * time.Millisecond)
+	assert.True(t, ring.IsUsing())
+	t.Log(base)
+
+	base.SetStatusClose()
+	<-done
+	assert.False(t, ring.IsUsing())
+	lastError, _ := base.GetLastError()
+	assert.True(t, base.GetReconnects() > 0)
+	assert.NotEqual(t, "", lastError)
+	assert.True(t, ring.Stats().FramesIn >= 6)
+}
+
+//------------------------------------------------------------------
+// test for the caster relaying the ring to another server
+//------------------------------------------------------------------
+func TestStreamCaster(t *testing.T) {
+	// server receiving the ring named relay
+	ss := NewServerConfig()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stream/", ss.RingHandler)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	sc := NewServerConfig()
+	ring := sc.Array[0]
+	ring.SetStatusUsing()
+	defer ring.SetStatusIdle()
+
+	fin := make(chan struct{})
+	defer close(fin)
+	go func() {
+		for i := 0; ; i++ {
+			select {
+			case <-fin:
+				return
+			case <-time.After(20 * time.Millisecond):
+			}
+			data := []byte(fmt.Sprintf("frame %d", i))
+			slot := sr.NewStreamSlotByData(len(data), "text/plain", len(data), data)
+			slot.Timestamp = int64(1000 + i)
+			ring.PutSlotInNext(slot)
+		}
+	}()
+
+	base := pb.NewProtoBase()
+	base.SetStatusRun()
+	done := make(chan error)
+	go func() { done <- sc.StreamCaster(base, ring, ts.URL+"/stream/relay") }()
+
+	time.Sleep(300 * time.Millisecond)
+	relay, err := ss.Rings.Get("relay")
+	assert.Nil(t, err)
+	slot, err := relay.GetSlotKeyframe()
+	assert.Equal(t, sb.ErrFound, err)
+	assert.True(t, relay.Stats().FramesIn > 0)
+	rr := relay.NewRingReader()
+	slot = sr.NewStreamSlotBySize(sb.KBYTE)
+	_, err = rr.ReadSlotTo(slot)
+	rr.Close()
+	assert.Nil(t, err)
+	assert.True(t, slot.Timestamp >= 1000 && slot.Timestamp < 2000)
+
+	// the server closes the ring, then the caster reconnects
+	ss.Rings.Remove("relay")
+	for i := 0; i < 30; i++ {
+		time.Sleep(100 * time.Millisecond)
+		relay, err = ss.Rings.Get("relay")
+		if err == nil && relay.Stats().FramesIn > 0 {
+			break
+		}
+	}
+	assert.Nil(t, err)
+	if err == nil {
+		assert.True(t, relay.Stats().FramesIn > 0)
+	}
+	t.Log(base)
+
+	base.SetStatusClose()
+	<-done
+	assert.True(t, base.GetReconnects() > 0)
+}
+
+//------------------------------------------------------------------
+// test for the ring published in order by the reorder stage
+//------------------------------------------------------------------
+func TestRingReorderIngest(t *testing.T) {
+	sc := NewServerConfig()
+	// through the frames of the pool, not copied
+	sc.Rings.Mode = sr.RING_MODE_SHARED
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stream/", sc.RingHandler)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, seq := range []int{2, 1, 1, 3} {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", "text/plain")
+		header.Set(sb.STR_HDR_SEQUENCE, strconv.Itoa(seq))
+		pw, _ := mw.CreatePart(header)
+		fmt.Fprintf(pw, "frame %d", seq)
+	}
+	mw.Close()
+
+	url := ts.URL + "/stream/relay?reorder=sequence&latency=50ms&num=4"
+	res, err := http.Post(url, "multipart/x-mixed-replace; boundary="+mw.Boundary(), &body)
+	assert.Nil(t, err)
+	if err == nil {
+		res.Body.Close()
+	}
+
+	res, err = http.Post(ts.URL+"/stream/relay?reorder=random", "multipart/x-mixed-replace; boundary=x", nil)
+	assert.Nil(t, err)
+	if err == nil {
+		assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+		res.Body.Close()
+	}
+
+	ring, err := sc.Rings.Get("relay")
+	assert.Nil(t, err)
+	assert.True(t, ring.IsShared())
+	for i := 0; i < 20 && ring.IsUsing(); i++ {
+		time.Sleep(50 * time.Millisecond)
+	}
+	stats := ring.Stats()
+	t.Log(stats.BaseString())
+	assert.Equal(t, int64(3), stats.FramesIn)
+	assert.Equal(t, int64(1), stats.DropsOrd)
+	for i := 0; i < 3; i++ {
+		slot, err := ring.GetSlotByPos(i)
+		assert.Nil(t, err)
+		assert.Equal(t, fmt.Sprintf("frame %d", i+1), string(slot.Content[:slot.Length]))
+	}
+}
+
+//------------------------------------------------------------------
+// test for the casters of the ring, the second one is in conflict
+//------------------------------------------------------------------
+func TestRingCasters(t *testing.T) {
+	sc := NewServerConfig()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stream/", sc.RingHandler)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	// the first one keeps the ring by the body not ended
+	pr, pw := io.Pipe()
+	done := make(chan int, 1)
+	go func() {
+		res, err := http.Post(ts.URL+"/stream/door", "multipart/x-mixed-replace; boundary=x", pr)
+		if err != nil {
+			done <- 0
+			return
+		}
+		res.Body.Close()
+		done <- res.StatusCode
+	}()
+
+	ring, _, _ := sc.Rings.GetOrCreate("door")
+	for i := 0; i < 40 && !ring.IsUsing(); i++ {
+		time.Sleep(25 * time.Millisecond)
+	}
+	assert.True(t, ring.IsUsing())
+
+	res, err := http.Post(ts.URL+"/stream/door", "multipart/x-mixed-replace; boundary=y", nil)
+	assert.Nil(t, err)
+	if err == nil {
+		assert.Equal(t, http.StatusConflict, res.StatusCode)
+		res.Body.Close()
+	}
+	assert.Equal(t, "x", ring.GetBoundary())
+
+	pw.Close()
+	assert.Equal(t, http.StatusOK, <-done)
+	for i := 0; i < 40 && ring.IsUsing(); i++ {
+		time.Sleep(25 * time.Millisecond)
+	}
+	assert.True(t, ring.IsIdle())
+}
+
+//------------------------------------------------------------------
+// test for the still of the ring, conditional and long-polled
+//------------------------------------------------------------------
+func TestSnapshotHandler(t *testing.T) {
+	sc := NewServerConfig()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/snapshot/", sc.SnapshotHandler)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	ring, _, err := sc.Rings.GetOrCreate("cam")
+	assert.Nil(t, err)
+
+	put := func(i int) {
+		data := []byte(fmt.Sprintf("jpeg %d", i))
+		slot := sr.NewStreamSlotByData(len(data), "image/jpeg", len(data), data)
+		slot.Timestamp = int64(1000 + i)
+		ring.PutSlotInNext(slot)
+	}
+
+	get := func(path string, tag string) (*http.Response, string) {
+		req, _ := http.NewRequest("GET", ts.URL+path, nil)
+		if tag != "" {
+			req.Header.Set("If-None-Match", tag)
+		}
+		res, err := http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		defer res.Body.Close()
+		body, _ := ioutil.ReadAll(res.Body)
+		return res, string(body)
+	}
+
+	// nothing yet
+	res, _ := get("/snapshot/cam", "")
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+	res, _ = get("/snapshot/none", "")
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+
+	put(0)
+	put(1)
+	res, body := get("/snapshot/cam", "")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "jpeg 1", body)
+	assert.Equal(t, "image/jpeg", res.Header.Get("Content-Type"))
+	assert.Equal(t, "1001", res.Header.Get(sb.STR_HDR_TIMESTAMP))
+	tag := res.Header.Get("ETag")
+	assert.Equal(t, fmt.Sprintf(`"%d-1"`, ring.GetEpoch()), tag)
+
+	// not modified for the newest
+	res, _ = get("/snapshot/cam", tag)
+	assert.Equal(t, http.StatusNotModified, res.StatusCode)
+
+	// blocked until a newer one
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		put(2)
+	}()
+	start := time.Now()
+	res, body = get("/snapshot/cam?wait=1", tag)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "jpeg 2", body)
+	assert.Equal(t, fmt.Sprintf(`"%d-2"`, ring.GetEpoch()), res.Header.Get("ETag"))
+	assert.True(t, time.Since(start) >= 100*time.Millisecond)
+
+	// newer than the seq given at once
+	res, body = get("/snapshot/cam?wait=1&seq=0", "")
+	assert.Equal(t, "jpeg 2", body)
+
+	// the tag before the reset is not the newest though its sequence is
+	tag = res.Header.Get("ETag")
+	ring.Reset()
+	put(3)
+	res, body = get("/snapshot/cam", tag)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "jpeg 3", body)
+	assert.Equal(t, fmt.Sprintf(`"%d-0"`, ring.GetEpoch()), res.Header.Get("ETag"))
+	assert.NotEqual(t, tag, res.Header.Get("ETag"))
+}
+
+//------------------------------------------------------------------
+// test for the rings saved at shutdown and played after restored
+//------------------------------------------------------------------
+func TestRingRestore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snap")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// names not to collide in the files
+	names := []string{"lobby/cam", "lobby_cam"}
+
+	sc := NewServerConfig()
+	sc.SnapDir = dir
+	for _, name := range names {
+		ring, _, err := sc.Rings.GetOrCreate(name)
+		assert.Nil(t, err)
+		ring.SetStatusUsing()
+		for i := 0; i < 3; i++ {
+			data := []byte(fmt.Sprintf("%s %d", name, i))
+			slot := sr.NewStreamSlotByData(len(data), "image/jpeg", len(data), data)
+			slot.Timestamp = int64(1000 + i)
+			ring.PutSlotInNext(slot)
+		}
+		ring.SetStatusIdle()
+	}
+	assert.Nil(t, sc.Shutdown(context.Background()))
+	for _, file := range []string{"lobby%2Fcam.snap", "lobby_cam.snap"} {
+		_, err = os.Stat(filepath.Join(dir, file))
+		assert.Nil(t, err)
+	}
+
+	// restarted
+	rs := NewServerConfig()
+	rs.SnapDir = dir
+	for _, name := range names {
+		rs.Rings.GetOrCreate(name)
+	}
+	rs.LoadSnapshots()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stream/", rs.RingHandler)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	for _, name := range names {
+		ring, err := rs.Rings.Get(name)
+		assert.Nil(t, err)
+		assert.True(t, ring.IsIdle())
+		assert.True(t, ring.IsReadable())
+
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		req, _ := http.NewRequest("GET", ts.URL+"/stream/"+name, nil)
+		res, err := http.DefaultClient.Do(req.WithContext(ctx))
+		assert.Nil(t, err)
+		if err != nil {
+			cancel()
+			continue
+		}
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+
+		boundary, _ := ph.GetTypeBoundary(res.Header.Get("Content-Type"))
+		part, err := sr.NewPartReader(res.Body, boundary).NextPart()
+		assert.Nil(t, err)
+		if err == nil {
+			// the newest restored, no next part to end it
+			body := make([]byte, sr.GetPartLength(part))
+			_, err = io.ReadFull(part, body)
+			assert.Nil(t, err)
+			assert.Equal(t, name+" 2", string(body))
+		}
+		cancel()
+		res.Body.Close()
+
+		// live again by the next caster
+		assert.Nil(t, ring.SetStatusUsing())
+		ring.SetStatusIdle()
+		assert.False(t, ring.IsReadable())
+	}
+
+	// nothing to seek in the ring live but empty
+	ring, _, _ := rs.Rings.GetOrCreate("hall-cam")
+	ring.SetStatusUsing()
+	defer ring.SetStatusIdle()
+	res, err := http.Get(ts.URL + "/stream/hall-cam?from=-5s")
+	assert.Nil(t, err)
+	if err == nil {
+		assert.Equal(t, http.StatusNotFound, res.StatusCode)
+		res.Body.Close()
+	}
+}
+
+//------------------------------------------------------------------
+// test for the upload into the media store and the ring
+//------------------------------------------------------------------
+func TestUploadHandler(t *testing.T) {
+	root, err := ioutil.TempDir("", "upload")
+	assert.Nil(t, err)
+	defer os.RemoveAll(root)
+
+	sc := NewServerConfig()
+	sc.Root = root
+	sc.UploadMax = 64 * sb.KBYTE
+	mux := http.NewServeMux()
+	mux.HandleFunc("/upload", sc.UploadHandler)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	jpg, err := sm.PutImageToBuffer(sm.GenSpiralImage(32, 32), "jpeg", 80)
+	assert.Nil(t, err)
+
+	upload := func(path string, files map[string][]byte) *http.Response {
+		body := new(bytes.Buffer)
+		mw := multipart.NewWriter(body)
+		mw.WriteField("memo", "ignored")
+		for name, data := range files {
+			fw, _ := mw.CreateFormFile("file", name)
+			fw.Write(data)
+		}
+		mw.Close()
+
+		res, err := http.Post(ts.URL+path, mw.FormDataContentType(), body)
+		assert.Nil(t, err)
+		return res
+	}
+
+	// published to the ring, and stored not to overwrite the same name
+	res := upload("/upload?ring=phone", map[string][]byte{"../../a.jpg": jpg})
+	assert.Equal(t, http.StatusCreated, res.StatusCode)
+	var files []UploadFile
+	json.NewDecoder(res.Body).Decode(&files)
+	res.Body.Close()
+	assert.Equal(t, 1, len(files))
+	assert.Equal(t, "upload/a.jpg", files[0].Path)
+	assert.Equal(t, "image/jpeg", files[0].Type)
+	assert.Equal(t, int64(0), files[0].Seq)
+
+	res = upload("/upload?dir=../photo", map[string][]byte{"a.jpg": jpg})
+	assert.Equal(t, http.StatusCreated, res.StatusCode)
+	res = upload("/upload?dir=photo", map[string][]byte{"a.jpg": jpg})
+	json.NewDecoder(res.Body).Decode(&files)
+	res.Body.Close()
+	assert.Equal(t, "photo/a-1.jpg", files[0].Path)
+	assert.Equal(t, int64(-1), files[0].Seq)
+
+	data, err := ioutil.ReadFile(filepath.Join(root, "photo", "a-1.jpg"))
+	assert.Nil(t, err)
+	assert.Equal(t, jpg, data)
+
+	// left to the players and the next caster
+	ring, err := sc.Rings.Get("phone")
+	assert.Nil(t, err)
+	assert.True(t, ring.IsIdle())
+	assert.True(t, ring.IsReadable())
+	slot := sr.NewStreamSlot()
+	assert.Nil(t, ring.ReadSlotLatestTo(slot))
+	assert.Equal(t, jpg, slot.Content[:slot.Length])
+
+	// not media, too big and none
+	res = upload("/upload", map[string][]byte{"a.txt": []byte("hello, world")})
+	assert.Equal(t, http.StatusUnsupportedMediaType, res.StatusCode)
+	res = upload("/upload", map[string][]byte{"b.jpg": append(jpg, make([]byte, 64*sb.KBYTE)...)})
+	assert.Equal(t, http.StatusRequestEntityTooLarge, res.StatusCode)
+	res = upload("/upload", nil)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	// nothing left behind
+	names, _ := filepath.Glob(filepath.Join(root, "upload", "*"))
+	assert.Equal(t, 1, len(names))
+
+	// none saved nor published if any fails
+	seq := ring.Stats().FramesIn
+	res = upload("/upload?dir=mixed&ring=phone", map[string][]byte{"b.jpg": jpg, "b.txt": []byte("hello, world")})
+	assert.Equal(t, http.StatusUnsupportedMediaType, res.StatusCode)
+	names, _ = filepath.Glob(filepath.Join(root, "mixed", "*"))
+	assert.Equal(t, 0, len(names))
+	assert.Equal(t, seq, ring.Stats().FramesIn)
+	res = upload("/upload?dir=mixed&ring=tablet", map[string][]byte{"b.txt": []byte("hello, world")})
+	assert.Equal(t, http.StatusUnsupportedMediaType, res.StatusCode)
+	_, err = sc.Rings.Get("tablet")
+	assert.NotNil(t, err)
+
+	// not published to the ring of other caster
+	assert.Nil(t, ring.SetStatusUsing())
+	res = upload("/upload?dir=cast&ring=phone", map[string][]byte{"d.jpg": jpg})
+	assert.Equal(t, http.StatusConflict, res.StatusCode)
+	names, _ = filepath.Glob(filepath.Join(root, "cast", "*"))
+	assert.Equal(t, 0, len(names))
+	assert.Nil(t, ring.SetStatusIdle())
+
+	// the same name at the same time, none overwritten
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res := upload("/upload?dir=race", map[string][]byte{"c.jpg": jpg})
+			res.Body.Close()
+		}()
+	}
+	wg.Wait()
+	names, _ = filepath.Glob(filepath.Join(root, "race", "*"))
+	assert.Equal(t, 5, len(names))
+
+	// copied if no hard link, not over the file used
+	err = copyFileExcl(names[0], filepath.Join(root, "race", "d.jpg"))
+	assert.Nil(t, err)
+	err = copyFileExcl(names[0], names[1])
+	assert.True(t, os.IsExist(err))
+	data, _ = ioutil.ReadFile(filepath.Join(root, "race", "d.jpg"))
+	assert.Equal(t, jpg, data)
+}
+
+//---------------------------------------------------------------------------
+func TestMediaHandler(t *testing.T) {
+	root, err := ioutil.TempDir("", "media")
+	assert.Nil(t, err)
+	defer os.RemoveAll(root)
+
+	jpg, err := sm.PutImageToBuffer(sm.GenSpiralImage(32, 32), "jpeg", 80)
+	assert.Nil(t, err)
+	os.MkdirAll(filepath.Join(root, "photo"), 0755)
+	ioutil.WriteFile(filepath.Join(root, "photo", "a.jpg"), jpg, 0644)
+	ioutil.WriteFile(filepath.Join(root, "photo", "b.jpg"), jpg, 0644)
+	ioutil.WriteFile(filepath.Join(root, "photo", ".upload-1"), jpg, 0644)
+
+	sc := NewServerConfig()
+	sc.Root = root
+	mux := http.NewServeMux()
+	mux.HandleFunc("/media", sc.MediaHandler)
+	mux.HandleFunc("/media/", sc.MediaHandler)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	get := func(path string, header http.Header) (*http.Response, []byte) {
+		req, _ := http.NewRequest("GET", ts.URL+path, nil)
+		for k := range header {
+			req.Header.Set(k, header.Get(k))
+		}
+		res, err := http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		return res, body
+	}
+
+	// whole, range and cached
+	res, body := get("/media/photo/a.jpg", nil)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "image/jpeg", res.Header.Get("Content-Type"))
+	assert.NotEmpty(t, res.Header.Get("Last-Modified"))
+	assert.Equal(t, jpg, body)
+	tag := res.Header.Get("ETag")
+	assert.NotEmpty(t, tag)
+
+	res, body = get("/media/photo/a.jpg", http.Header{"Range": {"bytes=2-9"}})
+	assert.Equal(t, http.StatusPartialContent, res.StatusCode)
+	assert.Equal(t, fmt.Sprintf("bytes 2-9/%d", len(jpg)), res.Header.Get("Content-Range"))
+	assert.Equal(t, jpg[2:10], body)
+
+	res, _ = get("/media/photo/a.jpg", http.Header{"If-None-Match": {tag}})
+	assert.Equal(t, http.StatusNotModified, res.StatusCode)
+
+	// not out of the root, the mux is bypassed not to be cleaned
+	ioutil.WriteFile(filepath.Join(filepath.Dir(root), "outside.jpg"), jpg, 0644)
+	defer os.Remove(filepath.Join(filepath.Dir(root), "outside.jpg"))
+	rec := httptest.NewRecorder()
+	sc.MediaHandler(rec, httptest.NewRequest("GET", "/media/../outside.jpg", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec = httptest.NewRecorder()
+	sc.MediaHandler(rec, httptest.NewRequest("GET", "/media/../../photo/a.jpg", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// not hidden
+	res, _ = get("/media/photo/.upload-1", nil)
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+
+	// neither keys nor links out of the root, the link inside is served
+	ioutil.WriteFile(filepath.Join(root, "photo", "server.key"), []byte("secret"), 0644)
+	ioutil.WriteFile(filepath.Join(root, "photo", "cert.PEM"), []byte("secret"), 0644)
+	os.Symlink(filepath.Join(filepath.Dir(root), "outside.jpg"), filepath.Join(root, "photo", "out.jpg"))
+	os.Symlink(filepath.Join(root, "photo", "a.jpg"), filepath.Join(root, "photo", "in.jpg"))
+	for _, name := range []string{"server.key", "cert.PEM", "out.jpg"} {
+		res, _ = get("/media/photo/"+name, nil)
+		assert.Equal(t, http.StatusNotFound, res.StatusCode, name)
+	}
+	res, body = get("/media/photo/in.jpg", nil)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, jpg, body)
+	os.Remove(filepath.Join(root, "photo", "in.jpg"))
+	res, _ = get("/media/photo?as=mjpeg&pat=*.key", nil)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	// listed
+	res, body = get("/media/photo", nil)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	var entries []MediaEntry
+	assert.Nil(t, json.Unmarshal(body, &entries))
+	assert.Equal(t, 2, len(entries))
+	assert.Equal(t, "/photo/a.jpg", entries[0].Path)
+	assert.Equal(t, "image/jpeg", entries[0].Type)
+	assert.Equal(t, int64(len(jpg)), entries[0].Size)
+	res, body = get("/media", nil)
+	assert.Nil(t, json.Unmarshal(body, &entries))
+	assert.Equal(t, 1, len(entries))
+	assert.True(t, entries[0].Dir)
+
+	res, _ = get("/media/photo?as=mjpeg&interval=1ms", nil)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	res, _ = get("/media/photo?as=mjpeg&pat=*.png", nil)
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+	res, _ = get("/media/photo/a.jpg?as=mjpeg", nil)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	// streamed in the interval, looped
+	start := time.Now()
+	res, err = http.Get(ts.URL + "/media/photo?as=mjpeg&interval=50ms")
+	assert.Nil(t, err)
+	defer res.Body.Close()
+	assert.Contains(t, res.Header.Get("Content-Type"), "multipart/x-mixed-replace")
+
+	mr := sr.NewPartReader(res.Body, "--"+sb.STR_DEF_BDRY)
+	slot := sr.NewStreamSlot()
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, sr.ReadPartToSlot(mr, slot))
+		assert.Equal(t, jpg, slot.Content[:slot.Length])
+	}
+	assert.True(t, time.Since(start) >= 100*time.Millisecond)
+}
+
+//---------------------------------------------------------------------------
+func TestSearchHandler(t *testing.T) {
+	root, err := ioutil.TempDir("", "search")
+	assert.Nil(t, err)
+	defer os.RemoveAll(root)
+
+	sc := NewServerConfig()
+	sc.Root = root
+	mux := http.NewServeMux()
+	mux.HandleFunc("/search", sc.SearchHandler)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	// a live ring and a recording of an hour ago
+	ring, _, err := sc.Rings.GetOrCreate("lobby-cam")
+	assert.Nil(t, err)
+	ring.SetStatusUsing()
+	data := []byte("not a jpeg")
+	slot := sr.NewStreamSlotByData(len(data), "image/jpeg", len(data), data)
+	slot.Timestamp = sb.GetTimestampNow()
+	ring.PutSlotInNext(slot)
+
+	os.MkdirAll(filepath.Join(root, "record"), 0755)
+	f, err := os.Create(filepath.Join(root, "record", "output.mjpg"))
+	assert.Nil(t, err)
+	slot.Timestamp = sb.GetTimestampNow() - sb.GetTimestampByDuration(time.Hour)
+	pf.WriteSlotToFile(f, slot, sb.STR_DEF_BDRY)
+	f.Close()
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(f.Name(), old, old)
+
+	// not a recording out of the record directory
+	os.MkdirAll(filepath.Join(root, "other"), 0755)
+	ioutil.WriteFile(filepath.Join(root, "other", "output.mjpg"), data, 0644)
+
+	search := func(query string) (int, *SearchPage) {
+		res, err := http.Get(ts.URL + "/search?" + query)
+		assert.Nil(t, err)
+		defer res.Body.Close()
+		page := &SearchPage{}
+		json.NewDecoder(res.Body).Decode(page)
+		return res.StatusCode, page
+	}
+
+	// 1 channel, 1 source of 3 tracks, 3+3+1 rings and 1 recording
+	status, page := search("")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, 1+1+3+7+1, page.Total)
+	assert.Equal(t, NUM_DEF_SEARCH_LIMIT, page.Limit)
+	assert.Equal(t, STR_KIND_CHANNEL, page.Results[0].Kind)
+
+	status, page = search("kind=ring&status=using&type=video")
+	assert.Equal(t, 1, page.Total)
+	assert.Equal(t, "lobby-cam", page.Results[0].Id)
+	assert.Equal(t, "image/jpeg", page.Results[0].Type)
+	assert.Equal(t, int64(1), page.Results[0].Stats.FramesIn)
+
+	status, page = search("kind=track&type=image/jpeg")
+	assert.Equal(t, 1, page.Total)
+	assert.Equal(t, "/stream/100/110/111", page.Results[0].Url)
+
+	status, page = search("name=LOBBY")
+	assert.Equal(t, 1, page.Total)
+
+	// live ones from 10 minutes ago, and the recording only until then
+	status, page = search("kind=ring,recording&from=-10m")
+	assert.Equal(t, 1, page.Total)
+	assert.Equal(t, STR_KIND_RING, page.Results[0].Kind)
+	status, page = search("kind=ring,recording&to=-10m")
+	assert.Equal(t, 1, page.Total)
+	assert.Equal(t, "record/output.mjpg", page.Results[0].Id)
+	assert.Equal(t, "/media/record/output.mjpg", page.Results[0].Url)
+	assert.Equal(t, "image/jpeg", page.Results[0].Type)
+
+	// listed again only when invalidated
+	ioutil.WriteFile(filepath.Join(root, "record", "more.mjpg"), data, 0644)
+	status, page = search("kind=recording")
+	assert.Equal(t, 1, page.Total)
+	sc.InvalidateRecordings()
+	status, page = search("kind=recording")
+	assert.Equal(t, 2, page.Total)
+	assert.Equal(t, "record/more.mjpg", page.Results[0].Id)
+
+	// paged
+	status, page = search("kind=ring&offset=5&limit=2")
+	assert.Equal(t, 7, page.Total)
+	assert.Equal(t, 2, len(page.Results))
+	assert.Equal(t, 5, page.Offset)
+	assert.Equal(t, "2", page.Results[0].Id)
+	status, page = search("kind=ring&offset=10")
+	assert.Equal(t, 7, page.Total)
+	assert.Equal(t, 0, len(page.Results))
+
+	for _, query := range []string{"kind=tv", "from=yesterday", "limit=0", "offset=-1"} {
+		status, _ = search(query)
+		assert.Equal(t, http.StatusBadRequest, status, query)
+	}
+}
+
+//---------------------------------------------------------------------------
+func TestApiHandlers(t *testing.T) {
+	sc := NewServerConfig()
+	mux := http.NewServeMux()
+	mux.HandleFunc(STR_API_PREFIX+"rings", sc.ApiRingsHandler)
+	mux.HandleFunc(STR_API_PREFIX+"rings/", sc.ApiRingsHandler)
+	mux.HandleFunc(STR_API_PREFIX+"actors", sc.ApiActorsHandler)
+	mux.HandleFunc(STR_API_PREFIX+"actors/", sc.ApiActorsHandler)
+	mux.HandleFunc(STR_API_PREFIX+"channels/", sc.ApiChannelsHandler)
+	mux.HandleFunc("/command", sc.CommandHandler)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	call := func(method string, path string, body string, v interface{}) *http.Response {
+		req, _ := http.NewRequest(method, ts.URL+path, bytes.NewBufferString(body))
+		res, err := http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		defer res.Body.Close()
+		if v != nil {
+			json.NewDecoder(res.Body).Decode(v)
+		}
+		return res
+	}
+
+	// rings created, changed and removed
+	ri := &RingInfo{}
+	res := call("POST", "/api/v1/rings", `{"name":"lobby-cam","num":4,"size":1024,"policy":"block-writer","timeout":"2s","retention":"30s"}`, ri)
+	assert.Equal(t, http.StatusCreated, res.StatusCode)
+	assert.Equal(t, "/api/v1/rings/lobby-cam", res.Header.Get("Location"))
+	assert.Equal(t, 4, ri.Max)
+	assert.Equal(t, "Idle", ri.Status)
+	assert.Equal(t, "block-writer", ri.Policy)
+	assert.Equal(t, "2s", ri.Timeout)
+	assert.Equal(t, "30s", ri.Retention)
+	assert.False(t, ri.Pinned)
+
+	ae := &ApiError{}
+	res = call("POST", "/api/v1/rings", `{"name":"lobby-cam"}`, ae)
+	assert.Equal(t, http.StatusConflict, res.StatusCode)
+	assert.Equal(t, http.StatusConflict, ae.Status)
+	res = call("POST", "/api/v1/rings", `{"name":"../etc"}`, nil)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	res = call("POST", "/api/v1/rings", `{"nam":"typo"}`, nil)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	res = call("POST", "/api/v1/rings", `{"name":"hall-cam","policy":"drop-all"}`, nil)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	res = call("POST", "/api/v1/rings", `{"name":"hall-cam","mode":"mirror"}`, nil)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	// frames shared with the players, not copied
+	shared := &RingInfo{}
+	res = call("POST", "/api/v1/rings", `{"name":"hall-cam","mode":"shared"}`, shared)
+	assert.Equal(t, http.StatusCreated, res.StatusCode)
+	assert.Equal(t, "shared", shared.Mode)
+	assert.Equal(t, "copy", ri.Mode)
+	res = call("PATCH", "/api/v1/rings/hall-cam", `{"mode":"copy"}`, nil)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	res = call("DELETE", "/api/v1/rings/hall-cam", "", nil)
+	assert.Equal(t, http.StatusNoContent, res.StatusCode)
+
+	// slots sized to the frames in the budget of num x size
+	res = call("POST", "/api/v1/rings", `{"name":"hall-cam","mode":"bytes","num":100,"size":1024}`, shared)
+	assert.Equal(t, http.StatusCreated, res.StatusCode)
+	assert.Equal(t, "bytes", shared.Mode)
+	assert.Equal(t, sr.NUM_DEF_SLOTS, shared.Max)
+	res = call("DELETE", "/api/v1/rings/hall-cam", "", nil)
+	assert.Equal(t, http.StatusNoContent, res.StatusCode)
+
+	res = call("PATCH", "/api/v1/rings/lobby-cam", `{"num":8,"status":"using"}`, ri)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, 8, ri.Max)
+	assert.Equal(t, "Using", ri.Status)
+	res = call("PATCH", "/api/v1/rings/lobby-cam", `{"num":1}`, nil)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	res = call("PATCH", "/api/v1/rings/lobby-cam", `{"timeout":"500ms"}`, ri)
+	assert.Equal(t, "block-writer", ri.Policy)
+	assert.Equal(t, "500ms", ri.Timeout)
+	res = call("PATCH", "/api/v1/rings/lobby-cam", `{"policy":"drop-newest"}`, ri)
+	assert.Equal(t, "drop-newest", ri.Policy)
+	res = call("PATCH", "/api/v1/rings/lobby-cam", `{"timeout":"-1s"}`, nil)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	kept := &RingInfo{}
+	res = call("PATCH", "/api/v1/rings/lobby-cam", `{"retention":"0"}`, kept)
+	assert.Equal(t, "drop-newest", kept.Policy)
+	assert.Equal(t, "", kept.Retention)
+	res = call("PATCH", "/api/v1/rings/lobby-cam", `{"retention":"soon"}`, nil)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	// nothing applied if any is wrong
+	res = call("PATCH", "/api/v1/rings/lobby-cam", `{"num":12,"policy":"drop-oldest","status":"idle","retention":"soon"}`, nil)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	res = call("PATCH", "/api/v1/rings/lobby-cam", `{"num":12,"status":"using"}`, nil)
+	assert.Equal(t, http.StatusConflict, res.StatusCode)
+	res = call("PATCH", "/api/v1/rings/lobby-cam", `{"num":12,"spill":""}`, nil)
+	assert.Equal(t, http.StatusConflict, res.StatusCode)
+	res = call("GET", "/api/v1/rings/lobby-cam", "", ri)
+	assert.Equal(t, 8, ri.Max)
+	assert.Equal(t, "drop-newest", ri.Policy)
+	assert.Equal(t, "Using", ri.Status)
+	res = call("PATCH", "/api/v1/rings/no-cam", `{}`, nil)
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+
+	var rings []RingInfo
+	res = call("GET", "/api/v1/rings", "", &rings)
+	assert.Equal(t, 7, len(rings))
+	res = call("GET", "/api/v1/rings/100/110/111", "", ri)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.True(t, ri.Pinned)
+
+	res = call("DELETE", "/api/v1/rings/100/110/111", "", nil)
+	assert.Equal(t, http.StatusConflict, res.StatusCode)
+	res = call("DELETE", "/api/v1/rings/lobby-cam", "", nil)
+	assert.Equal(t, http.StatusNoContent, res.StatusCode)
+	res = call("DELETE", "/api/v1/rings/lobby-cam", "", nil)
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+	res = call("PUT", "/api/v1/rings/0", "", nil)
+	assert.Equal(t, http.StatusMethodNotAllowed, res.StatusCode)
+	assert.Equal(t, "GET, HEAD, PATCH, DELETE", res.Header.Get("Allow"))
+
+	// actors started and stopped
+	ai := &ActorInfo{}
+	res = call("POST", "/api/v1/actors", `{"type":"combiner","mode":"mosaic","rings":["0","1"],"out":"wall"}`, ai)
+	assert.Equal(t, http.StatusCreated, res.StatusCode)
+	assert.Equal(t, "Run", ai.Status)
+	assert.Contains(t, ai.Desc, "combiner (0,1 -> wall)")
+
+	res = call("POST", "/api/v1/actors", `{"type":"dir_reader","ring":"no-cam","file":"*.jpg"}`, nil)
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+	res = call("POST", "/api/v1/actors", `{"type":"http_reader","ring":"0"}`, nil)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	res = call("POST", "/api/v1/actors", `{"type":"teleport","ring":"0"}`, nil)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	var actors []ActorInfo
+	call("GET", "/api/v1/actors", "", &actors)
+	assert.Equal(t, 1, len(actors))
+	res = call("DELETE", "/api/v1/actors/"+ai.Id, "", nil)
+	assert.Equal(t, http.StatusNoContent, res.StatusCode)
+	res = call("GET", "/api/v1/actors/"+ai.Id, "", ai)
+	assert.Equal(t, "Close", ai.Status)
+	res = call("DELETE", "/api/v1/actors/"+ai.Id, "", nil)
+	assert.Equal(t, http.StatusConflict, res.StatusCode)
+	res = call("DELETE", "/api/v1/actors/0", "", nil)
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+
+	// channels
+	ci := &ChannelInfo{}
+	res = call("GET", "/api/v1/channels/100", "", ci)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "100/110/111", ci.Sources[0].Tracks[0].Ring)
+	assert.Equal(t, "video", ci.Sources[0].Tracks[0].Type)
+	res = call("GET", "/api/v1/channels/200", "", nil)
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+
+	// the text of /command is kept
+	answer := func(query string) string {
+		res, err := http.Post(ts.URL+"/command?"+query, "text/plain", nil)
+		assert.Nil(t, err)
+		data, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		return string(data)
+	}
+	assert.Contains(t, answer("op=resize&obj=ring&id=1&num=6"), "resized the ring 1 to 6 slots")
+	assert.Equal(t, 6, sc.Array[1].Cap())
+	assert.Contains(t, answer("op=resize&obj=ring&id=9&num=6"), "error:")
+	assert.Contains(t, answer("op=stop&obj=actor&id=0"), "actor 0 not exist")
+	assert.Contains(t, answer("op=start&obj=combiner&ids=0,2&out=wall2"), "order to start combiner (0,2 -> wall2)")
+	assert.Contains(t, answer("op=start&obj=dir_reader&file=*.jpg&id=9"), "error: dir_reader (*.jpg, 9)")
+	assert.Contains(t, answer("op=close&obj=ring&name=wall2"), "removed the ring: wall2")
+	assert.Contains(t, answer("op=close&obj=ring&name=0"), "error:")
+	assert.Contains(t, answer("op=start&obj=teleport"), "what obj to start?")
+
+	for _, actor := range sc.GetActors() {
+		actor.SetStatusClose()
+	}
+}
@@ -0,0 +1,325 @@
+//=========================================================================
+// Author : Stoney Kang, sikang99@gmail.com, 2015
+// Upload of files in multipart/form-data into the media store
+// - the type of a file is sniffed from its content, only media is accepted
+// - images may be published to the ring as frames, ex) ?ring=phone
+//=========================================================================
+
+package mediaconf
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	ph "stoney/httpserver/src/protohttp"
+
+	sb "stoney/httpserver/src/streambase"
+	sr "stoney/httpserver/src/streamring"
+)
+
+//---------------------------------------------------------------------------
+// file stored by the upload
+//---------------------------------------------------------------------------
+type UploadFile struct {
+	Name string `json:"name"`
+	Path string `json:"path"` // relative to the media root
+	Type string `json:"type"`
+	Size int64  `json:"size"`
+	Seq  int64  `json:"seq"` // sequence in the ring published, -1 if not
+}
+
+//---------------------------------------------------------------------------
+// get the path of the media store, not to go out of the root
+//---------------------------------------------------------------------------
+func (sc *ServerConfig) GetMediaPath(rel string) (string, error) {
+	if strings.ContainsRune(rel, 0) {
+		return "", sb.ErrValue
+	}
+
+	// cleaned as absolute, ".." can not go above the root
+	clean := filepath.Clean("/" + filepath.FromSlash(rel))
+
+	return filepath.Join(sc.Root, clean), nil
+}
+
+//---------------------------------------------------------------------------
+// check the type sniffed is of media
+//---------------------------------------------------------------------------
+func IsMediaType(ctype string) bool {
+	for _, prefix := range []string{"image/", "video/", "audio/", "application/ogg"} {
+		if strings.HasPrefix(ctype, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+//---------------------------------------------------------------------------
+// get the name of the file uploaded without the path of the client
+//---------------------------------------------------------------------------
+func GetUploadName(name string) (string, error) {
+	name = filepath.Base(strings.Replace(name, "\\", "/", -1))
+	if name == "" || name == "." || name == "/" || strings.HasPrefix(name, ".") || IsSecretPath(name) {
+		return "", sb.ErrValue
+	}
+	return name, nil
+}
+
+//---------------------------------------------------------------------------
+// handle /upload access, files in multipart/form-data
+// - dir  : directory under the media root, ex) ?dir=photo
+// - ring : publish the images to the ring, ex) ?ring=phone
+//---------------------------------------------------------------------------
+func (sc *ServerConfig) UploadHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("handle /upload %s for %s to %s\n", r.Method, r.RequestURI, r.Host)
+	defer r.Body.Close()
+
+	var err error
+
+	if r.Method != "POST" {
+		ph.WriteResponseMessage(w, http.StatusMethodNotAllowed, "not allowed: "+r.Method)
+		return
+	}
+
+	query := r.URL.Query()
+
+	rel := query.Get("dir")
+	if rel == "" {
+		rel = STR_UPLOAD_DIR
+	}
+	dir, err := sc.GetMediaPath(rel)
+	if err != nil {
+		ph.WriteResponseMessage(w, http.StatusBadRequest, "invalid dir: "+rel)
+		return
+	}
+
+	// the ring is made only when the files are saved
+	name := query.Get("ring")
+	if name != "" && sr.CheckRingName(name) != nil {
+		ph.WriteResponseMessage(w, http.StatusBadRequest, "invalid ring: "+name)
+		return
+	}
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		ph.WriteResponseMessage(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		log.Println(err)
+		ph.WriteResponseMessage(w, http.StatusInternalServerError, "can not make dir: "+rel)
+		return
+	}
+
+	// all or nothing, the files saved are removed if any other fails
+	var files []UploadFile
+	fail := func(status int, msg string) {
+		for _, uf := range files {
+			os.Remove(filepath.Join(dir, uf.Name))
+		}
+		if len(files) > 0 {
+			msg += fmt.Sprintf(", %d saved are removed", len(files))
+		}
+		ph.WriteResponseMessage(w, status, msg)
+	}
+
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fail(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		// form fields are ignored
+		if p.FileName() == "" {
+			p.Close()
+			continue
+		}
+		if len(files) >= NUM_MAX_UPLOAD_FILES {
+			fail(http.StatusRequestEntityTooLarge, fmt.Sprintf("over %d files", NUM_MAX_UPLOAD_FILES))
+			return
+		}
+
+		uf, err := sc.saveUpload(dir, p)
+		p.Close()
+		if err != nil {
+			status := http.StatusInternalServerError
+			switch err {
+			case sb.ErrValue:
+				status = http.StatusBadRequest
+			case sb.ErrSize:
+				status = http.StatusRequestEntityTooLarge
+			case sb.ErrSupport:
+				status = http.StatusUnsupportedMediaType
+			}
+			fail(status, fmt.Sprintf("%s: %s", p.FileName(), err))
+			return
+		}
+		uf.Path = filepath.ToSlash(filepath.Join(rel, uf.Name))
+
+		files = append(files, *uf)
+	}
+
+	if len(files) == 0 {
+		ph.WriteResponseMessage(w, http.StatusBadRequest, "no file to upload")
+		return
+	}
+
+	// published only when all are saved, as the caster of the ring
+	var images []*UploadFile
+	for i := range files {
+		if name != "" && strings.HasPrefix(files[i].Type, "image/") {
+			images = append(images, &files[i])
+		}
+	}
+	if len(images) > 0 {
+		ring, _, err := sc.Rings.GetOrCreate(name)
+		if err != nil {
+			fail(http.StatusBadRequest, "invalid ring: "+name)
+			return
+		}
+		err = ring.SetStatusUsing()
+		if err != nil {
+			fail(http.StatusConflict, "ring is used by other caster: "+name)
+			return
+		}
+		defer ring.SetStatusIdleKept()
+
+		for _, uf := range images {
+			uf.Seq, err = PublishFile(ring, filepath.Join(dir, uf.Name), uf.Type)
+			if err != nil {
+				log.Println(err)
+			}
+		}
+	}
+
+	sc.InvalidateRecordings()
+	ph.WriteResponseJson(w, http.StatusCreated, files)
+}
+
+//---------------------------------------------------------------------------
+// save the file part into the directory, not over the max size and not
+// to overwrite others, the name is changed if used, ex) a.jpg -> a-1.jpg
+//---------------------------------------------------------------------------
+func (sc *ServerConfig) saveUpload(dir string, p *multipart.Part) (*UploadFile, error) {
+	var err error
+
+	name, err := GetUploadName(p.FileName())
+	if err != nil {
+		return nil, err
+	}
+
+	// sniff the type by the head
+	head := make([]byte, 512)
+	n, err := io.ReadFull(p, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		log.Println(err)
+		return nil, err
+	}
+	head = head[:n]
+
+	ctype := http.DetectContentType(head)
+	if !IsMediaType(ctype) {
+		log.Printf("%s of %s is not media\n", name, ctype)
+		return nil, sb.ErrSupport
+	}
+
+	f, err := ioutil.TempFile(dir, ".upload-")
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp) // the name linked is left
+
+	f.Write(head)
+	size, err := io.Copy(f, io.LimitReader(p, sc.UploadMax-int64(n)+1))
+	f.Close()
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	size += int64(n)
+	if size > sc.UploadMax {
+		log.Printf("%s is over %d bytes\n", name, sc.UploadMax)
+		return nil, sb.ErrSize
+	}
+
+	// the name is taken by the link failing if used, not to be raced
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 1; ; i++ {
+		err = os.Link(tmp, filepath.Join(dir, name))
+		if err != nil && !os.IsExist(err) {
+			// no hard link in the file system, copied to the file made only if not exist
+			err = copyFileExcl(tmp, filepath.Join(dir, name))
+		}
+		if !os.IsExist(err) {
+			break
+		}
+		name = fmt.Sprintf("%s-%d%s", base, i, ext)
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return &UploadFile{Name: name, Type: ctype, Size: size, Seq: -1}, nil
+}
+
+//---------------------------------------------------------------------------
+// copy the file to the new one, os.IsExist if the name is used
+//---------------------------------------------------------------------------
+func copyFileExcl(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dst) // not to be left in part
+	}
+
+	return err
+}
+
+//---------------------------------------------------------------------------
+// publish the file to the ring as a frame and return the sequence of it,
+// the ring must be used by the caller as the caster
+//---------------------------------------------------------------------------
+func PublishFile(ring *sr.StreamRing, file string, ctype string) (int64, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return -1, err
+	}
+
+	slot := sr.NewStreamSlotByData(len(data), ctype, len(data), data)
+	slot.Timestamp = sb.GetTimestampNow()
+	slot.Keyframe = true
+
+	return ring.PutSlotInNextSeq(slot)
+}
+
+// ---------------------------------E-----N-----D--------------------------------
@@ -7,7 +7,10 @@
 package protobase
 
 import (
+	"context"
 	"fmt"
+	"math/rand"
+	"sync"
 	"time"
 
 	tomb "gopkg.in/tomb.v2"
@@ -16,11 +19,19 @@ import (
 	si "stoney/httpserver/src/streaminfo"
 )
 
+//---------------------------------------------------------------------------
+const (
+	TIME_MIN_BACKOFF = 500 * time.Millisecond // first wait to reconnect
+	TIME_MAX_BACKOFF = 30 * time.Second       // max wait to reconnect
+)
+
 //---------------------------------------------------------------------------
 type ProtoBase struct {
+	mu   sync.Mutex    // guards the status and the reconnecting part
+	done chan struct{} // closed when it stops running
 	// mandatory part
 	Boundary string
-	Status   int // run state
+	Status   int // run state, use GetStatus() while running
 	// optional part
 	Id       string
 	URI      string
@@ -32,6 +43,10 @@ type ProtoBase struct {
 	Desc     string
 	Tomb     tomb.Tomb
 	Sign     chan string // signaling for state control
+	// reconnecting part, use GetReconnects() and GetLastError() while running
+	Reconnects int    // number of reconnects after the first connect
+	LastError  string // last error to reconnect by
+	LastTime   time.Time
 }
 
 //---------------------------------------------------------------------------
@@ -51,9 +66,17 @@ func NewProtoBase() *ProtoBase {
 // string ProtoBase information
 //---------------------------------------------------------------------------
 func (pb *ProtoBase) String() string {
+	status := pb.GetStatus()
+	reconnects := pb.GetReconnects()
+	lastError, lastTime := pb.GetLastError()
+
 	str := fmt.Sprintf("\tId: %s", pb.Id)
-	str += fmt.Sprintf("\tStatus: %s(%d)", sb.StatusText[pb.Status], pb.Status)
+	str += fmt.Sprintf("\tStatus: %s(%d)", sb.StatusText[status], status)
 	str += fmt.Sprintf("\tDesc: %s", pb.Desc)
+	if reconnects > 0 || lastError != "" {
+		str += fmt.Sprintf("\tReconnects: %d", reconnects)
+		str += fmt.Sprintf("\tLastError: %s (%s)", lastError, lastTime.Format(time.RFC3339))
+	}
 	/*
 		str += fmt.Sprintf("\tScheme: %s", pb.Scheme)
 		str += fmt.Sprintf("\tUser: %s", pb.User)
@@ -68,45 +91,184 @@ func (pb *ProtoBase) String() string {
 // check status of struct
 //---------------------------------------------------------------------------
 func (pb *ProtoBase) IsRun() bool {
-	if pb.Status == sb.STATUS_RUN {
-		return true
-	} else {
-		return false
-	}
+	return pb.GetStatus() == sb.STATUS_RUN
+}
+
+func (pb *ProtoBase) GetStatus() int {
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+
+	return pb.Status
 }
 
 func (pb *ProtoBase) Reset() {
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+
+	if pb.Status == sb.STATUS_RUN && pb.done != nil {
+		close(pb.done)
+	}
 	pb.Status = sb.STATUS_IDLE
 }
 
+//---------------------------------------------------------------------------
+// get the channel closed when it stops running, closed already if not run
+//---------------------------------------------------------------------------
+func (pb *ProtoBase) Done() <-chan struct{} {
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+
+	if pb.done == nil {
+		pb.done = make(chan struct{})
+		if pb.Status != sb.STATUS_RUN {
+			close(pb.done)
+		}
+	}
+	return pb.done
+}
+
+//---------------------------------------------------------------------------
+// get the context canceled when it stops running or cancel is called
+//---------------------------------------------------------------------------
+func (pb *ProtoBase) NewContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := pb.Done()
+
+	go func() {
+		select {
+		case <-done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, cancel
+}
+
 //---------------------------------------------------------------------------
 // set status
 //---------------------------------------------------------------------------
 func (pb *ProtoBase) SetStatusRun() error {
-	if pb.Status == sb.STATUS_IDLE {
-		pb.Status = sb.STATUS_RUN
-		return nil
-	} else {
-		return sb.ErrStatus
-	}
+	return pb.setStatus(sb.STATUS_IDLE, sb.STATUS_RUN)
 }
 
 func (pb *ProtoBase) SetStatusIdle() error {
-	if pb.Status == sb.STATUS_RUN {
-		pb.Status = sb.STATUS_IDLE
-		return nil
-	} else {
-		return sb.ErrStatus
-	}
+	return pb.setStatus(sb.STATUS_RUN, sb.STATUS_IDLE)
 }
 
 func (pb *ProtoBase) SetStatusClose() error {
-	if pb.Status == sb.STATUS_RUN {
-		pb.Status = sb.STATUS_CLOSE
-		return nil
-	} else {
+	return pb.setStatus(sb.STATUS_RUN, sb.STATUS_CLOSE)
+}
+
+// change the status only from the one expected
+func (pb *ProtoBase) setStatus(from, to int) error {
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+
+	if pb.Status != from {
 		return sb.ErrStatus
 	}
+	if from == sb.STATUS_RUN && pb.done != nil {
+		close(pb.done)
+	}
+	if to == sb.STATUS_RUN {
+		pb.done = make(chan struct{})
+	}
+	pb.Status = to
+	return nil
+}
+
+//---------------------------------------------------------------------------
+// record the error to reconnect by
+//---------------------------------------------------------------------------
+func (pb *ProtoBase) SetError(err error) {
+	if err == nil {
+		return
+	}
+
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+
+	pb.LastError = err.Error()
+	pb.LastTime = time.Now()
+}
+
+func (pb *ProtoBase) GetLastError() (string, time.Time) {
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+
+	return pb.LastError, pb.LastTime
+}
+
+//---------------------------------------------------------------------------
+// count the reconnects after the first connect
+//---------------------------------------------------------------------------
+func (pb *ProtoBase) AddReconnect() {
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+
+	pb.Reconnects++
+}
+
+func (pb *ProtoBase) GetReconnects() int {
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+
+	return pb.Reconnects
+}
+
+//---------------------------------------------------------------------------
+// sleep for the duration, return false if stopped while sleeping
+//---------------------------------------------------------------------------
+func (pb *ProtoBase) SleepWhileRun(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-pb.Done():
+		return false
+	case <-timer.C:
+		return pb.IsRun()
+	}
+}
+
+//---------------------------------------------------------------------------
+// exponential backoff with jitter for reconnecting
+//---------------------------------------------------------------------------
+type Backoff struct {
+	Min     time.Duration
+	Max     time.Duration
+	Attempt int
+}
+
+func NewBackoff(min, max time.Duration) *Backoff {
+	return &Backoff{Min: min, Max: max}
+}
+
+//---------------------------------------------------------------------------
+// get the time to wait for the next attempt, doubled at each attempt up to
+// max, and randomized in [d/2, d) not to reconnect at the same time
+//---------------------------------------------------------------------------
+func (bo *Backoff) Next() time.Duration {
+	d := bo.Min
+	for i := 0; i < bo.Attempt && d < bo.Max; i++ {
+		d *= 2
+	}
+	if d > bo.Max {
+		d = bo.Max
+	}
+	bo.Attempt++
+
+	half := d / 2
+	if half <= 0 {
+		return d
+	}
+	return half + time.Duration(rand.Int63n(int64(half)))
+}
+
+// start again from min after connected successfully
+func (bo *Backoff) Reset() {
+	bo.Attempt = 0
 }
 
 //---------------------------------------------------------------------------
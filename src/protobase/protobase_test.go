@@ -10,6 +10,8 @@ import (
 	"testing"
 	"time"
 
+	"github.com/stretchr/testify/assert"
+
 	sb "stoney/httpserver/src/streambase"
 )
 
@@ -46,4 +48,71 @@ func TestHandleInfo(t *testing.T) {
 	sb.Trace()
 }
 
+//---------------------------------------------------------------------------------
+// test for backoff to reconnect
+//---------------------------------------------------------------------------------
+func TestBackoff(t *testing.T) {
+	bo := NewBackoff(100*time.Millisecond, time.Second)
+	for _, max := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		max *= time.Millisecond
+		d := bo.Next()
+		assert.True(t, d >= max/2 && d < max)
+	}
+
+	bo.Reset()
+	assert.True(t, bo.Next() < 100*time.Millisecond)
+
+	pb := NewProtoBase()
+	pb.SetStatusRun()
+	pb.AddReconnect()
+	pb.SetError(sb.ErrTimeout)
+	t.Log(pb)
+
+	last, _ := pb.GetLastError()
+	assert.Equal(t, 1, pb.GetReconnects())
+	assert.Equal(t, sb.ErrTimeout.Error(), last)
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		pb.SetStatusClose()
+	}()
+	assert.False(t, pb.SleepWhileRun(time.Second))
+	assert.Equal(t, sb.STATUS_CLOSE, pb.GetStatus())
+}
+
+//---------------------------------------------------------------------------------
+// test for the done channel and the context closed with the status
+//---------------------------------------------------------------------------------
+func TestDone(t *testing.T) {
+	pb := NewProtoBase()
+	<-pb.Done() // closed if not run
+
+	pb.SetStatusRun()
+	ctx, cancel := pb.NewContext()
+	defer cancel()
+	select {
+	case <-ctx.Done():
+		t.Fatal("canceled while running")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	start := time.Now()
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		pb.SetStatusIdle()
+	}()
+	<-ctx.Done()
+	assert.True(t, time.Since(start) < 500*time.Millisecond)
+
+	// run again with a new channel
+	pb.SetStatusRun()
+	select {
+	case <-pb.Done():
+		t.Fatal("done while running")
+	default:
+	}
+	pb.Reset()
+	<-pb.Done()
+}
+
 //----------------------------------E-----N-----D----------------------------------
@@ -17,7 +17,6 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
-	"strconv"
 	"time"
 
 	pb "stoney/httpserver/src/protobase"
@@ -217,42 +216,7 @@ func ReadDirToRing(pb *pb.ProtoBase, ring *sr.StreamRing, pat string, loop bool)
 // read multipart file to the ring buffer
 //---------------------------------------------------------------------------
 func ReadPartToSlot(mr *multipart.Reader, ss *sr.StreamSlot) error {
-	var err error
-
-	p, err := mr.NextPart()
-	if err != nil { // io.EOF
-		log.Println(err)
-		return err
-	}
-
-	sl := p.Header.Get(sb.STR_HDR_CONTENT_LENGTH)
-	nl, err := strconv.Atoi(sl)
-	if err != nil {
-		log.Printf("%s %s %d\n", p.Header, sl, nl)
-		return err
-	}
-
-	ss.Length = 0
-
-	var tn int
-	for tn < nl {
-		n, err := p.Read(ss.Content[tn:])
-		if err != nil {
-			log.Println(err)
-			return err
-		}
-		tn += n
-	}
-
-	ss.Length = nl
-	ss.Type = p.Header.Get(sb.STR_HDR_CONTENT_TYPE)
-	ss.Timestamp = sb.GetTimestampNow()
-	//fmt.Println(ss)
-
-	ts := p.Header.Get(sb.STR_HDR_TIMESTAMP)
-	ss.Timestamp, err = strconv.ParseInt(ts, 10, 64)
-
-	return err
+	return sr.ReadPartToSlot(mr, ss)
 }
 
 //---------------------------------------------------------------------------
@@ -277,13 +241,18 @@ func ReadMultipartFileToRing(pb *pb.ProtoBase, ring *sr.StreamRing, file string)
 	}
 	defer ring.SetStatusIdle()
 
-	mr := multipart.NewReader(f, ring.Boundary)
+	mr := sr.NewPartReader(f, ring.GetBoundary())
 
 	var preTimestamp int64 = 0
 	for ring.IsUsing() && pb.IsRun() {
 		slot, pos := ring.GetSlotIn()
 
 		err = ReadPartToSlot(mr, slot)
+		if err == sb.ErrSize {
+			ring.CountOversize()
+			err = nil
+			continue
+		}
 		if err != nil {
 			log.Println(err)
 			break
@@ -317,14 +286,36 @@ func WriteRingToMultipartFile(pb *pb.ProtoBase, ring *sr.StreamRing, file string
 	defer f.Close()
 
 	// write ring buffer to file
-	var pos int
+	// a recorder must not lose a frame
+	rr := ring.NewRingReader()
+	defer rr.Close()
+	rr.SetLossless(true)
+
+	// not to wait for a frame after it stops
+	ctx, cancel := pb.NewContext()
+	defer cancel()
+
+	slot := sr.NewStreamSlotFromPool(ring.Size)
+	defer slot.Free()
+
+	var skip int
 	for ring.IsUsing() && pb.IsRun() {
-		slot, npos, err := ring.GetSlotNextByPos(pos)
+		skip, err = rr.WaitSlotTo(ctx, sb.TIME_DEF_BLOCK, slot)
 		if err != nil {
-			if err == sb.ErrEmpty {
-				time.Sleep(sb.TIME_DEF_WAIT)
+			// not to be returned when the ring is over in the meantime
+			if err == sb.ErrEmpty || err == sb.ErrTimeout {
+				err = nil
 				continue
 			}
+			if err == sb.ErrOverrun {
+				log.Printf("overrun, %d slots skipped\n", skip)
+				err = nil
+				continue
+			}
+			if err == ctx.Err() {
+				err = nil // stopped, not a failure
+				break
+			}
 			log.Println(err)
 			break
 		}
@@ -333,10 +324,13 @@ func WriteRingToMultipartFile(pb *pb.ProtoBase, ring *sr.StreamRing, file string
 		//err = WriteSlotToFile(out, slot, ring.Boundary)
 
 		w := bufio.NewWriter(f)
-		err = WriteSlotToHandle(w, slot, ring.Boundary)
+		err = WriteSlotToHandle(w, slot, ring.GetBoundary())
+		if err != nil {
+			log.Println(err)
+			break
+		}
 
 		//fmt.Println("MW", slot)
-		pos = npos
 	}
 
 	return err
@@ -404,11 +398,7 @@ func WriteSlotToFile(f *os.File, ss *sr.StreamSlot, boundary string) error {
 func WriteSlotToHandle(w *bufio.Writer, ss *sr.StreamSlot, boundary string) error {
 	var err error
 
-	str := fmt.Sprintf("--%s\r\n", boundary)
-	str += fmt.Sprintf("%s: %s\r\n", sb.STR_HDR_CONTENT_TYPE, ss.Type)
-	str += fmt.Sprintf("%s: %d\r\n", sb.STR_HDR_CONTENT_LENGTH, ss.Length)
-	str += fmt.Sprintf("%s: %v\r\n", sb.STR_HDR_TIMESTAMP, ss.Timestamp)
-	str += "\r\n"
+	str := GetSlotPartHeader(ss, boundary)
 
 	_, err = w.WriteString(str)
 	_, err = w.Write(ss.Content[:ss.Length])
@@ -418,4 +408,22 @@ func WriteSlotToHandle(w *bufio.Writer, ss *sr.StreamSlot, boundary string) erro
 	return err
 }
 
+//---------------------------------------------------------------------------
+// get the header of the part for the slot
+//---------------------------------------------------------------------------
+func GetSlotPartHeader(ss *sr.StreamSlot, boundary string) string {
+	str := fmt.Sprintf("--%s\r\n", boundary)
+	str += fmt.Sprintf("%s: %s\r\n", sb.STR_HDR_CONTENT_TYPE, ss.Type)
+	str += fmt.Sprintf("%s: %d\r\n", sb.STR_HDR_CONTENT_LENGTH, ss.Length)
+	str += fmt.Sprintf("%s: %v\r\n", sb.STR_HDR_TIMESTAMP, ss.Timestamp)
+	if ss.Keyframe {
+		str += fmt.Sprintf("%s: 1\r\n", sb.STR_HDR_KEYFRAME)
+	}
+	if ss.Origin != "" {
+		str += fmt.Sprintf("%s: %s\r\n", sb.STR_HDR_ORIGIN, ss.Origin)
+	}
+	str += "\r\n"
+	return str
+}
+
 // ---------------------------------E-----N-----D--------------------------------
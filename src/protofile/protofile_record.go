@@ -0,0 +1,131 @@
+//=================================================================================
+// Author: Stoney Kang, sikang99@gmail.com, 2015
+// Recordings in multipart files, ex) by file_writer or the spill segments
+// - the type and the start time are taken from the first part of the file
+//==================================================================================
+
+package protofile
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	sb "stoney/httpserver/src/streambase"
+	sr "stoney/httpserver/src/streamring"
+)
+
+//---------------------------------------------------------------------------
+var RecordExts = []string{".mjpg", ".mjpeg"} // extensions of the recordings
+
+//---------------------------------------------------------------------------
+// recording found
+//---------------------------------------------------------------------------
+type Recording struct {
+	Name  string
+	Path  string // relative to the directory searched
+	Size  int64
+	Type  string    // type of the first part
+	Start time.Time // time of the first part, modified time if unknown
+	End   time.Time // modified time
+}
+
+//---------------------------------------------------------------------------
+// check the file is a recording by the extension
+//---------------------------------------------------------------------------
+func IsRecordFile(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, re := range RecordExts {
+		if ext == re {
+			return true
+		}
+	}
+	return false
+}
+
+//---------------------------------------------------------------------------
+// list the recordings under the directory in the order of the path,
+// hidden files and directories are skipped
+//---------------------------------------------------------------------------
+func ListRecordings(dir string) ([]Recording, error) {
+	var recs []Recording
+
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			log.Println(err)
+			return nil // skip the unreadable
+		}
+		if path != dir && strings.HasPrefix(fi.Name(), ".") {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if fi.IsDir() || !IsRecordFile(fi.Name()) {
+			return nil
+		}
+
+		rel, _ := filepath.Rel(dir, path)
+		rec := Recording{
+			Name:  fi.Name(),
+			Path:  filepath.ToSlash(rel),
+			Size:  fi.Size(),
+			Start: fi.ModTime(),
+			End:   fi.ModTime(),
+		}
+
+		ctype, ts, err := ReadRecordHead(path)
+		if err == nil {
+			rec.Type = ctype
+			if ts > 0 {
+				rec.Start = time.Unix(0, 0).Add(sb.GetDuration(ts))
+			}
+		}
+
+		recs = append(recs, rec)
+		return nil
+	})
+
+	sort.Slice(recs, func(i, j int) bool { return recs[i].Path < recs[j].Path })
+
+	return recs, err
+}
+
+//---------------------------------------------------------------------------
+// read the type and the timestamp of the first part in the file,
+// the boundary is taken from the first line
+//---------------------------------------------------------------------------
+func ReadRecordHead(file string) (string, int64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	line, err := br.ReadString('\n')
+	if err != nil {
+		return "", 0, err
+	}
+	boundary := strings.TrimPrefix(strings.TrimSpace(line), "--")
+	if boundary == "" {
+		return "", 0, sb.ErrFound
+	}
+
+	mr := sr.NewPartReader(io.MultiReader(strings.NewReader(line), br), boundary)
+	p, err := mr.NextPart()
+	if err != nil {
+		return "", 0, err
+	}
+
+	ts := sb.GetTimestampFromString(strings.SplitN(p.Header.Get(sb.STR_HDR_TIMESTAMP), ";", 2)[0])
+
+	return p.Header.Get(sb.STR_HDR_CONTENT_TYPE), ts, nil
+}
+
+// ---------------------------------E-----N-----D--------------------------------
@@ -0,0 +1,410 @@
+//=================================================================================
+// Author: Stoney Kang, sikang99@gmail.com, 2015
+// Disk tier of the ring, the slots evicted are appended to segment files
+// in multipart format playable by file_reader, with a compact index
+//==================================================================================
+
+package protofile
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	sb "stoney/httpserver/src/streambase"
+	sr "stoney/httpserver/src/streamring"
+)
+
+//---------------------------------------------------------------------------
+const (
+	NUM_DEF_SEG_SLOTS = 300 // 10 sec at 30fps
+	NUM_DEF_SEG_FILES = 360 // 1 hour
+	NUM_MAX_SEG_OPEN  = 16  // segments kept open for reading, closed by LRU
+)
+
+//---------------------------------------------------------------------------
+// index record of a slot in the segment, 32 bytes in the index file
+//---------------------------------------------------------------------------
+type spillRecord struct {
+	Seq       int64
+	Timestamp int64
+	Offset    int64 // offset of the content in the segment file
+	Length    int32
+	Flags     int32 // 1 for keyframe
+}
+
+type spillEntry struct {
+	spillRecord
+	Type string
+}
+
+//---------------------------------------------------------------------------
+// segment file and its index
+//---------------------------------------------------------------------------
+type spillSegment struct {
+	Id    int
+	Path  string
+	Size  int64
+	file  *os.File // open for writing if current, or for reading
+	idx   *os.File // open only if current
+	index []spillEntry
+}
+
+func (sg *spillSegment) first() int64 {
+	if len(sg.index) == 0 {
+		return -1
+	}
+	return sg.index[0].Seq
+}
+
+func (sg *spillSegment) last() int64 {
+	if len(sg.index) == 0 {
+		return -1
+	}
+	return sg.index[len(sg.index)-1].Seq
+}
+
+//---------------------------------------------------------------------------
+type SpillFile struct {
+	sync.Mutex
+	Dir      string
+	Boundary string
+	SegSlots int   // number of slots in a segment
+	SegFiles int   // number of segments kept, the oldest is removed over it
+	Spilled  int64 // number of slots spilled
+	segs     []*spillSegment
+	opened   []*spillSegment // segments open for reading, the least recently used first
+	nextId   int
+	closed   bool
+}
+
+//---------------------------------------------------------------------------
+// string SpillFile information
+//---------------------------------------------------------------------------
+func (sf *SpillFile) String() string {
+	sf.Lock()
+	defer sf.Unlock()
+
+	str := fmt.Sprintf("[SpillFile] %s", sf.Dir)
+	str += fmt.Sprintf("\tSegments: %d/%d x %d", len(sf.segs), sf.SegFiles, sf.SegSlots)
+	str += fmt.Sprintf("\tSpilled: %d", sf.Spilled)
+	if len(sf.segs) > 0 {
+		str += fmt.Sprintf("\tSeq: %d-%d", sf.segs[0].first(), sf.segs[len(sf.segs)-1].last())
+	}
+	return str
+}
+
+//---------------------------------------------------------------------------
+// new SpillFile struct, segment files are made in the dir
+//---------------------------------------------------------------------------
+func NewSpillFile(dir string, boundary string, slots int, files int) (*SpillFile, error) {
+	var err error
+
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	if slots < 1 {
+		slots = NUM_DEF_SEG_SLOTS
+	}
+	if files < 1 {
+		files = NUM_DEF_SEG_FILES
+	}
+
+	sf := &SpillFile{
+		Dir:      dir,
+		Boundary: boundary,
+		SegSlots: slots,
+		SegFiles: files,
+	}
+
+	return sf, err
+}
+
+//---------------------------------------------------------------------------
+// append the slot evicted from the ring to the current segment
+//---------------------------------------------------------------------------
+func (sf *SpillFile) SpillSlot(ss *sr.StreamSlot) error {
+	sf.Lock()
+	defer sf.Unlock()
+
+	var err error
+
+	if sf.closed {
+		return sb.ErrStatus
+	}
+
+	// the ring was reset, its history is no more valid
+	if n := len(sf.segs); n > 0 && ss.Seq <= sf.segs[n-1].last() {
+		sf.clear()
+	}
+
+	n := len(sf.segs)
+	if n == 0 || len(sf.segs[n-1].index) >= sf.SegSlots {
+		err = sf.rotate()
+		if err != nil {
+			return err
+		}
+		n = len(sf.segs)
+	}
+	sg := sf.segs[n-1]
+
+	hdr := GetSlotPartHeader(ss, sf.Boundary)
+	data := make([]byte, 0, len(hdr)+ss.Length+2)
+	data = append(data, hdr...)
+	data = append(data, ss.Content[:ss.Length]...)
+	data = append(data, "\r\n"...)
+
+	_, err = sg.file.Write(data)
+	if err != nil {
+		return err
+	}
+
+	ent := spillEntry{Type: ss.Type}
+	ent.Seq = ss.Seq
+	ent.Timestamp = ss.Timestamp
+	ent.Offset = sg.Size + int64(len(hdr))
+	ent.Length = int32(ss.Length)
+	if ss.Keyframe {
+		ent.Flags = 1
+	}
+	sg.Size += int64(len(data))
+
+	err = binary.Write(sg.idx, binary.BigEndian, &ent.spillRecord)
+	if err != nil {
+		return err
+	}
+
+	sg.index = append(sg.index, ent)
+	sf.Spilled++
+
+	return err
+}
+
+//---------------------------------------------------------------------------
+// read the slot spilled into the given one
+//---------------------------------------------------------------------------
+func (sf *SpillFile) ReadSpilled(seq int64, ss *sr.StreamSlot) error {
+	sf.Lock()
+	defer sf.Unlock()
+
+	var err error
+
+	sg, ent := sf.find(seq)
+	if ent == nil {
+		return sb.ErrFound
+	}
+
+	f, err := sf.open(sg)
+	if err != nil {
+		return err
+	}
+
+	if len(ss.Content) < int(ent.Length) {
+		ss.Content = make([]byte, ent.Length)
+		ss.LengthMax = int(ent.Length)
+	}
+
+	_, err = f.ReadAt(ss.Content[:ent.Length], ent.Offset)
+	if err != nil {
+		return err
+	}
+
+	ss.Type = ent.Type
+	ss.Length = int(ent.Length)
+	ss.Timestamp = ent.Timestamp
+	ss.Seq = ent.Seq
+	ss.Keyframe = ent.Flags&1 != 0
+
+	return err
+}
+
+//---------------------------------------------------------------------------
+// get the sequence of the slot at or just before the time, the oldest one
+// if all are after it
+//---------------------------------------------------------------------------
+func (sf *SpillFile) SeqByTime(ts int64) (int64, error) {
+	sf.Lock()
+	defer sf.Unlock()
+
+	for i := len(sf.segs) - 1; i >= 0; i-- {
+		index := sf.segs[i].index
+		for j := len(index) - 1; j >= 0; j-- {
+			if index[j].Timestamp <= ts {
+				return index[j].Seq, nil
+			}
+		}
+	}
+
+	for _, sg := range sf.segs {
+		if len(sg.index) > 0 {
+			return sg.first(), nil
+		}
+	}
+
+	return 0, sb.ErrFound
+}
+
+//---------------------------------------------------------------------------
+// close and remove all the segments
+//---------------------------------------------------------------------------
+func (sf *SpillFile) Close() error {
+	sf.Lock()
+	defer sf.Unlock()
+
+	sf.clear()
+	sf.closed = true
+	return nil
+}
+
+//---------------------------------------------------------------------------
+// get the file of the segment to read, opened again if closed and the least
+// recently used is closed over NUM_MAX_SEG_OPEN, must be called in lock
+//---------------------------------------------------------------------------
+func (sf *SpillFile) open(sg *spillSegment) (*os.File, error) {
+	var err error
+
+	// the current one is open for writing
+	if sg.idx != nil {
+		return sg.file, nil
+	}
+
+	sf.forget(sg)
+	if sg.file == nil {
+		sg.file, err = os.Open(sg.Path)
+		if err != nil {
+			log.Println(err)
+			return nil, err
+		}
+	}
+	sf.opened = append(sf.opened, sg)
+
+	for len(sf.opened) > NUM_MAX_SEG_OPEN {
+		sf.opened[0].file.Close()
+		sf.opened[0].file = nil
+		sf.opened = sf.opened[1:]
+	}
+
+	return sg.file, err
+}
+
+//---------------------------------------------------------------------------
+// remove the segment from the ones open for reading, must be called in lock
+//---------------------------------------------------------------------------
+func (sf *SpillFile) forget(sg *spillSegment) {
+	for i, og := range sf.opened {
+		if og == sg {
+			sf.opened = append(sf.opened[:i], sf.opened[i+1:]...)
+			return
+		}
+	}
+}
+
+//---------------------------------------------------------------------------
+// find the segment and entry of the slot, must be called in lock
+//---------------------------------------------------------------------------
+func (sf *SpillFile) find(seq int64) (*spillSegment, *spillEntry) {
+	i := sort.Search(len(sf.segs), func(i int) bool {
+		return sf.segs[i].last() >= seq
+	})
+	if i == len(sf.segs) {
+		return nil, nil
+	}
+
+	sg := sf.segs[i]
+	j := sort.Search(len(sg.index), func(j int) bool {
+		return sg.index[j].Seq >= seq
+	})
+	if j == len(sg.index) || sg.index[j].Seq != seq {
+		return nil, nil
+	}
+
+	return sg, &sg.index[j]
+}
+
+//---------------------------------------------------------------------------
+// close the current segment and open a new one, removing the oldest
+// over the limit, must be called in lock
+//---------------------------------------------------------------------------
+func (sf *SpillFile) rotate() error {
+	var err error
+
+	// the one finished is opened again when read
+	if n := len(sf.segs); n > 0 {
+		sf.segs[n-1].close(sf.Boundary)
+	}
+
+	sf.nextId++
+	sg := &spillSegment{
+		Id:   sf.nextId,
+		Path: filepath.Join(sf.Dir, fmt.Sprintf("seg-%06d.mjpg", sf.nextId)),
+	}
+
+	sg.file, err = os.Create(sg.Path)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	sg.idx, err = os.Create(sg.Path + ".idx")
+	if err != nil {
+		log.Println(err)
+		sg.file.Close()
+		return err
+	}
+
+	sf.segs = append(sf.segs, sg)
+
+	for len(sf.segs) > sf.SegFiles {
+		sf.forget(sf.segs[0])
+		sf.segs[0].remove(sf.Boundary)
+		sf.segs = sf.segs[1:]
+	}
+
+	return err
+}
+
+//---------------------------------------------------------------------------
+// remove all the segments, must be called in lock
+//---------------------------------------------------------------------------
+func (sf *SpillFile) clear() {
+	for _, sg := range sf.segs {
+		sg.remove(sf.Boundary)
+	}
+	sf.segs = nil
+	sf.opened = nil
+}
+
+//---------------------------------------------------------------------------
+// finish the current segment with the closing boundary to be played as a file
+//---------------------------------------------------------------------------
+func (sg *spillSegment) close(boundary string) {
+	if sg.idx == nil {
+		return
+	}
+	sg.idx.Close()
+	sg.idx = nil
+
+	sg.file.WriteString(fmt.Sprintf("--%s--\r\n", boundary))
+	sg.file.Close()
+	sg.file = nil
+}
+
+func (sg *spillSegment) remove(boundary string) {
+	sg.close(boundary)
+	if sg.file != nil {
+		sg.file.Close()
+		sg.file = nil
+	}
+
+	os.Remove(sg.Path)
+	os.Remove(sg.Path + ".idx")
+}
+
+// ---------------------------------E-----N-----D--------------------------------
@@ -7,10 +7,17 @@ package protofile
 
 import (
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
+	"mime/multipart"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
+	"github.com/stretchr/testify/assert"
+
 	sb "stoney/httpserver/src/streambase"
 	sr "stoney/httpserver/src/streamring"
 )
@@ -67,4 +74,162 @@ func TestReadMultipartFile(t *testing.T) {
 	fd.StreamReader(ring)
 }
 
+//---------------------------------------------------------------------------------
+// test for spilling the slots evicted to disk
+//---------------------------------------------------------------------------------
+func TestSpillFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spill")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	ring := sr.NewStreamRingWithSize(5, sb.KBYTE)
+	sf, err := NewSpillFile(dir, ring.Boundary, 4, 3)
+	assert.Nil(t, err)
+	ring.SetSpill(sf)
+
+	for i := 0; i < 20; i++ {
+		data := []byte(fmt.Sprintf("slot %02d", i))
+		slot := sr.NewStreamSlotByData(len(data), "text/plain", len(data), data)
+		slot.Timestamp = int64(1000 + i*100)
+		slot.Keyframe = i%5 == 0
+		_, err = ring.PutSlotInNext(slot)
+		assert.Nil(t, err)
+	}
+	ring.SyncSpill()
+	t.Log(sf)
+
+	// 16 evicted, the last 12 of them are kept in 3 segments
+	assert.Equal(t, int64(16), sf.Spilled)
+
+	// continue reading from the disk into the ring
+	rr := ring.NewRingReader()
+	defer rr.Close()
+	err = rr.SeekTime(1650)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(6), rr.Seq)
+
+	out := sr.NewStreamSlotBySize(sb.KBYTE)
+	for i := 6; i < 20; i++ {
+		_, err = rr.ReadSlotTo(out)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(i), out.Seq)
+		assert.Equal(t, fmt.Sprintf("slot %02d", i), string(out.Content[:out.Length]))
+	}
+	assert.Equal(t, int64(0), rr.Drops)
+
+	// the slot only in the spill by the time
+	slot, err := ring.GetSlotByTime(1750)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(7), slot.Seq)
+	assert.Equal(t, "slot 07", string(slot.Content[:slot.Length]))
+
+	// removed from the disk
+	err = sf.ReadSpilled(2, out)
+	assert.Equal(t, sb.ErrFound, err)
+
+	// a closed segment is playable as a multipart file
+	files, _ := filepath.Glob(filepath.Join(dir, "*.mjpg"))
+	assert.Equal(t, 3, len(files))
+
+	f, err := os.Open(files[0])
+	assert.Nil(t, err)
+	defer f.Close()
+
+	mr := multipart.NewReader(f, ring.Boundary)
+	for i := 4; i < 8; i++ {
+		err = ReadPartToSlot(mr, out)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(1000+i*100), out.Timestamp)
+		assert.Equal(t, i == 5, out.Keyframe)
+	}
+	_, err = mr.NextPart()
+	assert.Equal(t, io.EOF, err)
+
+	// not spilled any more after closed
+	sf.Close()
+	err = sf.SpillSlot(out)
+	assert.Equal(t, sb.ErrStatus, err)
+}
+
+//---------------------------------------------------------------------------------
+// test for the segments open for reading, closed by LRU
+//---------------------------------------------------------------------------------
+func TestSpillFileOpen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spill")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	sf, err := NewSpillFile(dir, sb.STR_DEF_BDRY, 1, NUM_MAX_SEG_OPEN*2)
+	defer sf.Close()
+	for i := 0; i < NUM_MAX_SEG_OPEN*2; i++ {
+		data := []byte(fmt.Sprintf("slot %02d", i))
+		slot := sr.NewStreamSlotByData(len(data), "text/plain", len(data), data)
+		slot.Seq = int64(i)
+		assert.Nil(t, sf.SpillSlot(slot))
+	}
+
+	out := sr.NewStreamSlotBySize(sb.KBYTE)
+	for n := 0; n < 2; n++ {
+		for i := 0; i < NUM_MAX_SEG_OPEN*2; i++ {
+			assert.Nil(t, sf.ReadSpilled(int64(i), out))
+			assert.Equal(t, fmt.Sprintf("slot %02d", i), string(out.Content[:out.Length]))
+		}
+	}
+	assert.Equal(t, NUM_MAX_SEG_OPEN, len(sf.opened))
+
+	open := 0
+	for _, sg := range sf.segs {
+		if sg.file != nil {
+			open++
+		}
+	}
+	assert.Equal(t, NUM_MAX_SEG_OPEN+1, open) // and the current one
+}
+
+//---------------------------------------------------------------------------------
+// test for the recordings found in the directory
+//---------------------------------------------------------------------------------
+func TestListRecordings(t *testing.T) {
+	dir, err := ioutil.TempDir("", "record")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// segments spilled in a sub directory, the index files are not recordings
+	ring := sr.NewStreamRingWithSize(2, sb.KBYTE)
+	sf, err := NewSpillFile(filepath.Join(dir, "spill"), ring.Boundary, 2, 3)
+	assert.Nil(t, err)
+	ring.SetSpill(sf)
+	for i := 0; i < 5; i++ {
+		data := []byte(fmt.Sprintf("slot %02d", i))
+		slot := sr.NewStreamSlotByData(len(data), "text/plain", len(data), data)
+		slot.Timestamp = sb.GetTimestampNow()
+		ring.PutSlotInNext(slot)
+	}
+	ring.SyncSpill()
+
+	f, err := os.Create(filepath.Join(dir, "output.mjpg"))
+	assert.Nil(t, err)
+	data := []byte("not a jpeg")
+	slot := sr.NewStreamSlotByData(len(data), "image/jpeg", len(data), data)
+	slot.Timestamp = sb.GetTimestampNow() - sb.GetTimestampByDuration(time.Hour)
+	WriteSlotToFile(f, slot, sb.STR_DEF_BDRY)
+	f.Close()
+	ioutil.WriteFile(filepath.Join(dir, ".hidden.mjpg"), data, 0644)
+	ioutil.WriteFile(filepath.Join(dir, "broken.mjpg"), data, 0644)
+
+	recs, err := ListRecordings(dir)
+	assert.Nil(t, err)
+	for _, rec := range recs {
+		t.Log(rec)
+	}
+	assert.Equal(t, 4, len(recs))
+	assert.Equal(t, "broken.mjpg", recs[0].Path)
+	assert.Equal(t, "", recs[0].Type)
+	assert.Equal(t, "output.mjpg", recs[1].Path)
+	assert.Equal(t, "image/jpeg", recs[1].Type)
+	assert.True(t, recs[1].End.Sub(recs[1].Start) > 59*time.Minute)
+	assert.Equal(t, "spill/seg-000001.mjpg", recs[2].Path)
+	assert.Equal(t, "text/plain", recs[2].Type)
+}
+
 //----------------------------------E-----N-----D----------------------------------
@@ -10,6 +10,7 @@ package protohttp
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -22,11 +23,13 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"text/template"
 	"time"
 
 	"github.com/fatih/color"
 
+	bp "stoney/httpserver/src/base"
 	pb "stoney/httpserver/src/protobase"
 	sb "stoney/httpserver/src/streambase"
 	si "stoney/httpserver/src/streamimage"
@@ -185,78 +188,92 @@ func (ph *ProtoHttp) RequestPost(client *http.Client) error {
 }
 
 //---------------------------------------------------------------------------
-//	receive a part to data
+// post the slots of the reader in a long-lived multipart with chunked
+// transfer, until the server closes it or ctx is done
 //---------------------------------------------------------------------------
-func ReadPartToData(mr *multipart.Reader) ([]byte, error) {
+func PostReaderInMultipart(ctx context.Context, client *http.Client, url string, rr *sr.RingReader) error {
 	var err error
 
-	p, err := mr.NextPart()
-	if err != nil { // io.EOF
-		log.Println(err)
-		return nil, err
-	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	boundary := rr.Ring.GetBoundary()
+	pr, pw := io.Pipe()
 
-	sl := p.Header.Get(sb.STR_HDR_CONTENT_LENGTH)
-	nl, err := strconv.Atoi(sl)
+	req, err := http.NewRequest("POST", url, pr)
 	if err != nil {
-		log.Printf("%s %s %d\n", p.Header, sl, nl)
-		return nil, err
+		log.Println(err)
+		return err
 	}
+	req = req.WithContext(ctx)
+	req.ContentLength = -1 // unknown, sent in chunks
+	req.Header.Set("Content-Type", "multipart/x-mixed-replace; boundary="+boundary)
+	req.Header.Set("User-Agent", STR_HTTP_CASTER)
+
+	done := make(chan error, 1)
+	go func() {
+		werr := WriteReaderInMultipart(ctx, pw, rr)
+		pw.CloseWithError(werr)
+		done <- werr
+	}()
 
-	data := make([]byte, nl)
-
-	// implement like ReadFull() in jpeg.Decode()
-	var tn int
-	for tn < nl {
-		n, err := p.Read(data[tn:])
-		if err != nil {
-			log.Println(err)
-			return nil, err
+	res, err := client.Do(req)
+	if err == nil {
+		// the server may answer at the first and keep reading, or at the end
+		io.Copy(ioutil.Discard, res.Body)
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			err = fmt.Errorf("%s", res.Status)
 		}
-		tn += n
 	}
 
-	//fmt.Printf("%s %d/%d [%0x - %0x]\n", p.Header.Get("Content-Type"), tn, nl, data[:2], data[nl-2:])
-	return data[:nl], err
+	// the reader must not be used after return
+	cancel()
+	pr.Close()
+	werr := <-done
+	if err == nil {
+		err = werr
+	}
+
+	return err
 }
 
 //---------------------------------------------------------------------------
-//	receive a part to slot of buffer
+//	receive a part to data, drawn from the pool to be put back after use
 //---------------------------------------------------------------------------
-func ReadPartToSlot(mr *multipart.Reader, slot *sr.StreamSlot) error {
+func ReadPartToData(mr *multipart.Reader) ([]byte, error) {
 	var err error
 
 	p, err := mr.NextPart()
 	if err != nil { // io.EOF
 		log.Println(err)
-		return err
+		return nil, err
 	}
 
-	sl := p.Header.Get(sb.STR_HDR_CONTENT_LENGTH)
-	nl, err := strconv.Atoi(sl)
-	if err != nil {
-		log.Printf("%s %s %d\n", p.Header, sl, nl)
-		return err
+	// read to the boundary up to the default size if no length
+	nl := sr.GetPartLength(p)
+	size := nl
+	if nl < 0 {
+		size = sr.LEN_DEF_SLOT
 	}
 
-	slot.Length = 0
+	data := bp.GetBuffer(size)
 
-	var tn int
-	for tn < nl {
-		n, err := p.Read(slot.Content[tn:])
-		if err != nil {
-			log.Println(err)
-			return err
-		}
-		tn += n
+	n, err := sr.ReadPartBody(p, data, nl)
+	if err != nil {
+		bp.PutBuffer(data)
+		return nil, err
 	}
 
-	slot.Length = nl
-	slot.Type = p.Header.Get(sb.STR_HDR_CONTENT_TYPE)
-	slot.Timestamp = sb.GetTimestampNow()
-	//fmt.Println(slot)
+	//fmt.Printf("%s %d [%0x - %0x]\n", p.Header.Get("Content-Type"), n, data[:2], data[n-2:])
+	return data[:n], err
+}
 
-	return err
+//---------------------------------------------------------------------------
+//	receive a part to slot of buffer
+//---------------------------------------------------------------------------
+func ReadPartToSlot(mr *multipart.Reader, slot *sr.StreamSlot) error {
+	return sr.ReadPartToSlot(mr, slot)
 }
 
 //---------------------------------------------------------------------------
@@ -273,8 +290,21 @@ func ReadMultipartToRing(mr *multipart.Reader, ring *sr.StreamRing) error {
 	defer ring.SetStatusIdle()
 	//fmt.Println(ring)
 
+	_, err = ReadPartsToRing(mr, ring)
+
+	return err
+}
+
+//---------------------------------------------------------------------------
+//	insert parts to the ring in use until error, and return the count of parts,
+//	the status of the ring is kept for the reader to reconnect
+//---------------------------------------------------------------------------
+func ReadPartsToRing(mr *multipart.Reader, ring *sr.StreamRing) (int, error) {
+	var err error
+
 	// insert slots to the buffer
-	for i := 0; ring.IsUsing(); i++ {
+	i := 0
+	for ; ring.IsUsing(); i++ {
 		//pre, pos := ring.ReadSlotIn()
 		//fmt.Println("P", pos, pre)
 
@@ -282,6 +312,12 @@ func ReadMultipartToRing(mr *multipart.Reader, ring *sr.StreamRing) error {
 		//fmt.Println(i, pos, slot)
 
 		err = ReadPartToSlot(mr, slot)
+		if err == sb.ErrSize {
+			// skip the part, the slot is left to the next
+			ring.CountOversize()
+			err = nil
+			continue
+		}
 		if err != nil {
 			log.Println(err)
 			break
@@ -291,49 +327,129 @@ func ReadMultipartToRing(mr *multipart.Reader, ring *sr.StreamRing) error {
 		ring.SetPosInByPos(pos + 1)
 	}
 
-	return err
+	return i, err
 }
 
 //---------------------------------------------------------------------------
-//	receive multipart data and decode jpeg
+//	receive multipart data into the ring in order through the reorder stage
 //---------------------------------------------------------------------------
-func ReadMultipartToData(mr *multipart.Reader) error {
+func ReadMultipartToReorder(mr *multipart.Reader, ro *sr.RingReorder) error {
 	var err error
 
-	for {
-		p, err := mr.NextPart()
-		if err != nil { // io.EOF
+	ring := ro.Ring
+
+	err = ring.SetStatusUsing()
+	if err != nil {
+		log.Println(sb.RedString("ErrStatus/ReadMultipartToReorder"))
+		return sb.ErrStatus
+	}
+	defer ring.SetStatusIdle()
+
+	return ReadPartsToReorder(mr, ro)
+}
+
+//---------------------------------------------------------------------------
+//	insert parts to the ring in use through the reorder stage until error,
+//	the status of the ring is kept as ReadPartsToRing
+//---------------------------------------------------------------------------
+func ReadPartsToReorder(mr *multipart.Reader, ro *sr.RingReorder) error {
+	var err error
+
+	ring := ro.Ring
+
+	// the frames held are flushed before the ring is idle
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- ro.Run(ctx) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	slot := sr.NewStreamSlotFromPool(ring.Size)
+	defer slot.Free()
+
+	for ring.IsUsing() {
+		err = ReadPartToSlot(mr, slot)
+		if err == sb.ErrSize {
+			ring.CountOversize()
+			err = nil
+			continue
+		}
+		if err != nil {
 			log.Println(err)
-			return err
+			break
 		}
 
-		sl := p.Header.Get(sb.STR_HDR_CONTENT_LENGTH)
-		nl, err := strconv.Atoi(sl)
-		if sl == "" || nl == 0 {
-			log.Printf("%s %s %d\n", p.Header, sl, nl)
+		perr := ro.PutSlot(slot)
+		if perr != nil {
+			log.Printf("%s: %s dropped\n", perr, slot.Type)
+		}
+	}
+
+	return err
+}
+
+//---------------------------------------------------------------------------
+//	receive multipart data demultiplexed into the tracks by part type
+//---------------------------------------------------------------------------
+func ReadMultipartToTracks(mr *multipart.Reader, tracks *sr.StreamTracks) error {
+	var err error
+
+	err = tracks.SetStatusUsing()
+	if err != nil {
+		log.Println(sb.RedString("ErrStatus/ReadMultipartToTracks"))
+		return sb.ErrStatus
+	}
+	defer tracks.SetStatusIdle()
+
+	rings, _ := tracks.GetRings()
+
+	size := 0
+	for _, ring := range rings {
+		if ring.Size > size {
+			size = ring.Size
+		}
+	}
+	slot := sr.NewStreamSlotFromPool(size)
+	defer slot.Free()
+
+	for tracks.IsUsing() {
+		err = ReadPartToSlot(mr, slot)
+		if err == sb.ErrSize {
+			// skipped, logged by the reader
+			err = nil
 			continue
 		}
 		if err != nil {
 			log.Println(err)
-			return err
+			break
 		}
 
-		if nl > 0 {
-			data := make([]byte, nl)
-
-			// implement like ReadFull() in jpeg.Decode()
-			var tn int
-			for tn < nl {
-				n, err := p.Read(data[tn:])
-				if err != nil {
-					log.Println(err)
-					return err
-				}
-				tn += n
-			}
+		_, err = tracks.PutSlotByType(slot)
+		if err != nil {
+			log.Printf("%s: %s dropped\n", err, slot.Type)
+			err = nil
+		}
+	}
+
+	return err
+}
+
+//---------------------------------------------------------------------------
+//	receive multipart data and decode jpeg
+//---------------------------------------------------------------------------
+func ReadMultipartToData(mr *multipart.Reader) error {
+	var err error
 
-			//log.Printf("%s %d/%d [%0x - %0x]\n", p.Header.Get(sb.STR_HDR_CONTENT_LENGTH), tn, nl, data[:2], data[nl-2:])
+	for {
+		data, err := ReadPartToData(mr)
+		if err != nil { // io.EOF
+			return err
 		}
+		bp.PutBuffer(data)
+
+		//log.Printf("%d [%0x - %0x]\n", len(data), data[:2], data[len(data)-2:])
 	}
 
 	return err
@@ -392,38 +508,129 @@ func WriteImagesInMultipart(w io.Writer, dtype string, loop bool) error {
 }
 
 //---------------------------------------------------------------------------
-// send image stream with the given format(extension) in the directory
+// send ring buffer in multipart, blocking for new slots until ctx is done
 //---------------------------------------------------------------------------
-func WriteRingInMultipart(w io.Writer, ring *sr.StreamRing) error {
-	var err error
-
-	if !ring.IsUsing() {
+func WriteRingInMultipart(ctx context.Context, w io.Writer, ring *sr.StreamRing) error {
+	if !ring.IsReadable() {
 		fmt.Println(ring)
 		log.Println(sb.RedString("ErrStatus/WriteRingInMultipart"))
 		return sb.ErrStatus
 	}
 	//fmt.Println(ring)
 
-	var pos int
-	for ring.IsUsing() {
-		slot, npos, err := ring.GetSlotNextByPos(pos)
+	rr := ring.NewRingReader()
+	defer rr.Close()
+
+	return WriteReaderInMultipart(ctx, w, rr)
+}
+
+//---------------------------------------------------------------------------
+// send ring buffer in multipart from the position of the reader given
+//---------------------------------------------------------------------------
+func WriteReaderInMultipart(ctx context.Context, w io.Writer, rr *sr.RingReader) error {
+	return writeReaderInMultipart(ctx, w, rr, rr.Ring.GetBoundary(), nil, nil)
+}
+
+//---------------------------------------------------------------------------
+// send ring buffer in multipart throttled for the player, nil for no throttle
+//---------------------------------------------------------------------------
+func WriteReaderInMultipartThrottled(ctx context.Context, w io.Writer, rr *sr.RingReader, th *Throttle) error {
+	return writeReaderInMultipart(ctx, w, rr, rr.Ring.GetBoundary(), nil, th)
+}
+
+//---------------------------------------------------------------------------
+// send rings of tracks interleaved in a multipart with the boundary,
+// each part keeps its own Content-Type
+//---------------------------------------------------------------------------
+func WriteReadersInMultipart(ctx context.Context, w io.Writer, rrs []*sr.RingReader, boundary string) error {
+	return WriteReadersInMultipartThrottled(ctx, w, rrs, boundary, nil)
+}
+
+//---------------------------------------------------------------------------
+// send rings of tracks interleaved, sharing the throttle of the player
+//---------------------------------------------------------------------------
+func WriteReadersInMultipartThrottled(ctx context.Context, w io.Writer, rrs []*sr.RingReader, boundary string, th *Throttle) error {
+	var err error
+
+	if len(rrs) == 0 {
+		return sb.ErrEmpty
+	}
+
+	var mu sync.Mutex
+	errs := make(chan error, len(rrs))
+	ths := th.Split(len(rrs))
+
+	// until all the tracks are over, the writer must not be used after return
+	for i, rr := range rrs {
+		go func(rr *sr.RingReader, th *Throttle) {
+			errs <- writeReaderInMultipart(ctx, w, rr, boundary, &mu, th)
+		}(rr, ths[i])
+	}
+
+	for range rrs {
+		werr := <-errs
+		if err == nil {
+			err = werr
+		}
+	}
+
+	return err
+}
+
+func writeReaderInMultipart(ctx context.Context, w io.Writer, rr *sr.RingReader, boundary string, mu *sync.Mutex, th *Throttle) error {
+	var err error
+
+	ring := rr.Ring
+
+	slot := sr.NewStreamSlotFromPool(ring.Size)
+	defer slot.Free()
+
+	var skip int
+	for ring.IsReadable() {
+		skip, err = rr.WaitSlotTo(ctx, sb.TIME_DEF_BLOCK, slot)
 		if err != nil {
-			if err == sb.ErrEmpty {
-				time.Sleep(sb.TIME_DEF_WAIT)
+			// not to be returned when the ring is over in the meantime
+			if err == sb.ErrEmpty || err == sb.ErrTimeout {
+				err = nil
+				continue
+			}
+			if err == sb.ErrOverrun {
+				log.Printf("overrun, %d slots skipped\n", skip)
+				err = nil
 				continue
 			}
+			if err == ctx.Err() {
+				err = nil // the client is gone, not a failure
+				break
+			}
 			log.Println(err)
 			break
 		}
 
-		err = WriteSlotInPart(w, slot, ring.Boundary)
+		// skipped or transcoded for the player
+		out := slot
+		if th != nil {
+			out = th.Apply(slot, time.Now())
+			if out == nil {
+				continue
+			}
+		}
+
+		if mu != nil {
+			mu.Lock()
+		}
+		err = WriteSlotInPart(w, out, boundary)
+		if f, ok := w.(http.Flusher); ok && err == nil {
+			f.Flush() // not to be held in the buffer when the ring is still
+		}
+		if mu != nil {
+			mu.Unlock()
+		}
 		if err != nil {
 			log.Println(err)
 			break
 		}
 		//fmt.Println(slot)
-
-		pos = npos
 	}
 
 	return err
@@ -433,6 +640,14 @@ func WriteRingInMultipart(w io.Writer, ring *sr.StreamRing) error {
 // send files with the given format(extension) in the directory
 //---------------------------------------------------------------------------
 func WriteDirInMultipart(w io.Writer, pat string, loop bool) error {
+	return WriteDirInMultipartWithInterval(context.Background(), w, pat, sb.STR_DEF_BDRY, time.Second, loop)
+}
+
+//---------------------------------------------------------------------------
+// send files matched to the pattern one by one in the interval, until
+// ctx is done or the writer fails if loop
+//---------------------------------------------------------------------------
+func WriteDirInMultipartWithInterval(ctx context.Context, w io.Writer, pat string, boundary string, interval time.Duration, loop bool) error {
 	var err error
 
 	// direct pattern matching
@@ -446,13 +661,35 @@ func WriteDirInMultipart(w io.Writer, pat string, loop bool) error {
 		return sb.ErrFound
 	}
 
+	return WriteFilesInMultipartWithInterval(ctx, w, files, boundary, interval, loop)
+}
+
+//---------------------------------------------------------------------------
+// send the files one by one in the interval, until ctx is done or the writer
+// fails if loop
+//---------------------------------------------------------------------------
+func WriteFilesInMultipartWithInterval(ctx context.Context, w io.Writer, files []string, boundary string, interval time.Duration, loop bool) error {
+	var err error
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
 	for {
 		for i := range files {
-			err = WriteFileInPart(w, files[i], "myboundary")
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-timer.C:
+			}
+
+			err = WriteFileInPart(w, files[i], boundary)
 			if err != nil {
 				return err
 			}
-			time.Sleep(time.Second)
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+			timer.Reset(interval)
 		}
 
 		if !loop {
@@ -498,6 +735,9 @@ func WriteFileInPart(w io.Writer, file string, boundary string) error {
 	buf.Write(data)   // prepare data in the buffer
 	buf.WriteTo(part) // output the part with buffer in multipart format
 
+	// end of the part, the next boundary must follow a line break
+	_, err = io.WriteString(w, "\r\n")
+
 	return err
 }
 
@@ -543,24 +783,59 @@ func WriteImageInPart(w io.Writer, dtype string, boundary string) error {
 func WriteSlotInPart(w io.Writer, slot *sr.StreamSlot, boundary string) error {
 	var err error
 
-	mw := multipart.NewWriter(w)
-	mw.SetBoundary(boundary)
-	part, err := mw.CreatePart(textproto.MIMEHeader{
+	header := textproto.MIMEHeader{
 		"Content-Type":   {slot.Type},
 		"Content-Length": {strconv.Itoa(slot.Length)},
-	})
+	}
+	if slot.Keyframe {
+		header.Set(sb.STR_HDR_KEYFRAME, "1")
+	}
+	if slot.Origin != "" {
+		header.Set(sb.STR_HDR_ORIGIN, slot.Origin)
+	}
+	if slot.Timestamp > 0 {
+		header.Set(sb.STR_HDR_TIMESTAMP, strconv.FormatInt(slot.Timestamp, 10))
+	}
+
+	mw := multipart.NewWriter(w)
+	mw.SetBoundary(boundary)
+	part, err := mw.CreatePart(header)
 	if err != nil {
 		log.Println(err)
 		return err
 	}
 
-	buf := new(bytes.Buffer)
-	_, err = buf.Write(slot.Content[:slot.Length])
-	_, err = buf.WriteTo(part)
+	// write directly not to copy the content again
+	_, err = part.Write(slot.Content[:slot.Length])
+	if err != nil {
+		return err
+	}
+
+	// end of the part, the next boundary must follow a line break
+	_, err = io.WriteString(w, "\r\n")
 
 	return err
 }
 
+//---------------------------------------------------------------------------
+// get timestamp from parameter, relative like -5s or absolute in number
+//---------------------------------------------------------------------------
+func GetTimestampFromParam(str string) (int64, error) {
+	if strings.HasPrefix(str, "-") {
+		d, err := time.ParseDuration(str[1:])
+		if err == nil {
+			return sb.GetTimestampNow() - sb.GetTimestampByDuration(d), nil
+		}
+	}
+
+	ts, err := strconv.ParseInt(str, 10, 64)
+	if err != nil {
+		return 0, sb.ErrParse
+	}
+
+	return ts, nil
+}
+
 //---------------------------------------------------------------------------
 // get boundary string
 //---------------------------------------------------------------------------
@@ -587,6 +862,63 @@ func GetTypeBoundary(ctype string) (string, error) {
 	return boundary, err
 }
 
+//---------------------------------------------------------------------------
+// entity tag of the slot keyed on the epoch of the ring and its sequence,
+// ex) "1700000000000000000-123"
+//---------------------------------------------------------------------------
+func GetSlotTag(epoch int64, slot *sr.StreamSlot) string {
+	return strconv.Quote(strconv.FormatInt(epoch, 10) + "-" + strconv.FormatInt(slot.Seq, 10))
+}
+
+//---------------------------------------------------------------------------
+// get the newest sequence in the tags of If-None-Match of the given epoch,
+// ex) "5-12", W/"5-13", the tags of other epochs are ignored
+//---------------------------------------------------------------------------
+func GetSeqFromTags(tags string, epoch int64) (int64, error) {
+	var seq int64 = -1
+
+	for _, tag := range strings.Split(tags, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		st := strings.SplitN(strings.Trim(tag, `"`), "-", 2)
+		if len(st) != 2 || st[0] != strconv.FormatInt(epoch, 10) {
+			continue
+		}
+		n, err := strconv.ParseInt(st[1], 10, 64)
+		if err == nil && n > seq {
+			seq = n
+		}
+	}
+
+	if seq < 0 {
+		return seq, sb.ErrParse
+	}
+
+	return seq, nil
+}
+
+//---------------------------------------------------------------------------
+// send the slot as a plain response, only the header for HEAD
+//---------------------------------------------------------------------------
+func ResponseSlot(w http.ResponseWriter, method string, epoch int64, slot *sr.StreamSlot) error {
+	var err error
+
+	w.Header().Set("Content-Type", slot.Type)
+	w.Header().Set("Content-Length", strconv.Itoa(slot.Length))
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("ETag", GetSlotTag(epoch, slot))
+	w.Header().Set("Server", STR_HTTP_SERVER)
+	w.Header().Set(sb.STR_HDR_TIMESTAMP, strconv.FormatInt(slot.Timestamp, 10))
+	w.WriteHeader(http.StatusOK)
+
+	if method == "HEAD" {
+		return err
+	}
+
+	_, err = w.Write(slot.Content[:slot.Length])
+
+	return err
+}
+
 //---------------------------------------------------------------------------
 // send response for GET
 //---------------------------------------------------------------------------
@@ -606,12 +938,35 @@ func ResponseGet(w http.ResponseWriter, boundary string) error {
 func ResponsePost(w http.ResponseWriter, boundary string) error {
 	var err error
 
+	// the stream may be left unread, close rather than drain it
+	w.Header().Set("Connection", "close")
 	w.Header().Set("Server", STR_HTTP_SERVER)
 	w.WriteHeader(http.StatusOK)
 
 	return err
 }
 
+//---------------------------------------------------------------------------
+// close the connection of POST at the end of reading, the stream of the
+// caster is endless and can not be drained
+//---------------------------------------------------------------------------
+func ClosePost(w http.ResponseWriter) error {
+	var err error
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		return sb.ErrSupport
+	}
+
+	conn, _, err := hj.Hijack()
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return conn.Close()
+}
+
 //---------------------------------------------------------------------------
 // send response message simply
 //---------------------------------------------------------------------------
@@ -658,4 +1013,25 @@ func GetJsonFromRequest(r *http.Request, struc interface{}) error {
 	return err
 }
 
+//---------------------------------------------------------------------------
+// send the data in json with the status
+//---------------------------------------------------------------------------
+func WriteResponseJson(w http.ResponseWriter, status int, struc interface{}) error {
+	var err error
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Server", STR_HTTP_SERVER)
+	w.WriteHeader(status)
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	err = enc.Encode(struc)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return err
+}
+
 // ---------------------------------E-----N-----D--------------------------------
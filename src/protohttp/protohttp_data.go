@@ -31,4 +31,16 @@ func NewClient() *http.Client {
 	return &http.Client{Transport: tp, Timeout: timeout}
 }
 
+//---------------------------------------------------------------------------
+// new client for the long-lived stream, timeout only for connecting
+//---------------------------------------------------------------------------
+func NewStreamClient() *http.Client {
+	tp := &http.Transport{
+		Dial:            dialTimeout,
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}
+
+	return &http.Client{Transport: tp}
+}
+
 // ---------------------------------E-----N-----D--------------------------------
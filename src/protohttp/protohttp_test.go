@@ -6,8 +6,21 @@
 package protohttp
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"mime/multipart"
+	"net/textproto"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	si "stoney/httpserver/src/streamimage"
+	sr "stoney/httpserver/src/streamring"
 )
 
 //------------------------------------------------------------------
@@ -30,3 +43,142 @@ func TestInfo(t *testing.T) {
 func TestServer(t *testing.T) {
 
 }
+
+//------------------------------------------------------------------
+// test for the parts over the slot skipped in ingest, not ending it
+//------------------------------------------------------------------
+func TestReadPartsToRing(t *testing.T) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	frames := []string{"frame 1", strings.Repeat("x", 64), "frame 2", "frame 3"}
+	for i, frame := range frames {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", "text/plain")
+		// by the length and to the boundary
+		if i%2 == 0 {
+			header.Set("Content-Length", fmt.Sprint(len(frame)))
+		}
+		pw, _ := mw.CreatePart(header)
+		pw.Write([]byte(frame))
+	}
+	pw, _ := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain"}})
+	pw.Write(bytes.Repeat([]byte("y"), 64))
+	mw.Close()
+
+	ring := sr.NewStreamRingWithParams(5, 16, "oversize")
+	assert.Nil(t, ring.SetStatusUsing())
+	mr := sr.NewPartReader(&body, mw.Boundary())
+	n, err := ReadPartsToRing(mr, ring)
+	t.Log(n, err)
+
+	stats := ring.Stats()
+	t.Log(stats.BaseString())
+	assert.Equal(t, int64(3), stats.FramesIn)
+	assert.Equal(t, int64(2), stats.Oversize)
+	for i, frame := range []string{"frame 1", "frame 2", "frame 3"} {
+		slot, err := ring.GetSlotByPos(i)
+		assert.Nil(t, err)
+		assert.Equal(t, frame, string(slot.Content[:slot.Length]))
+	}
+}
+
+//------------------------------------------------------------------
+// writer failing always, as a client gone
+//------------------------------------------------------------------
+type failWriter struct{}
+
+func (fw failWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("broken pipe")
+}
+
+//------------------------------------------------------------------
+// test for the write failure returned, and the cancel not a failure
+//------------------------------------------------------------------
+func TestWriteRingInMultipart(t *testing.T) {
+	ring := sr.NewStreamRingWithParams(5, 16, "writer")
+	assert.Nil(t, ring.SetStatusUsing())
+	for i := 0; i < 3; i++ {
+		_, err := ring.PutSlotInNext(sr.NewStreamSlotByData(16, "text/plain", 7, []byte("frame "+fmt.Sprint(i))))
+		assert.Nil(t, err)
+	}
+
+	err := WriteRingInMultipart(context.Background(), failWriter{}, ring)
+	assert.EqualError(t, err, "broken pipe")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	var body bytes.Buffer
+	err = WriteRingInMultipart(ctx, &body, ring)
+	assert.Nil(t, err)
+	assert.Contains(t, body.String(), "frame 2")
+}
+
+//------------------------------------------------------------------
+// test for the throttle of a player
+//------------------------------------------------------------------
+func TestThrottle(t *testing.T) {
+	query := func(str string) url.Values {
+		q, _ := url.ParseQuery(str)
+		return q
+	}
+
+	th, err := GetThrottleFromQuery(query(""), true)
+	assert.Nil(t, err)
+	assert.Nil(t, th)
+	_, err = GetThrottleFromQuery(query("fps=-1"), true)
+	assert.NotNil(t, err)
+	_, err = GetThrottleFromQuery(query("maxkbps=x"), true)
+	assert.NotNil(t, err)
+	// transcode not allowed
+	th, err = GetThrottleFromQuery(query("quality=30"), false)
+	assert.Nil(t, err)
+	assert.Nil(t, th)
+
+	// 30 fps in to 5 fps out for a second, audio is not skipped
+	th, _ = GetThrottleFromQuery(query("fps=5"), false)
+	video := sr.NewStreamSlotByData(4, "image/jpeg", 4, []byte("jpeg"))
+	audio := sr.NewStreamSlotByData(4, "audio/pcm", 4, []byte("pcm0"))
+	start := time.Now()
+	sent, heard := 0, 0
+	for i := 0; i < 30; i++ {
+		now := start.Add(time.Duration(i) * time.Second / 30)
+		if th.Apply(video, now) != nil {
+			sent++
+		}
+		if th.Apply(audio, now) != nil {
+			heard++
+		}
+	}
+	t.Log(th)
+	assert.Equal(t, 5, sent)
+	assert.Equal(t, 30, heard)
+
+	// 80 kbps for 1000 bytes(8 kbits) at 30 fps for 2 seconds, 10 frames a
+	// second after the credit of a second at first
+	th, _ = GetThrottleFromQuery(query("maxkbps=80"), false)
+	data := make([]byte, 1000)
+	big := sr.NewStreamSlotByData(len(data), "image/jpeg", len(data), data)
+	sent = 0
+	for i := 0; i < 60; i++ {
+		if th.Apply(big, start.Add(time.Duration(i)*time.Second/30)) != nil {
+			sent++
+		}
+	}
+	t.Log(th)
+	assert.True(t, sent >= 28 && sent <= 30, sent)
+
+	// re-encoded at half the size, the slot given is intact
+	img := si.GenSpiralImage(64, 48)
+	jpg, err := si.PutImageToBuffer(img, "jpeg", 95)
+	assert.Nil(t, err)
+	in := sr.NewStreamSlotByData(len(jpg), "image/jpeg", len(jpg), jpg)
+	th, _ = GetThrottleFromQuery(query("quality=20&scale=2"), true)
+	out := th.Apply(in, start)
+	assert.NotNil(t, out)
+	assert.True(t, out.Length < in.Length)
+	assert.Equal(t, len(jpg), in.Length)
+	small, err := si.GetImageFromBuffer(out.Content[:out.Length])
+	assert.Nil(t, err)
+	assert.Equal(t, 32, small.Bounds().Dx())
+	assert.Equal(t, 24, small.Bounds().Dy())
+}
@@ -0,0 +1,264 @@
+//=========================================================================
+// Author : Stoney Kang, sikang99@gmail.com, 2015
+// Throttle of a player by frame rate and bit rate, ex) ?fps=5&maxkbps=500
+// - frames are skipped to keep the rates, audio is not skipped by the frame rate
+// - JPEG frames may be re-encoded at a lower quality or scale for the player only
+//=========================================================================
+
+package protohttp
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	si "stoney/httpserver/src/streamimage"
+	sr "stoney/httpserver/src/streamring"
+)
+
+//---------------------------------------------------------------------------
+const (
+	NUM_MAX_FPS     = 120
+	NUM_MAX_SCALE   = 16
+	NUM_DEF_QUALITY = 50 // quality of JPEG re-encoded if scaled only
+)
+
+//===========================================================================
+// throttle struc, for a reader of a player
+//---------------------------------------------------------------------------
+type Throttle struct {
+	Fps     float64 // frames per second at most, 0 for no limit
+	MaxKbps int     // kilo bits per second at most, 0 for no limit
+	Quality int     // quality of JPEG re-encoded, 0 for no transcode
+	Scale   int     // divisor of the width and height of JPEG re-encoded
+	Sent    int64
+	Skips   int64
+	next    time.Time // time the next frame is allowed
+	credit  float64   // bits allowed to be sent now
+	filled  time.Time // time the credit is filled last
+	keyed   bool      // the stream has keyframes
+	waitKey bool      // frames are skipped until the next keyframe
+	buf     bytes.Buffer
+	out     sr.StreamSlot
+}
+
+//---------------------------------------------------------------------------
+// string information for the throttle
+//---------------------------------------------------------------------------
+func (th *Throttle) String() string {
+	str := fmt.Sprintf("[Throttle] Fps: %g", th.Fps)
+	str += fmt.Sprintf("\tMaxKbps: %d", th.MaxKbps)
+	str += fmt.Sprintf("\tQuality: %d", th.Quality)
+	str += fmt.Sprintf("\tScale: %d", th.Scale)
+	str += fmt.Sprintf("\tSent: %d", th.Sent)
+	str += fmt.Sprintf("\tSkips: %d", th.Skips)
+	return str
+}
+
+//---------------------------------------------------------------------------
+// get the throttle from the query, nil if not requested
+// - fps, maxkbps : the rates at most
+// - quality, scale : re-encode JPEG if transcode is allowed
+//---------------------------------------------------------------------------
+func GetThrottleFromQuery(query url.Values, transcode bool) (*Throttle, error) {
+	var err error
+
+	th := &Throttle{Scale: 1}
+
+	if str := query.Get("fps"); str != "" {
+		th.Fps, err = strconv.ParseFloat(str, 64)
+		if err != nil || th.Fps < 0 || th.Fps > NUM_MAX_FPS {
+			return nil, fmt.Errorf("invalid fps: %s", str)
+		}
+	}
+
+	if str := query.Get("maxkbps"); str != "" {
+		th.MaxKbps, err = strconv.Atoi(str)
+		if err != nil || th.MaxKbps < 0 {
+			return nil, fmt.Errorf("invalid maxkbps: %s", str)
+		}
+	}
+
+	if transcode {
+		if str := query.Get("quality"); str != "" {
+			th.Quality, err = strconv.Atoi(str)
+			if err != nil || th.Quality < 1 || th.Quality > 100 {
+				return nil, fmt.Errorf("invalid quality: %s", str)
+			}
+		}
+
+		if str := query.Get("scale"); str != "" {
+			th.Scale, err = strconv.Atoi(str)
+			if err != nil || th.Scale < 1 || th.Scale > NUM_MAX_SCALE {
+				return nil, fmt.Errorf("invalid scale: %s", str)
+			}
+			if th.Quality == 0 && th.Scale > 1 {
+				th.Quality = NUM_DEF_QUALITY
+			}
+		}
+	}
+
+	if th.Fps == 0 && th.MaxKbps == 0 && th.Quality == 0 {
+		return nil, nil
+	}
+
+	return th, nil
+}
+
+//---------------------------------------------------------------------------
+// split the throttle for the readers of the tracks, sharing the bit rate evenly
+//---------------------------------------------------------------------------
+func (th *Throttle) Split(n int) []*Throttle {
+	ths := make([]*Throttle, n)
+	for i := range ths {
+		if th == nil {
+			continue
+		}
+		ths[i] = &Throttle{
+			Fps:     th.Fps,
+			MaxKbps: th.MaxKbps / n,
+			Quality: th.Quality,
+			Scale:   th.Scale,
+		}
+		if th.MaxKbps > 0 && ths[i].MaxKbps == 0 {
+			ths[i].MaxKbps = 1
+		}
+	}
+	return ths
+}
+
+//---------------------------------------------------------------------------
+// get the slot to be sent, transcoded if requested, or nil to skip it
+//---------------------------------------------------------------------------
+func (th *Throttle) Apply(slot *sr.StreamSlot, now time.Time) *sr.StreamSlot {
+	if slot.Keyframe {
+		th.keyed = true
+		th.waitKey = false
+	}
+
+	if th.waitKey || !th.allowFrame(slot, now) {
+		th.skip()
+		return nil
+	}
+
+	out := slot
+	if th.Quality > 0 {
+		out = th.transcode(slot)
+	}
+
+	if !th.allowBits(out.Length, now) {
+		th.skip()
+		return nil
+	}
+
+	th.Sent++
+	return out
+}
+
+//---------------------------------------------------------------------------
+// count the frame skipped, the following ones are not decodable without
+// the keyframe if the stream has
+//---------------------------------------------------------------------------
+func (th *Throttle) skip() {
+	th.Skips++
+	if th.keyed {
+		th.waitKey = true
+	}
+}
+
+//---------------------------------------------------------------------------
+// check the frame rate, the frames are scheduled in the interval
+//---------------------------------------------------------------------------
+func (th *Throttle) allowFrame(slot *sr.StreamSlot, now time.Time) bool {
+	if th.Fps <= 0 || strings.HasPrefix(slot.Type, "audio/") {
+		return true
+	}
+
+	if now.Before(th.next) {
+		return false
+	}
+
+	// keep the rate on average, but not to burst after a pause
+	interval := time.Duration(float64(time.Second) / th.Fps)
+	th.next = th.next.Add(interval)
+	if th.next.Before(now) {
+		th.next = now.Add(interval)
+	}
+
+	return true
+}
+
+//---------------------------------------------------------------------------
+// check the bit rate by the credit filled in time, up to a second of it
+//---------------------------------------------------------------------------
+func (th *Throttle) allowBits(length int, now time.Time) bool {
+	if th.MaxKbps <= 0 {
+		return true
+	}
+
+	rate := float64(th.MaxKbps) * 1000
+	if th.filled.IsZero() {
+		th.credit = rate
+	} else {
+		th.credit += now.Sub(th.filled).Seconds() * rate
+	}
+	if th.credit > rate {
+		th.credit = rate
+	}
+	th.filled = now
+
+	// a frame over the credit of a second is sent when it is full
+	bits := float64(length * 8)
+	if bits > th.credit && th.credit < rate {
+		return false
+	}
+
+	th.credit -= bits
+	return true
+}
+
+//---------------------------------------------------------------------------
+// re-encode JPEG at the quality and scale, the slot of others is intact
+//---------------------------------------------------------------------------
+func (th *Throttle) transcode(slot *sr.StreamSlot) *sr.StreamSlot {
+	if slot.Type != "image/jpeg" && slot.Type != "image/jpg" {
+		return slot
+	}
+
+	img, err := si.GetImageFromBuffer(slot.Content[:slot.Length])
+	if err != nil {
+		return slot
+	}
+
+	if th.Scale > 1 {
+		b := img.Bounds()
+		r := image.Rect(0, 0, (b.Dx()+th.Scale-1)/th.Scale, (b.Dy()+th.Scale-1)/th.Scale)
+		dst := image.NewRGBA(r)
+		si.DrawImageScaled(dst, r, img)
+		img = dst
+	}
+
+	th.buf.Reset()
+	err = si.EncodeImageByType(&th.buf, img, "jpeg", th.Quality)
+	if err != nil {
+		return slot
+	}
+
+	out := &th.out
+	out.Type = slot.Type
+	out.Content = th.buf.Bytes()
+	out.Length = th.buf.Len()
+	out.LengthMax = out.Length
+	out.Timestamp = slot.Timestamp
+	out.Seq = slot.Seq
+	out.Keyframe = slot.Keyframe
+	out.Origin = slot.Origin
+
+	return out
+}
+
+// ---------------------------------E-----N-----D--------------------------------
@@ -10,7 +10,9 @@ package prototcp
 
 import (
 	"bufio"
+	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"mime"
@@ -26,6 +28,7 @@ import (
 
 	//"github.com/kisom/go-schannel"	// Bidirectional secure channels over TCP/IP
 
+	bp "stoney/httpserver/src/base"
 	pb "stoney/httpserver/src/protobase"
 	sb "stoney/httpserver/src/streambase"
 	sr "stoney/httpserver/src/streamring"
@@ -46,8 +49,10 @@ type ProtoTcp struct {
 	Port2    string
 	Desc     string
 	Method   string // POST or GET
+	Path     string // request path, ex) /stream/lobby-cam
 	Boundary string
 	Conn     net.Conn
+	Rings    *sr.RingRegistry // named rings looked up by path, nil if not used
 	Base     *pb.ProtoBase
 }
 
@@ -58,7 +63,7 @@ func (pt *ProtoTcp) String() string {
 	str := fmt.Sprintf("\tHost: %s", pt.Host)
 	str += fmt.Sprintf("\tPort: %s", pt.Port)
 	str += fmt.Sprintf("\tBoundary: %s", pt.Boundary)
-	str += fmt.Sprintf("\tMethod: %s", pt.Method)
+	str += fmt.Sprintf("\tMethod: %s %s", pt.Method, pt.Path)
 	str += fmt.Sprintf("\tDesc: %s", pt.Desc)
 	str += fmt.Sprintf("\tConn: %v", pt.Conn)
 	return str
@@ -258,7 +263,7 @@ func (pt *ProtoTcp) RequestGet(r *bufio.Reader, w *bufio.Writer) error {
 	var err error
 
 	// send GET request
-	req := "GET /stream HTTP/1.1\r\n"
+	req := fmt.Sprintf("GET %s HTTP/1.1\r\n", pt.requestPath())
 	req += fmt.Sprintf("User-Agent: %s\r\n", STR_TCP_PLAYER)
 	req += "\r\n"
 
@@ -281,6 +286,16 @@ func (pt *ProtoTcp) RequestGet(r *bufio.Reader, w *bufio.Writer) error {
 	return err
 }
 
+//---------------------------------------------------------------------------
+// path of the request, the named ring if given, ex) /stream/lobby-cam
+//---------------------------------------------------------------------------
+func (pt *ProtoTcp) requestPath() string {
+	if pt.Path == "" {
+		return "/stream"
+	}
+	return pt.Path
+}
+
 //---------------------------------------------------------------------------
 // summit a POST request and get its response
 //---------------------------------------------------------------------------
@@ -288,7 +303,7 @@ func (pt *ProtoTcp) RequestPost(r *bufio.Reader, w *bufio.Writer) error {
 	var err error
 
 	// send POST request
-	req := "POST /stream HTTP/1.1\r\n"
+	req := fmt.Sprintf("POST %s HTTP/1.1\r\n", pt.requestPath())
 	req += fmt.Sprintf("Content-Type: multipart/x-mixed-replace; boundary=%s\r\n", pt.Boundary)
 	req += fmt.Sprintf("User-Agent: %s\r\n", STR_TCP_CASTER)
 	req += "\r\n"
@@ -333,6 +348,13 @@ func (pt *ProtoTcp) HandleRequest(conn net.Conn, ring *sr.StreamRing) error {
 		return err
 	}
 
+	// the ring named in the path, ex) /stream/lobby-cam
+	ring, err = pt.Rings.LookupRing(pt.Method, pt.Path, ring)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
 	// send response and multipart
 	switch pt.Method {
 	case "POST":
@@ -352,7 +374,15 @@ func (pt *ProtoTcp) HandleRequest(conn net.Conn, ring *sr.StreamRing) error {
 			log.Println(err)
 			return err
 		}
-		err = pt.WriteRingToStream(w, ring)
+		// canceled when the server stops or the client closes the connection
+		ctx, cancel := pt.Base.NewContext()
+		defer cancel()
+		go func() {
+			io.Copy(ioutil.Discard, r)
+			cancel()
+		}()
+
+		err = pt.WriteRingToStream(ctx, w, ring)
 		//err = pt.WriteDataToStream(w, ring)
 		if err != nil {
 			log.Println(err)
@@ -391,7 +421,7 @@ func (pt *ProtoTcp) WriteDataToStream(w *bufio.Writer, ring *sr.StreamRing) erro
 //---------------------------------------------------------------------------
 // send ring buffer to client in multipart
 //---------------------------------------------------------------------------
-func (pt *ProtoTcp) WriteRingToStream(w *bufio.Writer, ring *sr.StreamRing) error {
+func (pt *ProtoTcp) WriteRingToStream(ctx context.Context, w *bufio.Writer, ring *sr.StreamRing) error {
 	var err error
 
 	if !ring.IsUsing() {
@@ -399,14 +429,29 @@ func (pt *ProtoTcp) WriteRingToStream(w *bufio.Writer, ring *sr.StreamRing) erro
 		return sb.ErrStatus
 	}
 
-	var pos int
+	rr := ring.NewRingReader()
+	defer rr.Close()
+	slot := sr.NewStreamSlotFromPool(ring.Size)
+	defer slot.Free()
+
+	var skip int
 	for ring.IsUsing() {
-		slot, npos, err := ring.GetSlotNextByPos(pos)
+		skip, err = rr.WaitSlotTo(ctx, sb.TIME_DEF_BLOCK, slot)
 		if err != nil {
-			if err == sb.ErrEmpty {
-				time.Sleep(sb.TIME_DEF_WAIT)
+			// not to be returned when the ring is over in the meantime
+			if err == sb.ErrEmpty || err == sb.ErrTimeout {
+				err = nil
+				continue
+			}
+			if err == sb.ErrOverrun {
+				log.Printf("overrun, %d slots skipped\n", skip)
+				err = nil
 				continue
 			}
+			if err == ctx.Err() {
+				err = nil // the client is gone, not a failure
+				break
+			}
 			log.Println(err)
 			break
 		}
@@ -417,8 +462,6 @@ func (pt *ProtoTcp) WriteRingToStream(w *bufio.Writer, ring *sr.StreamRing) erro
 			break
 		}
 		fmt.Println("3>", slot)
-
-		pos = npos
 	}
 
 	return err
@@ -465,7 +508,8 @@ func (pt *ProtoTcp) ReadStreamToData(r *bufio.Reader) error {
 	var err error
 
 	// alloc temp slot
-	slot := sr.NewStreamSlot()
+	slot := sr.NewStreamSlotFromPool(sr.LEN_DEF_SLOT)
+	defer slot.Free()
 
 	for {
 		err = pt.ReadFrameToSlot(r, slot)
@@ -544,6 +588,8 @@ func (pt *ProtoTcp) ReadFrameToSlot(r *bufio.Reader, slot *sr.StreamSlot) error
 
 	if clen > 0 {
 		slot.Type = headers[sb.STR_HDR_CONTENT_TYPE]
+		slot.Keyframe = headers[sb.STR_HDR_KEYFRAME] == "1"
+		slot.Origin = headers[sb.STR_HDR_ORIGIN]
 		err = pt.ReadBodyToSlot(r, clen, slot)
 		if err != nil {
 			log.Println(err)
@@ -607,7 +653,8 @@ func (pt *ProtoTcp) ReadMessage(r *bufio.Reader) error {
 	}
 
 	if clen > 0 {
-		_, err = pt.ReadBodyToData(r, clen)
+		data, err := pt.ReadBodyToData(r, clen)
+		bp.PutBuffer(data)
 		if err != nil {
 			log.Println(err)
 			return err
@@ -634,6 +681,9 @@ func (pt *ProtoTcp) ReadMessageHeader(r *bufio.Reader) (map[string]string, error
 
 	res := strings.Fields(string(line))
 	pt.Method = res[0]
+	if len(res) > 1 && !strings.HasPrefix(res[0], "HTTP/") {
+		pt.Path = res[1]
+	}
 
 	// parse header lines
 	for {
@@ -659,12 +709,12 @@ func (pt *ProtoTcp) ReadMessageHeader(r *bufio.Reader) (map[string]string, error
 }
 
 //---------------------------------------------------------------------------
-// read(recv) body of message to data
+// read(recv) body of message to data, drawn from the pool to be put back
 //---------------------------------------------------------------------------
 func (pt *ProtoTcp) ReadBodyToData(r *bufio.Reader, clen int) ([]byte, error) {
 	var err error
 
-	data := make([]byte, clen)
+	data := bp.GetBuffer(clen)
 
 	tn := 0
 	for tn < clen {
@@ -789,7 +839,8 @@ func (pt *ProtoTcp) WriteDataInFrame(w *bufio.Writer, data []byte, ctype string)
 
 	clen := len(data)
 	// prepare a slot and its data
-	slot := sr.NewStreamSlotBySize(clen)
+	slot := sr.NewStreamSlotFromPool(clen)
+	defer slot.Free()
 	defer fmt.Println("1>", slot)
 
 	slot.Type = ctype
@@ -823,6 +874,12 @@ func (pt *ProtoTcp) WriteSlotInFrame(w *bufio.Writer, slot *sr.StreamSlot) error
 	req += fmt.Sprintf("Content-Type: %s\r\n", slot.Type)
 	req += fmt.Sprintf("Content-Length: %d\r\n", slot.Length)
 	req += fmt.Sprintf("x-Timestamp: %v\r\n", slot.Timestamp)
+	if slot.Keyframe {
+		req += fmt.Sprintf("%s: 1\r\n", sb.STR_HDR_KEYFRAME)
+	}
+	if slot.Origin != "" {
+		req += fmt.Sprintf("%s: %s\r\n", sb.STR_HDR_ORIGIN, slot.Origin)
+	}
 	req += "\r\n"
 
 	//defer fmt.Println("->", req)
@@ -959,6 +1016,8 @@ func RecvFrameToSlot(conn net.Conn, slot *sr.StreamSlot) error {
 
 	if clen > 0 {
 		slot.Type = headers[sb.STR_HDR_CONTENT_TYPE]
+		slot.Keyframe = headers[sb.STR_HDR_KEYFRAME] == "1"
+		slot.Origin = headers[sb.STR_HDR_ORIGIN]
 		err = RecvFrameBodyToSlot(conn, slot, clen)
 		if err != nil {
 			log.Println(err)
@@ -1100,7 +1159,8 @@ func ParseFrameHeader(reader *bufio.Reader) (map[string]string, error) {
 func RecvFrameBodyToData(conn net.Conn, clen int) error {
 	var err error
 
-	data := make([]byte, clen)
+	data := bp.GetBuffer(clen)
+	defer bp.PutBuffer(data)
 
 	tn := 0
 	for tn < clen {
@@ -53,9 +53,11 @@ type ProtoWs struct {
 	Port2    string // for HTTP/2
 	Desc     string
 	Method   string
+	Path     string // request path, ex) /stream/lobby-cam
 	Boundary string
 	Conn     *websocket.Conn
 	Ring     *sr.StreamRing
+	Rings    *sr.RingRegistry // named rings looked up by path, nil if not used
 	Base     *pb.ProtoBase
 }
 
@@ -67,7 +69,7 @@ func (pw *ProtoWs) String() string {
 	str += fmt.Sprintf("\tHost: %s", pw.Host)
 	str += fmt.Sprintf("\tPort: %s,%s,%s", pw.Port, pw.PortTls, pw.Port2)
 	str += fmt.Sprintf("\tConn: %v", pw.Conn)
-	str += fmt.Sprintf("\tMethod: %s", pw.Method)
+	str += fmt.Sprintf("\tMethod: %s %s", pw.Method, pw.Path)
 	str += fmt.Sprintf("\tBoundary: %s", pw.Boundary)
 	str += fmt.Sprintf("\tDesc: %s", pw.Desc)
 	return str
@@ -482,7 +484,7 @@ func (pw *ProtoWs) RequestGet(ws *websocket.Conn) error {
 	var err error
 
 	// send GET request
-	req := "GET /stream HTTP/1.1\r\n"
+	req := fmt.Sprintf("GET %s HTTP/1.1\r\n", pw.requestPath())
 	req += fmt.Sprintf("%s: %s\r\n", sb.STR_HDR_USER_AGENT, STR_WS_PLAYER)
 	req += "\r\n"
 
@@ -504,6 +506,16 @@ func (pw *ProtoWs) RequestGet(ws *websocket.Conn) error {
 	return err
 }
 
+//---------------------------------------------------------------------------
+// path of the request, the named ring if given, ex) /stream/lobby-cam
+//---------------------------------------------------------------------------
+func (pw *ProtoWs) requestPath() string {
+	if pw.Path == "" {
+		return "/stream"
+	}
+	return pw.Path
+}
+
 //---------------------------------------------------------------------------
 // a POST request to the server
 //---------------------------------------------------------------------------
@@ -511,7 +523,7 @@ func (pw *ProtoWs) RequestPost(ws *websocket.Conn) error {
 	var err error
 
 	// send POST request
-	req := "POST /stream HTTP/1.1\r\n"
+	req := fmt.Sprintf("POST %s HTTP/1.1\r\n", pw.requestPath())
 	req += fmt.Sprintf("%s: multipart/x-mixed-replace; boundary=%s\r\n", sb.STR_HDR_CONTENT_TYPE, pw.Boundary)
 	req += fmt.Sprintf("%s: %s\r\n", sb.STR_HDR_USER_AGENT, STR_WS_CASTER)
 	req += "\r\n"
@@ -590,10 +602,17 @@ func (pw *ProtoWs) HandleRequest(ws *websocket.Conn, ring *sr.StreamRing) error
 		return err
 	}
 
+	// the ring named in the path, ex) /stream/lobby-cam
+	ring, err = pw.Rings.LookupRing(pw.Method, pw.Path, ring)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
 	// send response and multipart
 	switch pw.Method {
 	case "POST":
-		ring.Boundary = pw.Boundary
+		ring.SetBoundary(pw.Boundary)
 
 		err = pw.ResponsePost(ws)
 		if err != nil {
@@ -607,7 +626,7 @@ func (pw *ProtoWs) HandleRequest(ws *websocket.Conn, ring *sr.StreamRing) error
 			return err
 		}
 	case "GET":
-		pw.Boundary = ring.Boundary
+		pw.Boundary = ring.GetBoundary()
 
 		err = pw.ResponseGet(ws)
 		if err != nil {
@@ -645,6 +664,7 @@ func (pw *ProtoWs) ReadRequest(ws *websocket.Conn) error {
 	//fmt.Println(req)
 
 	pw.Method = req.Method
+	pw.Path = req.URL.Path
 	ctype := req.Header.Get(sb.STR_HDR_CONTENT_TYPE)
 	if ctype != "" {
 		pw.Boundary, err = GetTypeBoundary(req.Header.Get(sb.STR_HDR_CONTENT_TYPE))
@@ -779,7 +799,8 @@ func WriteFileInFrame(ws *websocket.Conn, file string, boundary string) error {
 func ReadStreamToData(ws *websocket.Conn, boundary string) error {
 	var err error
 
-	slot := sr.NewStreamSlot()
+	slot := sr.NewStreamSlotFromPool(sr.LEN_DEF_SLOT)
+	defer slot.Free()
 
 	for {
 		err = ReadFrameToSlot(ws, slot, boundary)
@@ -908,6 +929,8 @@ func ParseFrameHeader(r *bufio.Reader, slot *sr.StreamSlot) int {
 	slot.Timestamp = sb.GetTimestampNow()
 
 	slot.Type = res[sb.STR_HDR_CONTENT_TYPE]
+	slot.Keyframe = res[sb.STR_HDR_KEYFRAME] == "1"
+	slot.Origin = res[sb.STR_HDR_ORIGIN]
 	sl := res[sb.STR_HDR_CONTENT_LENGTH]
 	nl, _ = strconv.Atoi(sl)
 
@@ -947,26 +970,42 @@ func WriteRingInStream(ws *websocket.Conn, ring *sr.StreamRing) error {
 		return sb.ErrStatus
 	}
 
-	var pos int
+	rr := ring.NewRingReader()
+	defer rr.Close()
+	slot := sr.NewStreamSlotFromPool(ring.Size)
+	defer slot.Free()
+
+	// canceled when the connection is closed
+	ctx := ws.Request().Context()
+
+	var skip int
 	for {
-		slot, npos, err := ring.GetSlotNextByPos(pos)
+		skip, err = rr.WaitSlotTo(ctx, sb.TIME_DEF_BLOCK, slot)
 		if err != nil {
-			if err == sb.ErrEmpty {
-				time.Sleep(sb.TIME_DEF_WAIT)
+			// not to be returned when the ring is over in the meantime
+			if err == sb.ErrEmpty || err == sb.ErrTimeout {
+				err = nil
+				continue
+			}
+			if err == sb.ErrOverrun {
+				log.Printf("overrun, %d slots skipped\n", skip)
+				err = nil
 				continue
 			}
+			if err == ctx.Err() {
+				err = nil // the client is gone, not a failure
+				break
+			}
 			log.Println(err)
 			break
 		}
 
-		err = WriteSlotInFrame(ws, slot, ring.Boundary)
+		err = WriteSlotInFrame(ws, slot, ring.GetBoundary())
 		if err != nil {
 			log.Println(err)
 			break
 		}
 		fmt.Println("3>", slot)
-
-		pos = npos
 	}
 
 	return err
@@ -979,7 +1018,8 @@ func WriteDataInFrame(ws *websocket.Conn, data []byte, dsize int, dtype string,
 	var err error
 
 	// prepare a slot and its data
-	slot := sr.NewStreamSlotBySize(dsize)
+	slot := sr.NewStreamSlotFromPool(dsize)
+	defer slot.Free()
 	defer fmt.Println("1>", slot)
 
 	slot.Type = dtype
@@ -1006,6 +1046,12 @@ func WriteSlotInFrame(ws *websocket.Conn, slot *sr.StreamSlot, boundary string)
 	smsg := fmt.Sprintf("\r\n--%s\r\n", boundary)
 	smsg += fmt.Sprintf("Content-Type: %s\r\n", slot.Type)
 	smsg += fmt.Sprintf("Content-Length: %d\r\n", slot.Length)
+	if slot.Keyframe {
+		smsg += fmt.Sprintf("%s: 1\r\n", sb.STR_HDR_KEYFRAME)
+	}
+	if slot.Origin != "" {
+		smsg += fmt.Sprintf("%s: %s\r\n", sb.STR_HDR_ORIGIN, slot.Origin)
+	}
 	//smsg += fmt.Sprintf("X-Audio-Format: format=pcm_16; channel=1; frequency=44100\r\n")
 	smsg += "\r\n"
 
@@ -1032,8 +1078,9 @@ func WriteSlotInFrame(ws *websocket.Conn, slot *sr.StreamSlot, boundary string)
 func ReadMultipartToData(ws *websocket.Conn, boundary string) error {
 	var err error
 
-	slot := sr.NewStreamSlot()
-	mr := multipart.NewReader(ws, boundary)
+	slot := sr.NewStreamSlotFromPool(sr.LEN_DEF_SLOT)
+	defer slot.Free()
+	mr := sr.NewPartReader(ws, boundary)
 
 	for {
 		err = ReadPartToSlot(mr, slot)
@@ -1059,12 +1106,16 @@ func ReadMultipartToRing(ws *websocket.Conn, ring *sr.StreamRing) error {
 	}
 	defer ring.Reset()
 
-	mr := multipart.NewReader(ws, ring.Boundary)
+	mr := sr.NewPartReader(ws, ring.GetBoundary())
 
 	for ring.IsUsing() {
 		slot, pos := ring.GetSlotIn()
 
 		err = ReadPartToSlot(mr, slot)
+		if err == sb.ErrSize {
+			ring.CountOversize()
+			continue
+		}
 		if err != nil {
 			log.Println(err)
 			return err
@@ -1080,80 +1131,7 @@ func ReadMultipartToRing(ws *websocket.Conn, ring *sr.StreamRing) error {
 // recv a part to slot of ring
 //---------------------------------------------------------------------------
 func ReadPartToSlot(mr *multipart.Reader, slot *sr.StreamSlot) error {
-	var err error
-
-	// read a part
-	p, err := mr.NextPart()
-	if err != nil { // io.EOF
-		log.Println(err)
-		return err
-	}
-
-	nl, err := ParsePartHeaderToSlot(p, slot)
-	if err != nil {
-		log.Println(err)
-		return err
-	}
-
-	err = ReadPartBodyToSlot(p, slot, nl)
-	if err != nil {
-		log.Println(err)
-		return err
-	}
-
-	//fmt.Println(">2", slot)
-	return err
-}
-
-//---------------------------------------------------------------------------
-// read a part header and parse it
-//---------------------------------------------------------------------------
-func ParsePartHeaderToSlot(p *multipart.Part, slot *sr.StreamSlot) (int, error) {
-	var err error
-
-	// get header information of each part
-	stamp := p.Header.Get(sb.STR_HDR_TIMESTAMP)
-	if stamp == "" {
-		slot.Timestamp = sb.GetTimestampFromString(stamp)
-	} else {
-		slot.Timestamp = sb.GetTimestampNow()
-	}
-
-	slot.Type = p.Header.Get(sb.STR_HDR_CONTENT_TYPE)
-
-	sl := p.Header.Get(sb.STR_HDR_CONTENT_LENGTH)
-	nl, err := strconv.Atoi(sl)
-	if err != nil {
-		log.Printf("%s %s -> %d\n", p.Header, sl, nl)
-		return nl, err
-	}
-	slot.Length = nl
-
-	return nl, err
-}
-
-//---------------------------------------------------------------------------
-// read a part body to slot
-//---------------------------------------------------------------------------
-func ReadPartBodyToSlot(p *multipart.Part, slot *sr.StreamSlot, nl int) error {
-	var err error
-
-	// to prevent from handling incomplete data
-	slot.Length = 0
-
-	var tn int
-	for tn < nl {
-		n, err := p.Read(slot.Content[tn:])
-		if err != nil {
-			log.Println(err)
-			return err
-		}
-		tn += n
-	}
-
-	slot.Length = nl
-
-	return err
+	return sr.ReadPartToSlot(mr, slot)
 }
 
 // ---------------------------------E-----N-----D--------------------------------
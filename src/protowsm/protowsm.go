@@ -13,6 +13,7 @@ package protowsm
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"crypto/tls"
 	"fmt"
 	"io"
@@ -479,7 +480,8 @@ func (pw *ProtoWs) StreamHandler(ws *websocket.Conn) {
 	r := bufio.NewReader(ws)
 	w := bufio.NewWriter(ws)
 
-	err = pw.HandleRequest(r, w, pw.Ring)
+	// canceled when the connection is closed
+	err = pw.HandleRequest(ws.Request().Context(), r, w, pw.Ring)
 	if err != nil {
 		log.Println(err)
 		return
@@ -595,7 +597,7 @@ func (pw *ProtoWs) ResponseGet(w *bufio.Writer) error {
 //---------------------------------------------------------------------------
 // handle a client request in the server
 //---------------------------------------------------------------------------
-func (pw *ProtoWs) HandleRequest(r *bufio.Reader, w *bufio.Writer, ring *sr.StreamRing) error {
+func (pw *ProtoWs) HandleRequest(ctx context.Context, r *bufio.Reader, w *bufio.Writer, ring *sr.StreamRing) error {
 	var err error
 
 	// recv request and parse it
@@ -608,7 +610,7 @@ func (pw *ProtoWs) HandleRequest(r *bufio.Reader, w *bufio.Writer, ring *sr.Stre
 	// send response and multipart
 	switch pw.Method {
 	case "POST":
-		ring.Boundary = pw.Boundary
+		ring.SetBoundary(pw.Boundary)
 
 		err = pw.ResponsePost(w)
 		if err != nil {
@@ -622,7 +624,7 @@ func (pw *ProtoWs) HandleRequest(r *bufio.Reader, w *bufio.Writer, ring *sr.Stre
 			return err
 		}
 	case "GET":
-		pw.Boundary = ring.Boundary
+		pw.Boundary = ring.GetBoundary()
 
 		err = pw.ResponseGet(w)
 		if err != nil {
@@ -630,7 +632,7 @@ func (pw *ProtoWs) HandleRequest(r *bufio.Reader, w *bufio.Writer, ring *sr.Stre
 			return err
 		}
 
-		err = WriteRingInMultipart(w, ring)
+		err = WriteRingInMultipart(ctx, w, ring)
 		if err != nil {
 			log.Println(err)
 			return err
@@ -789,8 +791,9 @@ func WriteFileInPart(mw *multipart.Writer, file string) error {
 func ReadMultipartToData(r *bufio.Reader, boundary string) error {
 	var err error
 
-	slot := sr.NewStreamSlot()
-	mr := multipart.NewReader(r, boundary)
+	slot := sr.NewStreamSlotFromPool(sr.LEN_DEF_SLOT)
+	defer slot.Free()
+	mr := sr.NewPartReader(r, boundary)
 
 	for {
 		err = ReadPartToSlot(mr, slot)
@@ -816,12 +819,16 @@ func ReadMultipartToRing(r *bufio.Reader, ring *sr.StreamRing) error {
 	}
 	defer ring.Reset()
 
-	mr := multipart.NewReader(r, ring.Boundary)
+	mr := sr.NewPartReader(r, ring.GetBoundary())
 
 	for {
 		slot, pos := ring.GetSlotIn()
 
 		err = ReadPartToSlot(mr, slot)
+		if err == sb.ErrSize {
+			ring.CountOversize()
+			continue
+		}
 		if err != nil {
 			log.Println(err)
 			return err
@@ -836,7 +843,7 @@ func ReadMultipartToRing(r *bufio.Reader, ring *sr.StreamRing) error {
 //---------------------------------------------------------------------------
 // recv multipart to ring buffer
 //---------------------------------------------------------------------------
-func WriteRingInMultipart(w *bufio.Writer, ring *sr.StreamRing) error {
+func WriteRingInMultipart(ctx context.Context, w *bufio.Writer, ring *sr.StreamRing) error {
 	var err error
 
 	if !ring.IsUsing() {
@@ -845,16 +852,31 @@ func WriteRingInMultipart(w *bufio.Writer, ring *sr.StreamRing) error {
 	}
 
 	mw := multipart.NewWriter(w)
-	mw.SetBoundary(ring.Boundary)
+	mw.SetBoundary(ring.GetBoundary())
+
+	rr := ring.NewRingReader()
+	defer rr.Close()
+	slot := sr.NewStreamSlotFromPool(ring.Size)
+	defer slot.Free()
 
-	var pos int
+	var skip int
 	for {
-		slot, npos, err := ring.GetSlotNextByPos(pos)
+		skip, err = rr.WaitSlotTo(ctx, sb.TIME_DEF_BLOCK, slot)
 		if err != nil {
-			if err == sb.ErrEmpty {
-				time.Sleep(sb.TIME_DEF_WAIT)
+			// not to be returned when the ring is over in the meantime
+			if err == sb.ErrEmpty || err == sb.ErrTimeout {
+				err = nil
+				continue
+			}
+			if err == sb.ErrOverrun {
+				log.Printf("overrun, %d slots skipped\n", skip)
+				err = nil
 				continue
 			}
+			if err == ctx.Err() {
+				err = nil // the client is gone, not a failure
+				break
+			}
 			log.Println(err)
 			break
 		}
@@ -865,8 +887,6 @@ func WriteRingInMultipart(w *bufio.Writer, ring *sr.StreamRing) error {
 			break
 		}
 		fmt.Println("3>", slot)
-
-		pos = npos
 	}
 
 	return err
@@ -879,7 +899,8 @@ func WriteDataInPart(mw *multipart.Writer, data []byte, dsize int, dtype string)
 	var err error
 
 	// prepare a slot and its data
-	slot := sr.NewStreamSlotBySize(dsize)
+	slot := sr.NewStreamSlotFromPool(dsize)
+	defer slot.Free()
 	defer fmt.Println("1>", slot)
 
 	slot.Type = dtype
@@ -905,11 +926,19 @@ func WriteSlotInPart(mw *multipart.Writer, slot *sr.StreamSlot) error {
 
 	buf := new(bytes.Buffer)
 
-	part, err := mw.CreatePart(textproto.MIMEHeader{
+	header := textproto.MIMEHeader{
 		sb.STR_HDR_CONTENT_TYPE:   {slot.Type},
 		sb.STR_HDR_CONTENT_LENGTH: {strconv.Itoa(slot.Length)},
 		sb.STR_HDR_TIMESTAMP:      {strconv.FormatInt(slot.Timestamp, 10)},
-	})
+	}
+	if slot.Keyframe {
+		header.Set(sb.STR_HDR_KEYFRAME, "1")
+	}
+	if slot.Origin != "" {
+		header.Set(sb.STR_HDR_ORIGIN, slot.Origin)
+	}
+
+	part, err := mw.CreatePart(header)
 	if err != nil {
 		log.Println(err)
 		return err
@@ -925,76 +954,7 @@ func WriteSlotInPart(mw *multipart.Writer, slot *sr.StreamSlot) error {
 // recv a part to slot of ring
 //---------------------------------------------------------------------------
 func ReadPartToSlot(mr *multipart.Reader, slot *sr.StreamSlot) error {
-	var err error
-
-	// read a part
-	p, err := mr.NextPart()
-	if err != nil { // io.EOF
-		log.Println(err)
-		return err
-	}
-
-	nl, err := ParsePartHeaderToSlot(p, slot)
-	if err != nil {
-		log.Println(err)
-		return err
-	}
-
-	err = ReadPartBodyToSlot(p, slot, nl)
-	if err != nil {
-		log.Println(err)
-		return err
-	}
-
-	//fmt.Println(">2", slot)
-	return err
-}
-
-//---------------------------------------------------------------------------
-// read a part header and parse it
-//---------------------------------------------------------------------------
-func ParsePartHeaderToSlot(p *multipart.Part, slot *sr.StreamSlot) (int, error) {
-	var err error
-
-	// get header information of each part
-	stamp := p.Header.Get(sb.STR_HDR_TIMESTAMP)
-	slot.Timestamp = sb.GetTimestampFromString(stamp)
-
-	slot.Type = p.Header.Get(sb.STR_HDR_CONTENT_TYPE)
-
-	sl := p.Header.Get(sb.STR_HDR_CONTENT_LENGTH)
-	nl, err := strconv.Atoi(sl)
-	if err != nil {
-		log.Printf("%s %s -> %d\n", p.Header, sl, nl)
-		return nl, err
-	}
-	slot.Length = nl
-
-	return nl, err
-}
-
-//---------------------------------------------------------------------------
-// read a part body to slot
-//---------------------------------------------------------------------------
-func ReadPartBodyToSlot(p *multipart.Part, slot *sr.StreamSlot, nl int) error {
-	var err error
-
-	// to prevent from handling incomplete data
-	slot.Length = 0
-
-	var tn int
-	for tn < nl {
-		n, err := p.Read(slot.Content[tn:])
-		if err != nil {
-			log.Println(err)
-			return err
-		}
-		tn += n
-	}
-
-	slot.Length = nl
-
-	return err
+	return sr.ReadPartToSlot(mr, slot)
 }
 
 // ---------------------------------E-----N-----D--------------------------------
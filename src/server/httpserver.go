@@ -6,6 +6,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
@@ -19,6 +20,7 @@ import (
 	pw "stoney/httpserver/src/protows"
 
 	sb "stoney/httpserver/src/streambase"
+	sr "stoney/httpserver/src/streamring"
 )
 
 //---------------------------------------------------------------------------
@@ -35,7 +37,11 @@ var (
 	fports = flag.String("ports", sb.STR_DEF_PTLS, "TCP port to be used for https")
 	fport2 = flag.String("port2", sb.STR_DEF_PORT2, "TCP port to be used for http2")
 	furl   = flag.String("url", "http://"+sb.STR_DEF_HOST+":"+sb.STR_DEF_PORT, "base url to be accessed")
-	froot  = flag.String("root", ".", "Define the root filesystem path")
+	froot  = flag.String("root", mc.STR_MEDIA_ROOT, "Directory of the media store served by /media")
+	fsnap  = flag.String("snap", "snapshot", "Directory to save ring snapshots, none if empty")
+	ftrans = flag.Bool("transcode", false, "Allow players to ask JPEG re-encoded")
+	fkeep  = flag.Duration("retention", 0, "Time window of slots kept in the rings created, 0 for no limit")
+	fring  = flag.String("ring", "copy", "Mode of the rings created, copy, shared or bytes")
 	vflag  = flag.Bool("verbose", false, "Verbose display")
 )
 
@@ -79,6 +85,15 @@ func main() {
 	sc.Port = *fport
 	sc.PortS = *fports
 	sc.Port2 = *fport2
+	sc.Root = *froot
+	sc.SnapDir = *fsnap
+	sc.Transcode = *ftrans
+	sc.Rings.Retention = *fkeep
+	mode, err := sr.GetRingModeByName(*fring)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	sc.Rings.Mode = mode
 
 	fmt.Printf("%s, v.%s\n", STR_MEDIA_SYSTEM, STR_MEDIA_VERSION)
 	fmt.Printf("Default ports: %s,%s,%s\n", sc.Port, sc.PortS, sc.Port2)
@@ -87,6 +102,8 @@ func main() {
 	//hp := ph.NewProtoHttpWithPorts(sc.Port, sc.PortS, sc.Port2)
 	tp := pt.NewProtoTcpWithPorts("8087")
 	wp := pw.NewProtoWsWithPorts("8087", "8443")
+	tp.Rings = sc.Rings
+	wp.Rings = sc.Rings
 
 	ring := sc.Array[0]
 
@@ -95,13 +112,23 @@ func main() {
 
 	// package protohttp
 	case "http_reader":
-		sc.StreamReader(ring, sc.Url)
+		sc.StreamReader(nil, ring, sc.Url)
 	case "http_player":
 		sc.StreamPlayer(ring, sc.Url)
 	case "http_caster":
-		sc.StreamCaster(sc.Url)
+		// relay the images in the directory if no other source
+		fr := pf.NewProtoFile("./static/image/*.jpg", "F-Rr")
+		go fr.DirReader(ring, true)
+		sc.StreamCaster(nil, ring, sc.Url)
 	case "http_server":
-		sc.StreamServer(ring)
+		go sc.StreamServer(ring)
+
+		// shut down gracefully to save the rings for the next start
+		sig := sc.WaitShutdown()
+		log.Printf("shutdown by %v\n", sig)
+		ctx, cancel := context.WithTimeout(context.Background(), mc.TIME_MAX_SHUTDOWN)
+		sc.Shutdown(ctx)
+		cancel()
 	case "http_monitor":
 		sc.StreamMonitor(sc.Url)
 
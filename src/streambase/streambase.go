@@ -52,6 +52,7 @@ const (
 	LEN_MAX_MSG  = 1024
 
 	TIME_DEF_WAIT      = 100 * time.Microsecond
+	TIME_DEF_BLOCK     = time.Second // max time to block waiting for a new slot
 	STR_TIME_PRECISION = "Millisecond"
 )
 
@@ -84,6 +85,9 @@ const (
 	STR_HDR_CONTENT_TYPE   = "Content-Type"
 	STR_HDR_CONTENT_LENGTH = "Content-Length"
 	STR_HDR_TIMESTAMP      = "X-Timestamp"
+	STR_HDR_KEYFRAME       = "X-Keyframe"
+	STR_HDR_ORIGIN         = "X-Origin"
+	STR_HDR_SEQUENCE       = "X-Sequence"
 	STR_HDR_AUDIO_FORMAT   = "X-Audio-Format"
 	STR_HDR_VIDEO_FORMAT   = "X-Video-Format"
 	STR_HDR_GPS_FORMAT     = "X-GPS-Format"
@@ -99,6 +103,11 @@ var (
 	ErrStatus  = errors.New("error invalid status")
 	ErrValue   = errors.New("error invalid value")
 	ErrSupport = errors.New("error not supported")
+	ErrOverrun = errors.New("error overrun")
+	ErrTimeout = errors.New("error timeout")
+	ErrCheck   = errors.New("error checksum")
+	ErrLate    = errors.New("error too late")
+	ErrDup     = errors.New("error duplicated")
 )
 
 //---------------------------------------------------------------------------
@@ -171,6 +180,23 @@ func GetDuration(value int64) time.Duration {
 	return time.Duration(value)
 }
 
+//---------------------------------------------------------------------------
+// get timestamp difference from wait time
+//---------------------------------------------------------------------------
+func GetTimestampByDuration(d time.Duration) int64 {
+	switch STR_TIME_PRECISION {
+	case "Second":
+		return int64(d / time.Second)
+	case "Millisecond":
+		return int64(d / time.Millisecond)
+	case "Microsecond":
+		return int64(d / time.Microsecond)
+	case "Nanosecond":
+	}
+
+	return int64(d)
+}
+
 //---------------------------------------------------------------------------
 // show network interfaces
 //---------------------------------------------------------------------------
@@ -45,6 +45,19 @@ func PutImageToBuffer(img image.Image, itype string, optnum int) ([]byte, error)
 	return buf.Bytes(), err
 }
 
+//----------------------------------------------------------------------------------
+// decode image from buffer in PNG, JPEG, GIF
+//----------------------------------------------------------------------------------
+func GetImageFromBuffer(data []byte) (image.Image, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return img, err
+}
+
 //----------------------------------------------------------------------------------
 // encode image to file in PNG, JPEG, GIF
 //----------------------------------------------------------------------------------
@@ -310,4 +323,52 @@ func GenFractalImage(xz, yz, optnum int) image.Image {
 	return img
 }
 
+//----------------------------------------------------------------------------------
+// draw the source image scaled to fit into the rectangle (nearest neighbor)
+//----------------------------------------------------------------------------------
+func DrawImageScaled(dst draw.Image, r image.Rectangle, src image.Image) {
+	b := src.Bounds()
+	if b.Empty() || r.Empty() {
+		return
+	}
+
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		sy := b.Min.Y + (y-r.Min.Y)*b.Dy()/r.Dy()
+		for x := r.Min.X; x < r.Max.X; x++ {
+			sx := b.Min.X + (x-r.Min.X)*b.Dx()/r.Dx()
+			dst.Set(x, y, src.At(sx, sy))
+		}
+	}
+}
+
+//----------------------------------------------------------------------------------
+// generate mosaic image tiling the images in cols, each in xz * yz,
+// the nil one is left in black
+//----------------------------------------------------------------------------------
+func GenMosaicImage(imgs []image.Image, cols, xz, yz int) image.Image {
+	if cols < 1 {
+		cols = int(math.Ceil(math.Sqrt(float64(len(imgs)))))
+	}
+	if cols < 1 {
+		cols = 1
+	}
+	rows := (len(imgs) + cols - 1) / cols
+	if rows < 1 {
+		rows = 1
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, cols*xz, rows*yz))
+	draw.Draw(img, img.Bounds(), &image.Uniform{black}, image.ZP, draw.Src)
+
+	for i, tile := range imgs {
+		if tile == nil {
+			continue
+		}
+		x, y := (i%cols)*xz, (i/cols)*yz
+		DrawImageScaled(img, image.Rect(x, y, x+xz, y+yz), tile)
+	}
+
+	return img
+}
+
 // ---------------------------------E-----N-----D-----------------------------------
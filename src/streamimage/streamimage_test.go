@@ -8,10 +8,12 @@ package streamimage
 
 import (
 	"fmt"
+	"image"
 	"log"
 	"testing"
 
 	"github.com/nfnt/resize"
+	"github.com/stretchr/testify/assert"
 
 	sb "stoney/httpserver/src/streambase"
 )
@@ -73,4 +75,27 @@ func TestStreamImage(t *testing.T) {
 	fmt.Printf("%d KB\n", len(data)/sb.KBYTE)
 }
 
+//----------------------------------------------------------------------------------
+// test for mosaic of images
+//----------------------------------------------------------------------------------
+func TestMosaicImage(t *testing.T) {
+	var err error
+
+	data, err := PutImageToBuffer(GenSimpleImage(64, 48), "jpg", 80)
+	assert.Nil(t, err)
+
+	tile, err := GetImageFromBuffer(data)
+	assert.Nil(t, err)
+
+	img := GenMosaicImage([]image.Image{tile, nil, tile}, 0, 32, 24)
+	assert.Equal(t, image.Rect(0, 0, 64, 48), img.Bounds())
+
+	_, err = GetImageFromBuffer([]byte("not an image"))
+	assert.NotNil(t, err)
+
+	data, err = PutImageToBuffer(img, "jpg", 80)
+	assert.Nil(t, err)
+	t.Logf("mosaic %d bytes", len(data))
+}
+
 // ---------------------------------E-----N-----D-----------------------------------
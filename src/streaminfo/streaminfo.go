@@ -11,7 +11,9 @@ import (
 	"crypto/rand"
 	"fmt"
 	"log"
+	"mime"
 	"net/url"
+	"strings"
 	"time"
 
 	//"github.com/twinj/uuid"
@@ -26,6 +28,10 @@ const (
 	ID_DEF_CHANNEL = "100"
 	ID_DEF_SOURCE  = "110"
 	ID_DEF_TRACK   = "111"
+
+	STR_TRACK_VIDEO = "video"
+	STR_TRACK_AUDIO = "audio"
+	STR_TRACK_TEXT  = "text"
 )
 
 //==================================================================================
@@ -33,6 +39,7 @@ const (
 //----------------------------------------------------------------------------------
 type Track struct {
 	Id   string
+	Type string // kind of media carried: video, audio, text, ...
 	Desc string
 }
 
@@ -47,6 +54,7 @@ func NewTrack() *Track {
 func (trk *Track) BaseString() string {
 	str := fmt.Sprintf("[Track]")
 	str += fmt.Sprintf("\tId: %s", trk.Id)
+	str += fmt.Sprintf("\tType: %s", trk.Type)
 	str += fmt.Sprintf("\tDesc: %s", trk.Desc)
 	return str
 }
@@ -65,6 +73,35 @@ func (trk *Track) SetId(id int) string {
 	return trk.Id
 }
 
+//----------------------------------------------------------------------------------
+// check if the content type is carried by the track
+//----------------------------------------------------------------------------------
+func (trk *Track) IsType(ctype string) bool {
+	return trk.Type != "" && trk.Type == GetTrackType(ctype)
+}
+
+//----------------------------------------------------------------------------------
+// get the kind of track for the content type, ex) image/jpeg -> video
+//----------------------------------------------------------------------------------
+func GetTrackType(ctype string) string {
+	mt, _, err := mime.ParseMediaType(ctype)
+	if err != nil {
+		return ""
+	}
+
+	major := strings.SplitN(mt, "/", 2)[0]
+	switch major {
+	case "image", "video":
+		return STR_TRACK_VIDEO
+	case "audio":
+		return STR_TRACK_AUDIO
+	case "text", "application":
+		return STR_TRACK_TEXT
+	}
+
+	return major
+}
+
 //==================================================================================
 // source struc
 //----------------------------------------------------------------------------------
@@ -59,6 +59,21 @@ func TestStreamRequest(t *testing.T) {
 	fmt.Println(sreq)
 }
 
+//----------------------------------------------------------------------------------
+// test for track type of content
+//----------------------------------------------------------------------------------
+func TestTrackType(t *testing.T) {
+	t.Log(GetTrackType("image/jpeg"), GetTrackType("video/h264"))
+	if GetTrackType("image/jpeg") != STR_TRACK_VIDEO || GetTrackType("audio/wav; rate=8000") != STR_TRACK_AUDIO {
+		t.Fatal("invalid track type")
+	}
+
+	trk := &Track{Id: ID_DEF_TRACK, Type: STR_TRACK_TEXT}
+	if !trk.IsType("text/plain") || trk.IsType("image/png") || trk.IsType("") {
+		t.Fatal("invalid track match")
+	}
+}
+
 //----------------------------------------------------------------------------------
 // test for uuid
 //----------------------------------------------------------------------------------
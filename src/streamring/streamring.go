@@ -14,7 +14,10 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	bp "stoney/httpserver/src/base"
 	sb "stoney/httpserver/src/streambase"
 )
 
@@ -27,6 +30,13 @@ const (
 	NUM_MAX_SLOTS = 1024
 )
 
+// last epoch given, from the start time not to be repeated after restart
+var lastEpoch = time.Now().UnixNano()
+
+func newEpoch() int64 {
+	return atomic.AddInt64(&lastEpoch, 1)
+}
+
 //==================================================================================
 // stream slot struc
 //----------------------------------------------------------------------------------
@@ -37,6 +47,11 @@ type StreamSlot struct {
 	LengthMax int
 	Content   []byte
 	Timestamp int64
+	Seq       int64        // sequence number given when published to the ring
+	Keyframe  bool         // decodable by itself, start of a GOP
+	Origin    string       // id of the ring the slot came from when combined
+	Frame     *FrameBuffer // frame referred in shared mode, Content is its data
+	pooled    []byte       // buffer drawn from the pool, given back by Free
 }
 
 //----------------------------------------------------------------------------------
@@ -58,6 +73,36 @@ func NewStreamSlotBySize(cmax int) *StreamSlot {
 	}
 }
 
+//----------------------------------------------------------------------------------
+// make a new slot whose buffer is drawn from the pool, to be freed after use
+//----------------------------------------------------------------------------------
+func NewStreamSlotFromPool(cmax int) *StreamSlot {
+	buf := bp.GetBuffer(cmax)
+	return &StreamSlot{
+		Length:    0,
+		LengthMax: cmax,
+		Content:   buf,
+		pooled:    buf,
+	}
+}
+
+//----------------------------------------------------------------------------------
+// release the frame referred in shared mode and give the buffer back to the pool,
+// even if the slot has referred to a frame since drawn
+//----------------------------------------------------------------------------------
+func (ss *StreamSlot) Free() {
+	ss.Release()
+	if ss.pooled == nil {
+		ss.pooled = ss.Content
+	}
+
+	bp.PutBuffer(ss.pooled)
+	ss.pooled = nil
+	ss.Content = nil
+	ss.Length = 0
+	ss.LengthMax = 0
+}
+
 func NewStreamSlotBySlot(cmax int, in *StreamSlot) *StreamSlot {
 	return &StreamSlot{
 		Type:      in.Type,
@@ -80,7 +125,12 @@ func NewStreamSlotByData(cmax int, ctype string, clen int, cdata []byte) *Stream
 // string information for the single slot
 //----------------------------------------------------------------------------------
 func (ss *StreamSlot) String() string {
-	str := fmt.Sprintf("\tTimestamp: %v", ss.Timestamp)
+	str := fmt.Sprintf("\tSeq: %v", ss.Seq)
+	str += fmt.Sprintf("\tTimestamp: %v", ss.Timestamp)
+	str += fmt.Sprintf("\tKeyframe: %v", ss.Keyframe)
+	if ss.Origin != "" {
+		str += fmt.Sprintf("\tOrigin: %s", ss.Origin)
+	}
 	str += fmt.Sprintf("\tType: %v", ss.Type)
 	str += fmt.Sprintf("\tLength: %v/%v(%v)", ss.Length, ss.LengthMax, len(ss.Content))
 	str += fmt.Sprintf("\tContent: ")
@@ -141,9 +191,33 @@ type StreamRing struct {
 	In         int    // input position of buffer to be written
 	Out        int    // output position of buffer to be read
 	TotalBytes int64  // total bytes to recevie
+	Seq        int64  // sequence number of the next slot to be published
 	Boundary   string // description of buffer
 	Desc       string // description of buffer
 	Slots      []StreamSlot
+	Pool       *FramePool     // pool of frames in shared mode, nil in copy mode
+	MaxBytes   int64          // byte budget of slots in bytes mode, 0 in count mode
+	UsedBytes  int64          // bytes used by slots in bytes mode, by the capacity
+	Tail       int64          // sequence number of the oldest slot kept
+	epoch      int64          // generation of the sequence numbers, renewed when reset
+	KeySeq     int64          // sequence number of the last keyframe, -1 if none
+	Retention  time.Duration  // time window of slots kept, 0 for no limit
+	Spill      SlotSpiller    // store of the slots evicted, nil if not spilled
+	Policy     int            // back-pressure policy against lossless readers
+	Timeout    time.Duration  // max time to block the writer by the policy
+	scratch    *StreamSlot    // slot written by the caster before published
+	buffers    *bp.BufferPool // buffers of the slots in bytes mode, not shared with others
+	readers    map[*RingReader]struct{}
+	readerId   int64 // id of the reader made last
+	stats      ringStats
+	notify     chan struct{} // closed and renewed when the ring is changed
+	room       chan struct{} // closed when lossless readers make room
+	active     time.Time     // last time published, read or changed
+	restored   bool          // slots restored or left by the caster, readable while idle
+	spilling   []*StreamSlot // copies of the slots evicted, waiting for the spill worker
+	spillWake  chan struct{} // wakes up the spill worker
+	spillStop  chan struct{} // closed to stop the spill worker
+	spillDone  chan struct{} // closed when the slots queued are all spilled
 }
 
 //----------------------------------------------------------------------------------
@@ -164,6 +238,11 @@ func NewStreamRingWithParams(num int, size int, desc string) *StreamRing {
 		In:   0, Out: 0,
 		Boundary: sb.STR_DEF_BDRY,
 		Desc:     desc,
+		KeySeq:   -1,
+		epoch:    newEpoch(),
+		readers:  make(map[*RingReader]struct{}),
+		notify:   make(chan struct{}),
+		active:   time.Now(),
 	}
 }
 
@@ -190,11 +269,20 @@ func NewStreamArrayWithSize(rnum, snum, size int) []*StreamRing {
 // string information for the stream buffer
 //----------------------------------------------------------------------------------
 func (sr *StreamRing) BaseString() string {
+	stats := sr.Stats()
+
+	sr.Lock()
+	defer sr.Unlock()
+
 	str := fmt.Sprintf("[StreamRing] %s", sr.Id)
 	str += fmt.Sprintf("\tStatus: %s(%d)", sb.StatusText[sr.Status], sr.Status)
 	str += fmt.Sprintf("\tPos: %d,%d", sr.In, sr.Out)
+	str += fmt.Sprintf("\tSeq: %d,%d", sr.Seq, sr.KeySeq)
 	str += fmt.Sprintf("\tSize: %d/%d, %d KB", sr.Num, sr.NumMax, sr.Size/sb.KBYTE)
+	str += fmt.Sprintf("\tBytes: %d/%d KB", sr.bytesUsed()/sb.KBYTE, sr.bytesAllowed()/sb.KBYTE)
+	str += fmt.Sprintf("\tPolicy: %s", PolicyText[sr.Policy])
 	str += fmt.Sprintf("\tTotalBytes: %v", sr.TotalBytes)
+	str += fmt.Sprintf("\tFps: %.1f\tKbps: %.1f\tJitter: %.1f ms\tDrops: %d", stats.FpsNow, stats.KbpsNow, stats.Jitter, stats.Drops)
 	str += fmt.Sprintf("\tBoundary: %s", sr.Boundary)
 	str += fmt.Sprintf("\tDesc: %s", sr.Desc)
 	return str
@@ -202,6 +290,10 @@ func (sr *StreamRing) BaseString() string {
 
 func (sr *StreamRing) String() string {
 	str := fmt.Sprintf("%s\n", sr.BaseString())
+
+	sr.Lock()
+	defer sr.Unlock()
+
 	for i := 0; i < sr.Num; i++ {
 		str += fmt.Sprintf("\t[%d] %s\n", i, sr.Slots[i].String())
 	}
@@ -213,60 +305,158 @@ func (sr *StreamRing) String() string {
 // get the number of slots in buffer for used(len) and allocted(cap)
 //----------------------------------------------------------------------------------
 func (sr *StreamRing) Len() int {
+	sr.Lock()
+	defer sr.Unlock()
+
 	return sr.Num
 }
 
 func (sr *StreamRing) Cap() int {
+	sr.Lock()
+	defer sr.Unlock()
+
 	return sr.NumMax
 }
 
+//----------------------------------------------------------------------------------
+// set and get the boundary of multipart given by the caster
+//----------------------------------------------------------------------------------
+func (sr *StreamRing) SetBoundary(boundary string) {
+	sr.Lock()
+	defer sr.Unlock()
+
+	sr.Boundary = boundary
+}
+
+func (sr *StreamRing) GetBoundary() string {
+	sr.Lock()
+	defer sr.Unlock()
+
+	return sr.Boundary
+}
+
 //----------------------------------------------------------------------------------
 // set status of buffer
 //----------------------------------------------------------------------------------
 func (sr *StreamRing) SetStatus(status int) int {
+	sr.Lock()
+	defer sr.Unlock()
+
 	sr.Status = status
+	sr.notifyAll()
 	return sr.Status
 }
 
 func (sr *StreamRing) SetStatusUsing() error {
+	sr.Lock()
+	defer sr.Unlock()
+
 	var err error
 	if sr.Status != sb.STATUS_IDLE {
 		return sb.ErrStatus
 	}
 	sr.Status = sb.STATUS_USING
+	sr.restored = false
+	sr.notifyAll()
 	return err
 }
 
 func (sr *StreamRing) SetStatusIdle() error {
+	sr.Lock()
+	defer sr.Unlock()
+
 	var err error
 	if sr.Status != sb.STATUS_USING {
 		return sb.ErrStatus
 	}
 	sr.Status = sb.STATUS_IDLE
+	sr.notifyAll()
 	return err
 }
 
+// the slots are left readable for the players until the next caster comes
+func (sr *StreamRing) SetStatusIdleKept() error {
+	sr.Lock()
+	defer sr.Unlock()
+
+	var err error
+	if sr.Status != sb.STATUS_USING {
+		return sb.ErrStatus
+	}
+	sr.Status = sb.STATUS_IDLE
+	sr.restored = sr.oldest() < sr.Seq
+	sr.notifyAll()
+	return err
+}
+
+//----------------------------------------------------------------------------------
+// generation of the sequence numbers, not the same after reset or restart
+//----------------------------------------------------------------------------------
+func (sr *StreamRing) GetEpoch() int64 {
+	sr.Lock()
+	defer sr.Unlock()
+
+	return sr.epoch
+}
+
 func (sr *StreamRing) GetStatus() int {
+	sr.Lock()
+	defer sr.Unlock()
+
 	return sr.Status
 }
 
 func (sr *StreamRing) IsUsing() bool {
-	return sr.Status == sb.STATUS_USING
+	return sr.GetStatus() == sb.STATUS_USING
 }
 
 func (sr *StreamRing) IsIdle() bool {
-	return sr.Status == sb.STATUS_IDLE
+	return sr.GetStatus() == sb.STATUS_IDLE
+}
+
+//----------------------------------------------------------------------------------
+// check the ring can be played, used by the caster or idle with the slots
+// restored by the snapshot until the next caster comes
+//----------------------------------------------------------------------------------
+func (sr *StreamRing) IsReadable() bool {
+	sr.Lock()
+	defer sr.Unlock()
+
+	return sr.Status == sb.STATUS_USING || (sr.Status == sb.STATUS_IDLE && sr.restored)
 }
 
 //----------------------------------------------------------------------------------
-// set the position of slot to be read and written
+// publish the slot written by the caster at once, the slot and In are changed
+// together in lock so that readers never see a slot half written
 //----------------------------------------------------------------------------------
 func (sr *StreamRing) SetPosInByPos(pos int) int {
-	sr.In = (pos % sr.Num)
+	sr.Lock()
+	defer sr.Unlock()
+
+	// nothing written by GetSlotIn
+	if sr.scratch == nil {
+		return sr.In
+	}
+
+	// dropped by the policy, to be overwritten by the next
+	if sr.waitRoom() != nil {
+		return sr.In
+	}
+
+	if sr.IsBytes() {
+		sr.putBytesIn(sr.scratch)
+		return sr.In
+	}
+
+	// pos is expected to be the next of the slot written
+	sr.putScratchIn()
 	return sr.In
 }
 
 func (sr *StreamRing) SetPosOutByPos(pos int) int {
+	sr.Lock()
+	defer sr.Unlock()
+
 	sr.Out = (pos % sr.Num)
 	return sr.Out
 }
@@ -275,22 +465,83 @@ func (sr *StreamRing) SetPosOutByPos(pos int) int {
 // get the current position of slot to read and write
 //----------------------------------------------------------------------------------
 func (sr *StreamRing) GetPosIn() int {
+	sr.Lock()
+	defer sr.Unlock()
+
 	return sr.In
 }
 
 func (sr *StreamRing) GetPosOut() int {
+	sr.Lock()
+	defer sr.Unlock()
+
 	return sr.Out
 }
 
 //----------------------------------------------------------------------------------
-// get the current slot to read and write
+// get the slot to be written by the caster, it is not seen by readers until
+// published by SetPosInByPos, only for the single caster using the ring
 //----------------------------------------------------------------------------------
 func (sr *StreamRing) GetSlotIn() (*StreamSlot, int) {
-	slot := &sr.Slots[sr.In]
-	return slot, sr.In
+	sr.Lock()
+	defer sr.Unlock()
+
+	if sr.scratch == nil {
+		sr.scratch = NewStreamSlotBySize(sr.Size)
+		if sr.IsShared() {
+			sr.scratch.Content = nil
+		}
+	}
+
+	st := sr.scratch
+	if sr.IsShared() && st.Frame == nil {
+		st.Frame = sr.Pool.Get(sr.Size)
+		st.Content = st.Frame.Data
+		st.LengthMax = len(st.Content)
+	}
+	st.Length = 0
+	st.Timestamp = 0
+	st.Keyframe = false
+	st.Origin = ""
+
+	return st, sr.In
+}
+
+//----------------------------------------------------------------------------------
+// move the slot written by the caster into the input position, the buffers
+// are swapped not to be copied, must be called in lock
+//----------------------------------------------------------------------------------
+func (sr *StreamRing) putScratchIn() {
+	in := sr.scratch
+	st := &sr.Slots[sr.In]
+	st.Release()
+
+	if sr.IsShared() {
+		st.Frame, in.Frame = in.Frame, nil
+		st.Content, in.Content = in.Content, nil
+		st.LengthMax = len(st.Content)
+	} else {
+		st.Content, in.Content = in.Content, st.Content
+		st.LengthMax, in.LengthMax = in.LengthMax, st.LengthMax
+	}
+
+	st.Type = in.Type
+	st.Length = in.Length
+	st.Timestamp = in.Timestamp
+	st.Keyframe = in.Keyframe
+	st.Origin = in.Origin
+
+	sr.publishSlotIn()
 }
 
+//----------------------------------------------------------------------------------
+// get the slot at the output position, the slot is not copied
+// and may be overwritten later, use RingReader to read it safely
+//----------------------------------------------------------------------------------
 func (sr *StreamRing) GetSlotOut() (*StreamSlot, int) {
+	sr.Lock()
+	defer sr.Unlock()
+
 	slot := &sr.Slots[sr.Out]
 	return slot, sr.Out
 }
@@ -299,6 +550,9 @@ func (sr *StreamRing) GetSlotOut() (*StreamSlot, int) {
 // get the slot designated
 //----------------------------------------------------------------------------------
 func (sr *StreamRing) GetSlotByPos(pos int) (*StreamSlot, error) {
+	sr.Lock()
+	defer sr.Unlock()
+
 	var err error
 
 	pos = pos % sr.Num
@@ -311,6 +565,9 @@ func (sr *StreamRing) GetSlotByPos(pos int) (*StreamSlot, error) {
 // get the slot to be read and move to the next
 //----------------------------------------------------------------------------------
 func (sr *StreamRing) GetSlotOutNext() (*StreamSlot, error) {
+	sr.Lock()
+	defer sr.Unlock()
+
 	var err error
 
 	// no data to read
@@ -328,6 +585,9 @@ func (sr *StreamRing) GetSlotOutNext() (*StreamSlot, error) {
 // get the pointer of slot designated and go to the next
 //----------------------------------------------------------------------------------
 func (sr *StreamRing) GetSlotNextByPos(pos int) (*StreamSlot, int, error) {
+	sr.Lock()
+	defer sr.Unlock()
+
 	var err error
 
 	pos = pos % sr.Num
@@ -356,17 +616,145 @@ func (sr *StreamRing) PutSlotInNext(slot *StreamSlot) (*StreamSlot, error) {
 		return nil, fmt.Errorf("too big data size")
 	}
 
+	err = sr.waitRoom()
+	if err != nil {
+		return nil, err
+	}
+
+	return sr.putSlotIn(slot)
+}
+
+//----------------------------------------------------------------------------------
+// write the slot and get the sequence number given to it, not the slot
+// which may be changed by others after the lock is released
+//----------------------------------------------------------------------------------
+func (sr *StreamRing) PutSlotInNextSeq(slot *StreamSlot) (int64, error) {
+	sr.Lock()
+	defer sr.Unlock()
+
+	var err error
+
+	if slot.Length > sr.Size {
+		return -1, fmt.Errorf("too big data size")
+	}
+
+	err = sr.waitRoom()
+	if err != nil {
+		return -1, err
+	}
+
+	st, err := sr.putSlotIn(slot)
+	if err != nil {
+		return -1, err
+	}
+
+	return st.Seq, nil
+}
+
+//----------------------------------------------------------------------------------
+// copy the slot into the input position and publish it, must be called in lock
+//----------------------------------------------------------------------------------
+func (sr *StreamRing) putSlotIn(slot *StreamSlot) (*StreamSlot, error) {
+	var err error
+
+	if sr.IsBytes() {
+		return sr.putBytesIn(slot)
+	}
+
 	st := &sr.Slots[sr.In]
+	if sr.IsShared() {
+		st = sr.renewSlotIn()
+	}
 
 	st.Type = slot.Type
 	st.Length = slot.Length
+	st.Timestamp = slot.Timestamp
+	st.Keyframe = slot.Keyframe
+	st.Origin = slot.Origin
 	copy(st.Content, slot.Content)
 
-	sr.In = (sr.In + 1) % sr.Num
+	sr.publishSlotIn()
 
 	return st, err
 }
 
+//----------------------------------------------------------------------------------
+// give the sequence number to the slot written at the input position,
+// go to the next and expire the old slots, must be called in lock
+//----------------------------------------------------------------------------------
+func (sr *StreamRing) publishSlotIn() {
+	st := &sr.Slots[sr.In]
+	if st.Timestamp == 0 {
+		st.Timestamp = sb.GetTimestampNow()
+	}
+	st.Seq = sr.Seq
+	sr.updateStats(st)
+	if st.Keyframe {
+		sr.KeySeq = st.Seq
+	}
+
+	sr.Seq++
+	sr.In = (sr.In + 1) % sr.Num
+
+	// the slot to be overwritten by wrapping around is evicted now,
+	// the current GOP in use is lost only if not kept by waitRoom
+	for sr.Tail < sr.Seq-int64(sr.Num-1) {
+		sr.evictTail()
+	}
+
+	if sr.Retention > 0 {
+		sr.expireSlots(st.Timestamp)
+	}
+
+	sr.notifyAll()
+}
+
+//----------------------------------------------------------------------------------
+// evict the oldest slot kept, must be called in lock
+//----------------------------------------------------------------------------------
+func (sr *StreamRing) evictTail() {
+	ev := &sr.Slots[sr.posOfSeq(sr.Tail)]
+
+	if sr.Spill != nil && ev.Seq == sr.Tail {
+		sr.queueSpill(ev)
+	}
+
+	if sr.IsBytes() {
+		sr.UsedBytes -= int64(cap(ev.Content))
+		sr.buffers.Put(ev.Content)
+		ev.Content = nil
+		ev.Length = 0
+		ev.LengthMax = 0
+	}
+	ev.Release()
+
+	sr.Tail++
+}
+
+//----------------------------------------------------------------------------------
+// wake up all readers waiting for the change of ring, must be called in lock
+//----------------------------------------------------------------------------------
+func (sr *StreamRing) notifyAll() {
+	sr.active = time.Now()
+	if sr.notify != nil {
+		close(sr.notify)
+	}
+	sr.notify = make(chan struct{})
+}
+
+//----------------------------------------------------------------------------------
+// get the channel closed at the next change of ring
+//----------------------------------------------------------------------------------
+func (sr *StreamRing) changed() <-chan struct{} {
+	sr.Lock()
+	defer sr.Unlock()
+
+	if sr.notify == nil {
+		sr.notify = make(chan struct{})
+	}
+	return sr.notify
+}
+
 //----------------------------------------------------------------------------------
 // write the information to the slot designated
 //----------------------------------------------------------------------------------
@@ -376,6 +764,11 @@ func (sr *StreamRing) PutSlotInByPos(slot *StreamSlot, pos int) (*StreamSlot, er
 
 	var err error
 
+	// published frames are immutable, sizes are accounted
+	if sr.IsShared() || sr.IsBytes() {
+		return nil, sb.ErrSupport
+	}
+
 	pos = (pos % sr.Num)
 	st := &sr.Slots[pos]
 
@@ -393,20 +786,49 @@ func (sr *StreamRing) Reset() {
 	sr.Lock()
 	defer sr.Unlock()
 
+	sr.reset()
+	sr.Status = sb.STATUS_IDLE
+	sr.Desc = "Buffer is reset"
+	sr.notifyAll()
+}
+
+//----------------------------------------------------------------------------------
+// clear the slots and positions, must be called in lock
+//----------------------------------------------------------------------------------
+func (sr *StreamRing) reset() {
 	for i := 0; i < sr.NumMax; i++ {
+		sr.Slots[i].Release()
 		sr.Slots[i].Type = ""
 		sr.Slots[i].Length = 0
+		sr.Slots[i].Keyframe = false
+		sr.Slots[i].Origin = ""
+		if sr.IsBytes() {
+			sr.buffers.Put(sr.Slots[i].Content)
+			sr.Slots[i].Content = nil
+		}
 	}
 
 	sr.In = 0
 	sr.Out = 0
+	sr.Seq = 0
+	sr.Tail = 0
+	sr.KeySeq = -1
+	sr.epoch = newEpoch()
+	sr.UsedBytes = 0
+	sr.TotalBytes = 0
+	sr.stats = ringStats{}
+	sr.restored = false
+	sr.dropSpilling()
+	sr.notifyRoom()
 	sr.Num = sr.NumMax
-	sr.Status = sb.STATUS_IDLE
-	sr.Desc = "Buffer is reset"
 }
 
 //----------------------------------------------------------------------------------
-// change the size of buffer, i.e, the number of slots
+// change the size of buffer, i.e, the number of slots, growing or shrinking
+// while the casters and readers are active
+// - the newest slots are kept, the older ones are evicted if shrunk
+// - the readers continue at the same sequence number, or get overrun
+//   if their slots are evicted
 //----------------------------------------------------------------------------------
 func (sr *StreamRing) Resize(num int) error {
 	sr.Lock()
@@ -418,28 +840,103 @@ func (sr *StreamRing) Resize(num int) error {
 		return fmt.Errorf("%d is invalid, use a number between 2 - %d", num, NUM_MAX_SLOTS)
 	}
 
-	if num > sr.NumMax {
-		slot := StreamSlot{
-			Length:    sr.Size,
-			LengthMax: sr.Size,
-			Content:   make([]byte, sr.Size),
-		}
-		for i := 0; i < num-sr.NumMax; i++ {
-			sr.Slots = append(sr.Slots, slot)
+	// the number of slots is decided by the byte budget
+	if sr.IsBytes() {
+		return sb.ErrSupport
+	}
+
+	sr.resize(num)
+
+	return err
+}
+
+//----------------------------------------------------------------------------------
+// change the number of slots keeping the newest, must be called in lock
+//----------------------------------------------------------------------------------
+func (sr *StreamRing) resize(num int) {
+	// the slot at the input position is being written, so only num-1 are kept
+	for sr.Tail < sr.Seq-int64(num-1) {
+		sr.evictTail()
+	}
+
+	slots := make([]StreamSlot, num)
+
+	// move the slots kept to the front in order of the sequence
+	kept := int(sr.Seq - sr.Tail)
+	for i := 0; i < kept; i++ {
+		pos := sr.posOfSeq(sr.Tail + int64(i))
+		moveSlot(&slots[i], &sr.Slots[pos])
+	}
+
+	// the rest are released, and new ones get their own buffers
+	for i := range sr.Slots {
+		sr.Slots[i].Release()
+	}
+	if !sr.IsShared() && !sr.IsBytes() {
+		for i := kept; i < num; i++ {
+			slots[i].Content = make([]byte, sr.Size)
+			slots[i].LengthMax = sr.Size
 		}
-		sr.NumMax = num
 	}
+
+	// out position at the same sequence, or the oldest if evicted
+	out := sr.Seq - int64((sr.In-sr.Out+sr.Num)%sr.Num)
+
+	sr.Slots = slots
 	sr.Num = num
+	sr.NumMax = num
+	sr.In = kept % num
 
-	return err
+	if out >= sr.Tail && out <= sr.Seq {
+		sr.Out = sr.posOfSeq(out)
+	} else {
+		sr.Out = sr.posOfSeq(sr.Tail)
+	}
+
+	// readers out of the ring get overrun at the next read
+	for rr := range sr.readers {
+		if rr.Seq >= sr.oldest() && rr.Seq <= sr.Seq {
+			rr.Pos = sr.posOfSeq(rr.Seq)
+		} else {
+			rr.Pos = sr.In
+		}
+	}
+
+	sr.notifyRoom()
+	sr.notifyAll()
+}
+
+//----------------------------------------------------------------------------------
+// move the data and information of the slot to another, the source is cleared
+//----------------------------------------------------------------------------------
+func moveSlot(dst *StreamSlot, src *StreamSlot) {
+	dst.Type = src.Type
+	dst.Length = src.Length
+	dst.LengthMax = src.LengthMax
+	dst.Content = src.Content
+	dst.Timestamp = src.Timestamp
+	dst.Seq = src.Seq
+	dst.Keyframe = src.Keyframe
+	dst.Origin = src.Origin
+	dst.Frame = src.Frame
+
+	src.Frame = nil
+	src.Content = nil
+	src.Length = 0
+	src.LengthMax = 0
 }
 
 //----------------------------------------------------------------------------------
 // read out the slot to new one
 //----------------------------------------------------------------------------------
 func (sr *StreamRing) ReadSlotIn() (*StreamSlot, int) {
+	sr.Lock()
+	defer sr.Unlock()
+
 	in := &sr.Slots[sr.In]
-	slot := NewStreamSlotByData(sr.Size, in.Type, in.Length, in.Content)
+	data := make([]byte, len(in.Content))
+	copy(data, in.Content)
+	slot := NewStreamSlotByData(sr.Size, in.Type, in.Length, data)
 	return slot, sr.In
 }
 
@@ -0,0 +1,151 @@
+//==================================================================================
+// Author : Stoney Kang, sikang99@gmail.com, 2015
+// Byte budgeted mode of the ring with variable size slots
+//==================================================================================
+
+package streamring
+
+import (
+	"fmt"
+
+	bp "stoney/httpserver/src/base"
+)
+
+//----------------------------------------------------------------------------------
+// make a new ring capped by total bytes, each slot is sized to its content
+// and the oldest ones are evicted when over the budget, the slots are
+// added as needed up to NUM_MAX_SLOTS, the buffers evicted are reused only
+// in the ring as the slots read are still used after the lock
+//----------------------------------------------------------------------------------
+func NewStreamRingWithBytes(max int64, size int, desc string) *StreamRing {
+	if int64(size) > max {
+		size = int(max)
+	}
+
+	sr := NewStreamRingWithParams(NUM_DEF_SLOTS, 0, desc)
+	for i := range sr.Slots {
+		sr.Slots[i].Content = nil
+	}
+	sr.Size = size
+	sr.MaxBytes = max
+	sr.scratch = NewStreamSlotBySize(size)
+	sr.buffers = bp.NewBufferPool(1, bp.LEN_MAX_CLASS) // classes from 1 byte, not to charge small frames much
+
+	return sr
+}
+
+func (sr *StreamRing) IsBytes() bool {
+	return sr.MaxBytes > 0
+}
+
+//----------------------------------------------------------------------------------
+// get the bytes used by the slots and allowed for them
+//----------------------------------------------------------------------------------
+func (sr *StreamRing) BytesUsed() int64 {
+	sr.Lock()
+	defer sr.Unlock()
+
+	return sr.bytesUsed()
+}
+
+func (sr *StreamRing) BytesAllowed() int64 {
+	sr.Lock()
+	defer sr.Unlock()
+
+	return sr.bytesAllowed()
+}
+
+func (sr *StreamRing) bytesUsed() int64 {
+	if sr.IsBytes() {
+		return sr.UsedBytes
+	}
+
+	var used int64
+	for i := 0; i < sr.Num; i++ {
+		used += int64(sr.Slots[i].Length)
+	}
+	return used
+}
+
+func (sr *StreamRing) bytesAllowed() int64 {
+	if sr.IsBytes() {
+		return sr.MaxBytes
+	}
+	return int64(sr.Num) * int64(sr.Size)
+}
+
+//----------------------------------------------------------------------------------
+// sequence number of the oldest slot readable, must be called in lock
+//----------------------------------------------------------------------------------
+func (sr *StreamRing) oldest() int64 {
+	// the slot at the input position is being written, so only Num-1 are valid
+	old := sr.Seq - int64(sr.Num-1)
+	if old < sr.Tail {
+		old = sr.Tail
+	}
+	return old
+}
+
+//----------------------------------------------------------------------------------
+// position of the slot having the sequence number, must be called in lock
+//----------------------------------------------------------------------------------
+func (sr *StreamRing) posOfSeq(seq int64) int {
+	pos := (sr.In - int((sr.Seq-seq)%int64(sr.Num))) % sr.Num
+	if pos < 0 {
+		pos += sr.Num
+	}
+	return pos
+}
+
+//----------------------------------------------------------------------------------
+// copy the slot into the input position sized to its content and go to the next,
+// then evict the oldest slots over the budget, must be called in lock
+//----------------------------------------------------------------------------------
+func (sr *StreamRing) putBytesIn(in *StreamSlot) (*StreamSlot, error) {
+	var err error
+
+	if in.Length > sr.Size {
+		return nil, fmt.Errorf("too big data size")
+	}
+
+	// the capacity of the buffer is charged, not the length
+	buf := sr.buffers.Get(in.Length)
+
+	// more slots rather than evicting the ones under the budget
+	if sr.Seq-sr.Tail >= int64(sr.Num-1) && sr.Num < NUM_MAX_SLOTS &&
+		sr.UsedBytes+int64(cap(buf)) <= sr.MaxBytes {
+		num := sr.Num * 2
+		if num > NUM_MAX_SLOTS {
+			num = NUM_MAX_SLOTS
+		}
+		sr.resize(num)
+	}
+
+	st := &sr.Slots[sr.In]
+	sr.UsedBytes -= int64(cap(st.Content))
+	sr.buffers.Put(st.Content)
+
+	st.Content = buf
+	copy(st.Content, in.Content[:in.Length])
+	st.Type = in.Type
+	st.Length = in.Length
+	st.LengthMax = in.Length
+	st.Timestamp = in.Timestamp
+	st.Keyframe = in.Keyframe
+	st.Origin = in.Origin
+	sr.UsedBytes += int64(cap(buf))
+
+	sr.publishSlotIn()
+
+	// keep the newest one at least, and the current GOP in use over the budget
+	for sr.UsedBytes > sr.MaxBytes && sr.Tail < sr.Seq-1 {
+		if !sr.canEvictTail() {
+			break
+		}
+		sr.evictTail()
+	}
+
+	return st, err
+}
+
+// ---------------------------------E-----N-----D-----------------------------------
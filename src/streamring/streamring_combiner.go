@@ -0,0 +1,250 @@
+//==================================================================================
+// Author : Stoney Kang, sikang99@gmail.com, 2015
+// Fan-in combiner merging several rings into one composite stream
+// - interleave : frames are re-published tagged with their origin
+// - mosaic     : the last JPEG frames are tiled into a frame at the target fps
+//==================================================================================
+
+package streamring
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	sb "stoney/httpserver/src/streambase"
+	sm "stoney/httpserver/src/streamimage"
+)
+
+//----------------------------------------------------------------------------------
+const (
+	COMBINE_INTERLEAVE = iota
+	COMBINE_MOSAIC
+)
+
+var CombineText = map[int]string{
+	COMBINE_INTERLEAVE: "interleave",
+	COMBINE_MOSAIC:     "mosaic",
+}
+
+const (
+	NUM_DEF_MOSAIC_FPS = 10
+	NUM_DEF_QUALITY    = 80
+	NUM_DEF_TILE_X     = 320
+	NUM_DEF_TILE_Y     = 240
+)
+
+//----------------------------------------------------------------------------------
+// get the combine mode by its name
+//----------------------------------------------------------------------------------
+func GetCombineByName(name string) (int, error) {
+	for mode, text := range CombineText {
+		if text == name {
+			return mode, nil
+		}
+	}
+	return COMBINE_INTERLEAVE, fmt.Errorf("unknown combine mode: %s", name)
+}
+
+//==================================================================================
+// ring combiner struc
+//----------------------------------------------------------------------------------
+type RingCombiner struct {
+	sync.Mutex
+	Mode    int
+	Inputs  []*StreamRing
+	Output  *StreamRing
+	Fps     int // target fps of mosaic
+	Cols    int // tiles in a row of mosaic, square by default
+	TileX   int // size of a tile
+	TileY   int
+	Quality int   // JPEG quality of mosaic
+	Frames  int64 // frames written to the output
+	Errors  int64 // frames failed to decode or write
+	tiles   []image.Image
+}
+
+//----------------------------------------------------------------------------------
+// make a new combiner of the inputs into the output
+//----------------------------------------------------------------------------------
+func NewRingCombiner(mode int, out *StreamRing, ins ...*StreamRing) *RingCombiner {
+	return &RingCombiner{
+		Mode:    mode,
+		Inputs:  ins,
+		Output:  out,
+		Fps:     NUM_DEF_MOSAIC_FPS,
+		TileX:   NUM_DEF_TILE_X,
+		TileY:   NUM_DEF_TILE_Y,
+		Quality: NUM_DEF_QUALITY,
+		tiles:   make([]image.Image, len(ins)),
+	}
+}
+
+//----------------------------------------------------------------------------------
+// string information for the combiner
+//----------------------------------------------------------------------------------
+func (rc *RingCombiner) String() string {
+	rc.Lock()
+	defer rc.Unlock()
+
+	str := fmt.Sprintf("[RingCombiner] %s", CombineText[rc.Mode])
+	str += fmt.Sprintf("\tInputs: %d -> %s", len(rc.Inputs), rc.Output.Id)
+	if rc.Mode == COMBINE_MOSAIC {
+		str += fmt.Sprintf("\tFps: %d\tTile: %dx%d", rc.Fps, rc.TileX, rc.TileY)
+	}
+	str += fmt.Sprintf("\tFrames: %d\tErrors: %d", rc.Frames, rc.Errors)
+	return str
+}
+
+//----------------------------------------------------------------------------------
+// get the origin of the input, its id or index if no id
+//----------------------------------------------------------------------------------
+func (rc *RingCombiner) origin(i int) string {
+	if id := rc.Inputs[i].Id; id != "" {
+		return id
+	}
+	return strconv.Itoa(i)
+}
+
+//----------------------------------------------------------------------------------
+// run the combiner until ctx is done, the output is in use while running
+//----------------------------------------------------------------------------------
+func (rc *RingCombiner) Run(ctx context.Context) error {
+	var err error
+
+	if len(rc.Inputs) == 0 {
+		return sb.ErrEmpty
+	}
+
+	err = rc.Output.SetStatusUsing()
+	if err != nil {
+		log.Println(sb.RedString("ErrStatus/RingCombiner"))
+		return err
+	}
+	defer rc.Output.SetStatusIdle()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for i := range rc.Inputs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			rc.readInput(ctx, i)
+		}(i)
+	}
+
+	if rc.Mode == COMBINE_MOSAIC {
+		rc.writeMosaic(ctx)
+	}
+
+	wg.Wait()
+	return err
+}
+
+//----------------------------------------------------------------------------------
+// read frames of the input, to the output in interleave mode
+// or to the tile in mosaic mode
+//----------------------------------------------------------------------------------
+func (rc *RingCombiner) readInput(ctx context.Context, i int) {
+	rr := rc.Inputs[i].NewRingReader()
+	defer rr.Close()
+
+	slot := NewStreamSlotBySize(rc.Inputs[i].Size)
+	defer slot.Release()
+
+	origin := rc.origin(i)
+
+	for ctx.Err() == nil {
+		_, err := rr.WaitSlotTo(ctx, time.Second, slot)
+		if err != nil {
+			continue // empty, timeout or overrun
+		}
+
+		if rc.Mode == COMBINE_MOSAIC {
+			rc.putTile(i, slot)
+			continue
+		}
+
+		slot.Origin = origin
+		_, err = rc.Output.PutSlotInNext(slot)
+		rc.count(err)
+	}
+}
+
+//----------------------------------------------------------------------------------
+// decode the JPEG frame as the tile of the input
+//----------------------------------------------------------------------------------
+func (rc *RingCombiner) putTile(i int, slot *StreamSlot) {
+	if !slot.IsType("image/jpeg") {
+		return
+	}
+
+	img, err := sm.GetImageFromBuffer(slot.Content[:slot.Length])
+	if err != nil {
+		rc.count(err)
+		return
+	}
+
+	rc.Lock()
+	rc.tiles[i] = img
+	rc.Unlock()
+}
+
+//----------------------------------------------------------------------------------
+// tile the last frames of the inputs and write at the target fps
+//----------------------------------------------------------------------------------
+func (rc *RingCombiner) writeMosaic(ctx context.Context) {
+	fps := rc.Fps
+	if fps < 1 {
+		fps = NUM_DEF_MOSAIC_FPS
+	}
+
+	ticker := time.NewTicker(time.Second / time.Duration(fps))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		rc.Lock()
+		tiles := append([]image.Image(nil), rc.tiles...)
+		rc.Unlock()
+
+		img := sm.GenMosaicImage(tiles, rc.Cols, rc.TileX, rc.TileY)
+		data, err := sm.PutImageToBuffer(img, "jpg", rc.Quality)
+		if err != nil {
+			rc.count(err)
+			continue
+		}
+
+		slot := NewStreamSlotByData(len(data), "image/jpeg", len(data), data)
+		slot.Keyframe = true
+		_, err = rc.Output.PutSlotInNext(slot)
+		rc.count(err)
+	}
+}
+
+//----------------------------------------------------------------------------------
+// count the frame written or failed
+//----------------------------------------------------------------------------------
+func (rc *RingCombiner) count(err error) {
+	rc.Lock()
+	defer rc.Unlock()
+
+	if err != nil {
+		rc.Errors++
+		return
+	}
+	rc.Frames++
+}
+
+// ---------------------------------E-----N-----D-----------------------------------
@@ -0,0 +1,196 @@
+//==================================================================================
+// Author : Stoney Kang, sikang99@gmail.com, 2015
+// Reference counted frame buffers for zero-copy fan-out of the ring
+//==================================================================================
+
+package streamring
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+//==================================================================================
+// frame pool struc
+//----------------------------------------------------------------------------------
+type FramePool struct {
+	Size   int   // size of the buffer in the pool
+	Gets   int64 // number of buffers taken
+	Allocs int64 // number of buffers allocated newly
+	pool   sync.Pool
+}
+
+//----------------------------------------------------------------------------------
+// make a new pool of frame buffers of given size
+//----------------------------------------------------------------------------------
+func NewFramePool(size int) *FramePool {
+	return &FramePool{
+		Size: size,
+	}
+}
+
+func (fp *FramePool) String() string {
+	str := fmt.Sprintf("[FramePool] Size: %d", fp.Size)
+	str += fmt.Sprintf("\tGets: %d", atomic.LoadInt64(&fp.Gets))
+	str += fmt.Sprintf("\tAllocs: %d", atomic.LoadInt64(&fp.Allocs))
+	return str
+}
+
+//----------------------------------------------------------------------------------
+// get a buffer with one reference, at least n bytes long
+//----------------------------------------------------------------------------------
+func (fp *FramePool) Get(n int) *FrameBuffer {
+	atomic.AddInt64(&fp.Gets, 1)
+
+	if n > fp.Size {
+		atomic.AddInt64(&fp.Allocs, 1)
+		return &FrameBuffer{Data: make([]byte, n), refs: 1}
+	}
+
+	fb, ok := fp.pool.Get().(*FrameBuffer)
+	if !ok {
+		atomic.AddInt64(&fp.Allocs, 1)
+		fb = &FrameBuffer{Data: make([]byte, fp.Size)}
+	}
+	fb.pool = fp
+	fb.refs = 1
+
+	return fb
+}
+
+//==================================================================================
+// frame buffer struc, immutable after published to the ring
+//----------------------------------------------------------------------------------
+type FrameBuffer struct {
+	Data []byte
+	refs int32
+	pool *FramePool
+}
+
+//----------------------------------------------------------------------------------
+// add a reference to the buffer
+//----------------------------------------------------------------------------------
+func (fb *FrameBuffer) Retain() *FrameBuffer {
+	atomic.AddInt32(&fb.refs, 1)
+	return fb
+}
+
+//----------------------------------------------------------------------------------
+// drop a reference, the buffer goes back to the pool with the last one
+//----------------------------------------------------------------------------------
+func (fb *FrameBuffer) Release() {
+	refs := atomic.AddInt32(&fb.refs, -1)
+	if refs < 0 {
+		panic("streamring: frame buffer released too many times")
+	}
+	if refs == 0 && fb.pool != nil {
+		fb.pool.pool.Put(fb)
+	}
+}
+
+func (fb *FrameBuffer) Refs() int {
+	return int(atomic.LoadInt32(&fb.refs))
+}
+
+//----------------------------------------------------------------------------------
+// release the frame referenced by the slot (view), if any
+//----------------------------------------------------------------------------------
+func (ss *StreamSlot) Release() {
+	if ss.Frame == nil {
+		return
+	}
+
+	ss.Frame.Release()
+	ss.Frame = nil
+	ss.Content = nil
+	ss.Length = 0
+	ss.LengthMax = 0
+}
+
+//----------------------------------------------------------------------------------
+// make the slot a read-only view of the given one sharing its frame
+//----------------------------------------------------------------------------------
+func (ss *StreamSlot) shareFrom(in *StreamSlot) {
+	ss.Release()
+
+	ss.Frame = in.Frame.Retain()
+	ss.Type = in.Type
+	ss.Length = in.Length
+	ss.LengthMax = in.LengthMax
+	ss.Content = in.Content
+	ss.Timestamp = in.Timestamp
+	ss.Seq = in.Seq
+	ss.Keyframe = in.Keyframe
+	ss.Origin = in.Origin
+}
+
+//==================================================================================
+// shared(zero-copy) mode of the ring
+//----------------------------------------------------------------------------------
+// make a new ring whose slots refer to frame buffers drawn from the pool
+//----------------------------------------------------------------------------------
+func NewStreamRingShared(num int, size int, desc string) *StreamRing {
+	sr := NewStreamRingWithParams(num, 0, desc)
+	sr.Size = size
+	sr.Pool = NewFramePool(size)
+	return sr
+}
+
+func (sr *StreamRing) IsShared() bool {
+	return sr.Pool != nil
+}
+
+//----------------------------------------------------------------------------------
+// hand the frame over to the ring and go to the next, the ring owns it after
+//----------------------------------------------------------------------------------
+func (sr *StreamRing) PutFrameInNext(ctype string, frame *FrameBuffer, length int, tstamp int64) (*StreamSlot, error) {
+	sr.Lock()
+	defer sr.Unlock()
+
+	var err error
+
+	if length > len(frame.Data) {
+		frame.Release()
+		return nil, fmt.Errorf("too big data size")
+	}
+
+	err = sr.waitRoom()
+	if err != nil {
+		frame.Release()
+		return nil, err
+	}
+
+	st := &sr.Slots[sr.In]
+	st.Release()
+
+	st.Frame = frame
+	st.Type = ctype
+	st.Length = length
+	st.LengthMax = len(frame.Data)
+	st.Content = frame.Data
+	st.Timestamp = tstamp
+	st.Keyframe = false
+	st.Origin = ""
+
+	sr.publishSlotIn()
+
+	return st, err
+}
+
+//----------------------------------------------------------------------------------
+// give a new frame to the slot at the input position to be written, in lock
+//----------------------------------------------------------------------------------
+func (sr *StreamRing) renewSlotIn() *StreamSlot {
+	st := &sr.Slots[sr.In]
+	st.Release()
+
+	st.Frame = sr.Pool.Get(sr.Size)
+	st.Content = st.Frame.Data
+	st.LengthMax = len(st.Content)
+	st.Length = 0
+
+	return st
+}
+
+// ---------------------------------E-----N-----D-----------------------------------
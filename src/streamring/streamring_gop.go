@@ -0,0 +1,102 @@
+//==================================================================================
+// Author : Stoney Kang, sikang99@gmail.com, 2015
+// Keyframe aware GOP cache of the ring for inter-frame codecs
+//==================================================================================
+
+package streamring
+
+import (
+	"log"
+
+	sb "stoney/httpserver/src/streambase"
+)
+
+//----------------------------------------------------------------------------------
+// get the slot of the last keyframe still kept in the ring
+//----------------------------------------------------------------------------------
+func (sr *StreamRing) GetSlotKeyframe() (*StreamSlot, error) {
+	sr.Lock()
+	defer sr.Unlock()
+
+	if !sr.hasKeyframe() {
+		return nil, sb.ErrFound
+	}
+
+	return &sr.Slots[sr.posOfSeq(sr.KeySeq)], nil
+}
+
+//----------------------------------------------------------------------------------
+// check if the last keyframe is still readable, must be called in lock
+//----------------------------------------------------------------------------------
+func (sr *StreamRing) hasKeyframe() bool {
+	return sr.KeySeq >= 0 && sr.KeySeq < sr.Seq && sr.KeySeq >= sr.oldest()
+}
+
+//----------------------------------------------------------------------------------
+// check if any reader is inside the current GOP, must be called in lock
+//----------------------------------------------------------------------------------
+func (sr *StreamRing) isGopInUse() bool {
+	if !sr.hasKeyframe() {
+		return false
+	}
+
+	for rr := range sr.readers {
+		if rr.Seq >= sr.KeySeq {
+			return true
+		}
+	}
+
+	return false
+}
+
+//----------------------------------------------------------------------------------
+// check if the oldest slot kept can be evicted, i.e. not in the current GOP
+// being read nor held by a lossless reader, must be called in lock
+//----------------------------------------------------------------------------------
+func (sr *StreamRing) canEvictTail() bool {
+	return (sr.Tail < sr.KeySeq || !sr.isGopInUse()) && !sr.isTailHeld()
+}
+
+//----------------------------------------------------------------------------------
+// check if publishing the next slot in count mode evicts the current GOP
+// from a reader still behind in it, the readers caught up do not need it,
+// must be called in lock
+//----------------------------------------------------------------------------------
+func (sr *StreamRing) isGopLapping() bool {
+	if sr.IsBytes() || !sr.hasKeyframe() {
+		return false
+	}
+
+	// the oldest readable after the next publish
+	old := sr.Seq + 2 - int64(sr.Num)
+	if sr.Tail >= old || sr.Tail < sr.KeySeq {
+		return false
+	}
+
+	for rr := range sr.readers {
+		if rr.Seq >= sr.KeySeq && rr.Seq < old {
+			return true
+		}
+	}
+
+	return false
+}
+
+//----------------------------------------------------------------------------------
+// double the number of slots to keep the GOP being read, up to NUM_MAX_SLOTS,
+// it is lost over the max, must be called in lock
+//----------------------------------------------------------------------------------
+func (sr *StreamRing) growGop() {
+	num := sr.Num * 2
+	if num > NUM_MAX_SLOTS {
+		num = NUM_MAX_SLOTS
+	}
+	if num <= sr.Num {
+		return
+	}
+
+	log.Printf("ring grows to %d slots for the GOP in use\n", num)
+	sr.resize(num)
+}
+
+// ---------------------------------E-----N-----D-----------------------------------
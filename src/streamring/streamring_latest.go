@@ -0,0 +1,90 @@
+//==================================================================================
+// Author : Stoney Kang, sikang99@gmail.com, 2015
+// Newest slot of the ring for stills, ex) snapshots without a multipart stream
+//==================================================================================
+
+package streamring
+
+import (
+	"context"
+	"time"
+
+	sb "stoney/httpserver/src/streambase"
+)
+
+//----------------------------------------------------------------------------------
+// copy the newest slot into the given one, not to be torn by the writer
+// - ErrEmpty : no slot published yet or kept
+//----------------------------------------------------------------------------------
+func (sr *StreamRing) ReadSlotLatestTo(out *StreamSlot) error {
+	sr.Lock()
+	defer sr.Unlock()
+
+	seq := sr.Seq - 1
+	if seq < 0 || seq < sr.oldest() {
+		return sb.ErrEmpty
+	}
+
+	out.copyFrom(&sr.Slots[sr.posOfSeq(seq)])
+
+	return nil
+}
+
+//----------------------------------------------------------------------------------
+// get the type and the timestamp of the newest slot without the copy,
+// ex) to search the rings by what they carry now
+// - ErrEmpty : no slot published yet or kept
+//----------------------------------------------------------------------------------
+func (sr *StreamRing) GetSlotLatestInfo() (string, int64, error) {
+	sr.Lock()
+	defer sr.Unlock()
+
+	seq := sr.Seq - 1
+	if seq < 0 || seq < sr.oldest() {
+		return "", 0, sb.ErrEmpty
+	}
+
+	slot := &sr.Slots[sr.posOfSeq(seq)]
+
+	return slot.Type, slot.Timestamp, nil
+}
+
+//----------------------------------------------------------------------------------
+// wait for the newest slot after the sequence given and copy it,
+// blocking until a newer one is published
+// - ErrTimeout : nothing newer within the timeout if it is positive
+//----------------------------------------------------------------------------------
+func (sr *StreamRing) WaitSlotLatestTo(ctx context.Context, after int64, timeout time.Duration, out *StreamSlot) error {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+
+	for {
+		// take the channel before reading not to miss the change between them
+		ch := sr.changed()
+
+		err := sr.ReadSlotLatestTo(out)
+		if err == nil && out.Seq > after {
+			return nil
+		}
+		if err != nil && err != sb.ErrEmpty {
+			return err
+		}
+
+		wait := time.Duration(0)
+		if timeout > 0 {
+			wait = time.Until(deadline)
+			if wait <= 0 {
+				return sb.ErrTimeout
+			}
+		}
+
+		err = waitChange(ctx, wait, ch)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// ---------------------------------E-----N-----D-----------------------------------
@@ -0,0 +1,146 @@
+//==================================================================================
+// Author : Stoney Kang, sikang99@gmail.com, 2015
+// Parts of multipart streams read into slots, shared by the protocols
+// - parts without Content-Length, ex) from IP cameras, are read to the boundary
+// - parts are not read over the max length of the slot
+// - boundaries given with or without the leading -- are accepted
+//==================================================================================
+
+package streamring
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime/multipart"
+	"strconv"
+	"strings"
+
+	sb "stoney/httpserver/src/streambase"
+)
+
+//----------------------------------------------------------------------------------
+// make a multipart reader with the boundary used in the stream actually,
+// some senders give "--xxx" in the header and put it as is in the stream
+//----------------------------------------------------------------------------------
+func NewPartReader(r io.Reader, boundary string) *multipart.Reader {
+	name := strings.TrimPrefix(boundary, "--")
+	if name == boundary {
+		return multipart.NewReader(r, boundary)
+	}
+
+	// see the first delimiter to know which one is used
+	br := bufio.NewReader(r)
+	peek, _ := br.Peek(len(boundary) + 4)
+	peek = bytes.TrimLeft(peek, "\r\n")
+	if bytes.HasPrefix(peek, []byte("--"+boundary)) {
+		return multipart.NewReader(br, boundary)
+	}
+
+	return multipart.NewReader(br, name)
+}
+
+//----------------------------------------------------------------------------------
+// get the length of the part by the header, -1 if not given or invalid
+//----------------------------------------------------------------------------------
+func GetPartLength(p *multipart.Part) int {
+	sl := p.Header.Get(sb.STR_HDR_CONTENT_LENGTH)
+	if sl == "" {
+		return -1
+	}
+
+	nl, err := strconv.Atoi(strings.TrimSpace(sl))
+	if err != nil || nl < 0 {
+		log.Printf("invalid %s: %q\n", sb.STR_HDR_CONTENT_LENGTH, sl)
+		return -1
+	}
+
+	return nl
+}
+
+//----------------------------------------------------------------------------------
+// read the body of the part into the buffer, by the length if given or
+// to the boundary if not (-1), and return the length read
+// - ErrSize : the part is larger than the buffer, drained to the next part
+//----------------------------------------------------------------------------------
+func ReadPartBody(p *multipart.Part, buf []byte, nl int) (int, error) {
+	var err error
+
+	if nl > len(buf) {
+		log.Printf("part of %d bytes is over %d\n", nl, len(buf))
+		io.Copy(ioutil.Discard, p)
+		return 0, sb.ErrSize
+	}
+
+	if nl >= 0 {
+		// the last read of a part may return EOF with the data
+		_, err = io.ReadFull(p, buf[:nl])
+		if err != nil {
+			log.Println(err)
+			return 0, err
+		}
+		return nl, err
+	}
+
+	n, err := io.ReadFull(p, buf)
+	switch err {
+	case io.EOF, io.ErrUnexpectedEOF:
+		return n, nil
+	case nil:
+		// full, but the part may have more
+		var one [1]byte
+		if m, _ := p.Read(one[:]); m > 0 {
+			log.Printf("part is over %d bytes\n", len(buf))
+			io.Copy(ioutil.Discard, p)
+			return 0, sb.ErrSize
+		}
+		return n, nil
+	}
+
+	log.Println(err)
+	return 0, err
+}
+
+//----------------------------------------------------------------------------------
+// read the next part into the slot, not over its max length
+//----------------------------------------------------------------------------------
+func ReadPartToSlot(mr *multipart.Reader, slot *StreamSlot) error {
+	var err error
+
+	p, err := mr.NextPart()
+	if err != nil { // io.EOF
+		log.Println(err)
+		return err
+	}
+
+	max := len(slot.Content)
+	if slot.LengthMax > 0 && slot.LengthMax < max {
+		max = slot.LengthMax
+	}
+
+	// to prevent from handling incomplete data
+	slot.Length = 0
+
+	nl, err := ReadPartBody(p, slot.Content[:max], GetPartLength(p))
+	if err != nil {
+		return err
+	}
+
+	slot.Length = nl
+	slot.Type = p.Header.Get(sb.STR_HDR_CONTENT_TYPE)
+	slot.Keyframe = p.Header.Get(sb.STR_HDR_KEYFRAME) == "1"
+	slot.Origin = p.Header.Get(sb.STR_HDR_ORIGIN)
+	// sequence of the sender to be reordered by, renumbered by the ring
+	slot.Seq, _ = strconv.ParseInt(p.Header.Get(sb.STR_HDR_SEQUENCE), 10, 64)
+	slot.Timestamp = sb.GetTimestampNow()
+	// keep the time of the source if given
+	if ts := sb.GetTimestampFromString(p.Header.Get(sb.STR_HDR_TIMESTAMP)); ts > 0 {
+		slot.Timestamp = ts
+	}
+
+	return err
+}
+
+// ---------------------------------E-----N-----D-----------------------------------
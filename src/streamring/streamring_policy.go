@@ -0,0 +1,190 @@
+//==================================================================================
+// Author : Stoney Kang, sikang99@gmail.com, 2015
+// Back-pressure policy of the ring against lossless readers
+//==================================================================================
+
+package streamring
+
+import (
+	"fmt"
+	"time"
+
+	sb "stoney/httpserver/src/streambase"
+)
+
+//----------------------------------------------------------------------------------
+const (
+	POLICY_DROP_OLDEST  = iota // overwrite the oldest slot, default
+	POLICY_BLOCK_WRITER        // wait for lossless readers until the timeout
+	POLICY_DROP_NEWEST         // drop the slot being written
+)
+
+var PolicyText = map[int]string{
+	POLICY_DROP_OLDEST:  "drop-oldest",
+	POLICY_BLOCK_WRITER: "block-writer",
+	POLICY_DROP_NEWEST:  "drop-newest",
+}
+
+//----------------------------------------------------------------------------------
+// get the policy by its name
+//----------------------------------------------------------------------------------
+func GetPolicyByName(name string) (int, error) {
+	for policy, text := range PolicyText {
+		if text == name {
+			return policy, nil
+		}
+	}
+	return POLICY_DROP_OLDEST, fmt.Errorf("unknown policy: %s", name)
+}
+
+//----------------------------------------------------------------------------------
+// set the policy applied when lossless readers are lapped, timeout is
+// the max time to block the writer, TIME_DEF_BLOCK if not positive
+//----------------------------------------------------------------------------------
+func (sr *StreamRing) SetPolicy(policy int, timeout time.Duration) error {
+	sr.Lock()
+	defer sr.Unlock()
+
+	if _, ok := PolicyText[policy]; !ok {
+		return sb.ErrValue
+	}
+
+	if timeout <= 0 {
+		timeout = sb.TIME_DEF_BLOCK
+	}
+
+	sr.Policy = policy
+	sr.Timeout = timeout
+	sr.notifyRoom()
+
+	return nil
+}
+
+func (sr *StreamRing) GetPolicy() int {
+	sr.Lock()
+	defer sr.Unlock()
+
+	return sr.Policy
+}
+
+//----------------------------------------------------------------------------------
+// register the reader as lossless, to which the policy is applied
+//----------------------------------------------------------------------------------
+func (rr *RingReader) SetLossless(lossless bool) {
+	sr := rr.Ring
+
+	sr.Lock()
+	defer sr.Unlock()
+
+	rr.Lossless = lossless
+	sr.notifyRoom()
+}
+
+//----------------------------------------------------------------------------------
+// check if publishing the next slot makes a lossless reader lose the oldest,
+// must be called in lock
+//----------------------------------------------------------------------------------
+func (sr *StreamRing) isLapping() bool {
+	if sr.Policy == POLICY_DROP_OLDEST {
+		return false
+	}
+
+	// the oldest readable after the next publish
+	old := sr.Seq + 2 - int64(sr.Num)
+	for rr := range sr.readers {
+		if rr.Lossless && rr.Seq < old && rr.Seq >= sr.oldest() {
+			return true
+		}
+	}
+
+	return false
+}
+
+//----------------------------------------------------------------------------------
+// check if the oldest slot is being read by a lossless reader,
+// must be called in lock
+//----------------------------------------------------------------------------------
+func (sr *StreamRing) isTailHeld() bool {
+	if sr.Policy == POLICY_DROP_OLDEST {
+		return false
+	}
+
+	for rr := range sr.readers {
+		if rr.Lossless && rr.Seq <= sr.Tail {
+			return true
+		}
+	}
+
+	return false
+}
+
+//----------------------------------------------------------------------------------
+// apply the policy before publishing the next slot, must be called in lock
+// - ErrFull : the slot is to be dropped by drop-newest
+// the writer overwrites the oldest after blocked for the timeout
+//----------------------------------------------------------------------------------
+func (sr *StreamRing) waitRoom() error {
+	err := sr.applyPolicy(sr.isLapping)
+	if err != nil {
+		return err
+	}
+
+	// the GOP being read in count mode is kept only by growing, the policy
+	// is not for the lossy readers
+	if sr.isGopLapping() {
+		sr.growGop()
+	}
+
+	return err
+}
+
+//----------------------------------------------------------------------------------
+// drop the slot or block the writer while lapping, must be called in lock
+//----------------------------------------------------------------------------------
+func (sr *StreamRing) applyPolicy(lapping func() bool) error {
+	if !lapping() {
+		return nil
+	}
+
+	switch sr.Policy {
+	case POLICY_DROP_NEWEST:
+		sr.stats.dropsIn++
+		return sb.ErrFull
+
+	case POLICY_BLOCK_WRITER:
+		timer := time.NewTimer(sr.Timeout)
+		defer timer.Stop()
+
+		for lapping() {
+			room := sr.room
+			if room == nil {
+				room = make(chan struct{})
+				sr.room = room
+			}
+
+			sr.Unlock()
+			select {
+			case <-room:
+				sr.Lock()
+			case <-timer.C:
+				sr.Lock()
+				sr.stats.blocks++
+				return nil
+			}
+		}
+	}
+
+	return nil
+}
+
+//----------------------------------------------------------------------------------
+// wake up the writer blocked for lossless readers, must be called in lock
+//----------------------------------------------------------------------------------
+func (sr *StreamRing) notifyRoom() {
+	if sr.room != nil {
+		close(sr.room)
+		sr.room = nil
+	}
+}
+
+// ---------------------------------E-----N-----D-----------------------------------
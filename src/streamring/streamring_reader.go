@@ -0,0 +1,300 @@
+//==================================================================================
+// Author : Stoney Kang, sikang99@gmail.com, 2015
+// Reader cursor for the ring with overrun detection
+//==================================================================================
+
+package streamring
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	sb "stoney/httpserver/src/streambase"
+)
+
+//==================================================================================
+// ring reader struc
+//----------------------------------------------------------------------------------
+type RingReader struct {
+	Ring     *StreamRing
+	Id       int64       // unique in the ring, in the order made
+	Pos      int         // position of the slot to be read next
+	Seq      int64       // sequence number of the slot to be read next
+	Last     int64       // sequence number of the slot read last
+	Reads    int64       // number of slots read
+	Drops    int64       // number of slots skipped by overrun
+	Lossless bool        // the policy of the ring is applied to
+	spill    *StreamSlot // slot read from the spill tier
+}
+
+//----------------------------------------------------------------------------------
+// make a new reader starting from the last keyframe, or the newest slot
+// of the ring if no keyframe, it should be closed after use
+//----------------------------------------------------------------------------------
+func (sr *StreamRing) NewRingReader() *RingReader {
+	rr := &RingReader{
+		Ring: sr,
+		Last: -1,
+	}
+
+	sr.Lock()
+	sr.readerId++
+	rr.Id = sr.readerId
+	rr.resync()
+	sr.readers[rr] = struct{}{}
+	sr.active = time.Now()
+	sr.Unlock()
+
+	return rr
+}
+
+//----------------------------------------------------------------------------------
+// detach the reader from the ring not to hold its GOP any more
+//----------------------------------------------------------------------------------
+func (rr *RingReader) Close() {
+	sr := rr.Ring
+
+	sr.Lock()
+	if _, ok := sr.readers[rr]; ok {
+		sr.stats.drops += rr.Drops
+		delete(sr.readers, rr)
+		sr.active = time.Now()
+		sr.notifyRoom()
+	}
+	sr.Unlock()
+}
+
+//----------------------------------------------------------------------------------
+// string information for the reader
+//----------------------------------------------------------------------------------
+func (rr *RingReader) String() string {
+	str := fmt.Sprintf("[RingReader] %s", rr.Ring.Id)
+	str += fmt.Sprintf("\tPos: %d", rr.Pos)
+	str += fmt.Sprintf("\tSeq: %d,%d", rr.Seq, rr.Last)
+	str += fmt.Sprintf("\tReads: %d", rr.Reads)
+	str += fmt.Sprintf("\tDrops: %d", rr.Drops)
+	return str
+}
+
+//----------------------------------------------------------------------------------
+// move the cursor to the last keyframe kept or the newest slot published,
+// must be called in lock
+//----------------------------------------------------------------------------------
+func (rr *RingReader) resync() {
+	sr := rr.Ring
+
+	if sr.hasKeyframe() {
+		rr.Seq = sr.KeySeq
+		rr.Pos = sr.posOfSeq(sr.KeySeq)
+	} else if sr.Seq > 0 {
+		rr.Seq = sr.Seq - 1
+		rr.Pos = (sr.In - 1 + sr.Num) % sr.Num
+	} else {
+		rr.Seq = sr.Seq
+		rr.Pos = sr.In
+	}
+}
+
+//----------------------------------------------------------------------------------
+// get the slot to be read and move to the next
+// - ErrEmpty   : no new slot published yet
+// - ErrOverrun : the writer lapped the reader, the count of slots skipped is
+//                returned and the next read starts from the last keyframe
+//                or the newest slot
+// the slot is not copied and may be overwritten while used, check it by Check
+// after use, or use ReadSlotTo to be free from the race with the writer
+//----------------------------------------------------------------------------------
+func (rr *RingReader) ReadSlot() (*StreamSlot, int, error) {
+	sr := rr.Ring
+
+	sr.Lock()
+	slot, skip, err := rr.readSlot()
+	sp := sr.Spill
+	sr.Unlock()
+
+	if err == errSpilled {
+		return rr.readSpilled(sp)
+	}
+
+	return slot, skip, err
+}
+
+func (rr *RingReader) readSlot() (*StreamSlot, int, error) {
+	sr := rr.Ring
+
+	// ring was reset or resized under the reader
+	if rr.Seq > sr.Seq || rr.Pos >= sr.Num {
+		rr.resync()
+	}
+
+	// no data to read
+	if rr.Seq == sr.Seq {
+		return nil, 0, sb.ErrEmpty
+	}
+
+	// the slot was overwritten or evicted, but may be kept in the spill tier
+	if rr.Seq < sr.oldest() {
+		if sr.Spill != nil {
+			if slot := sr.getSpilling(rr.Seq); slot != nil {
+				rr.Last = rr.Seq
+				rr.Seq++
+				rr.Pos = sr.posOfSeq(rr.Seq)
+				rr.Reads++
+				return slot, 0, nil
+			}
+			return nil, 0, errSpilled
+		}
+
+		old := rr.Seq
+		rr.resync()
+		skip := int(rr.Seq - old)
+		rr.Drops += int64(skip)
+		return nil, skip, sb.ErrOverrun
+	}
+
+	slot := &sr.Slots[rr.Pos]
+	rr.Last = rr.Seq
+	rr.Pos = (rr.Pos + 1) % sr.Num
+	rr.Seq++
+	rr.Reads++
+	if rr.Lossless {
+		sr.notifyRoom()
+	}
+
+	return slot, 0, nil
+}
+
+//----------------------------------------------------------------------------------
+// wait for the slot to be read, blocking until the ring is changed
+// - ErrEmpty   : the ring is changed without a new slot, e.g. status
+// - ErrTimeout : nothing happened within the timeout if it is positive
+//----------------------------------------------------------------------------------
+func (rr *RingReader) WaitSlot(ctx context.Context, timeout time.Duration) (*StreamSlot, int, error) {
+	// take the channel before reading not to miss the change between them
+	ch := rr.Ring.changed()
+
+	slot, skip, err := rr.ReadSlot()
+	if err != sb.ErrEmpty {
+		return slot, skip, err
+	}
+
+	err = waitChange(ctx, timeout, ch)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return rr.ReadSlot()
+}
+
+//----------------------------------------------------------------------------------
+// copy the slot to be read into the given one in lock not to be torn by the
+// writer, in shared mode the given one becomes a read-only view to be released
+//----------------------------------------------------------------------------------
+func (rr *RingReader) ReadSlotTo(out *StreamSlot) (int, error) {
+	sr := rr.Ring
+
+	sr.Lock()
+	slot, skip, err := rr.readSlot()
+	if err == nil {
+		out.copyFrom(slot)
+	}
+	sp := sr.Spill
+	sr.Unlock()
+
+	// the slot of the spill is of the reader, not of the ring
+	if err == errSpilled {
+		slot, skip, err = rr.readSpilled(sp)
+		if err == nil {
+			out.copyFrom(slot)
+		}
+	}
+
+	return skip, err
+}
+
+func (rr *RingReader) WaitSlotTo(ctx context.Context, timeout time.Duration, out *StreamSlot) (int, error) {
+	ch := rr.Ring.changed()
+
+	skip, err := rr.ReadSlotTo(out)
+	if err != sb.ErrEmpty {
+		return skip, err
+	}
+
+	err = waitChange(ctx, timeout, ch)
+	if err != nil {
+		return 0, err
+	}
+
+	return rr.ReadSlotTo(out)
+}
+
+//----------------------------------------------------------------------------------
+// copy the slot of the ring, or refer to its frame in shared mode,
+// must be called in lock of the ring
+//----------------------------------------------------------------------------------
+func (out *StreamSlot) copyFrom(slot *StreamSlot) {
+	// no copy, just refer to the frame
+	if slot.Frame != nil {
+		out.shareFrom(slot)
+		return
+	}
+
+	out.Release()
+	if out.Content == nil && out.pooled != nil {
+		out.Content = out.pooled
+		out.LengthMax = len(out.pooled)
+	}
+	if len(out.Content) < slot.Length {
+		out.Content = make([]byte, slot.Length)
+		out.LengthMax = slot.Length
+	}
+
+	out.Type = slot.Type
+	out.Length = slot.Length
+	out.Timestamp = slot.Timestamp
+	out.Seq = slot.Seq
+	out.Keyframe = slot.Keyframe
+	out.Origin = slot.Origin
+	copy(out.Content, slot.Content[:slot.Length])
+}
+
+//----------------------------------------------------------------------------------
+// block until the channel is closed, ctx is done or the timeout expires
+//----------------------------------------------------------------------------------
+func waitChange(ctx context.Context, timeout time.Duration, ch <-chan struct{}) error {
+	var expire <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expire = timer.C
+	}
+
+	select {
+	case <-ch:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-expire:
+		return sb.ErrTimeout
+	}
+
+	return nil
+}
+
+//----------------------------------------------------------------------------------
+// check the slot read last is not overwritten yet, use after handling the slot
+//----------------------------------------------------------------------------------
+func (rr *RingReader) Check() error {
+	sr := rr.Ring
+
+	sr.Lock()
+	defer sr.Unlock()
+
+	if rr.Last < 0 || rr.Last < sr.oldest() {
+		return sb.ErrOverrun
+	}
+
+	return nil
+}
+
+// ---------------------------------E-----N-----D-----------------------------------
@@ -0,0 +1,397 @@
+//==================================================================================
+// Author : Stoney Kang, sikang99@gmail.com, 2015
+// Registry of rings by name or channel/source/track path,
+// created on the first publish and removed when idle for a while
+//==================================================================================
+
+package streamring
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	sb "stoney/httpserver/src/streambase"
+)
+
+//----------------------------------------------------------------------------------
+const (
+	TIME_DEF_IDLE = time.Minute // time to remove the ring not used
+
+	NUM_MAX_NAME_PARTS = 3 // channel/source/track
+
+	STR_STREAM_PREFIX   = "/stream/"   // url path prefix of the named ring
+	STR_SNAPSHOT_PREFIX = "/snapshot/" // url path prefix of the still of the ring
+)
+
+// mode of the ring created by the registry
+const (
+	RING_MODE_COPY   = iota // slots of their own buffers, copied to readers
+	RING_MODE_SHARED        // slots referring to the frames of the pool, zero-copy
+	RING_MODE_BYTES         // slots sized to their content in the budget of num x size
+)
+
+var RingModeText = map[int]string{
+	RING_MODE_COPY:   "copy",
+	RING_MODE_SHARED: "shared",
+	RING_MODE_BYTES:  "bytes",
+}
+
+//----------------------------------------------------------------------------------
+// get the mode of the ring by its name
+//----------------------------------------------------------------------------------
+func GetRingModeByName(name string) (int, error) {
+	for mode, text := range RingModeText {
+		if text == name {
+			return mode, nil
+		}
+	}
+	return RING_MODE_COPY, fmt.Errorf("unknown mode: %s", name)
+}
+
+//----------------------------------------------------------------------------------
+// get the mode of the ring
+//----------------------------------------------------------------------------------
+func (sr *StreamRing) GetMode() int {
+	if sr.IsShared() {
+		return RING_MODE_SHARED
+	}
+	if sr.IsBytes() {
+		return RING_MODE_BYTES
+	}
+	return RING_MODE_COPY
+}
+
+//==================================================================================
+// ring registry struc
+//----------------------------------------------------------------------------------
+type RingRegistry struct {
+	sync.Mutex
+	Num       int           // number of slots of the ring created
+	Size      int           // size of the slot of the ring created
+	Idle      time.Duration // time to remove the ring not used, 0 for never
+	Retention time.Duration // time window of slots kept in the ring created, 0 for no limit
+	Mode      int           // mode of the ring created, RING_MODE_COPY by default
+	Rings     map[string]*StreamRing
+	pinned    map[string]bool // rings added by hand, never removed when idle
+}
+
+//----------------------------------------------------------------------------------
+// make a new registry creating rings of num slots in size
+//----------------------------------------------------------------------------------
+func NewRingRegistry(num int, size int, idle time.Duration) *RingRegistry {
+	if num < 1 {
+		num = NUM_DEF_SLOTS
+	}
+	if size < 1 {
+		size = LEN_DEF_SLOT
+	}
+
+	return &RingRegistry{
+		Num:    num,
+		Size:   size,
+		Idle:   idle,
+		Rings:  make(map[string]*StreamRing),
+		pinned: make(map[string]bool),
+	}
+}
+
+//----------------------------------------------------------------------------------
+// string information for the registry
+//----------------------------------------------------------------------------------
+func (rg *RingRegistry) String() string {
+	names := rg.Names()
+
+	str := fmt.Sprintf("[RingRegistry] Rings: %d", len(names))
+	str += fmt.Sprintf("\tSize: %d, %d KB", rg.Num, rg.Size/sb.KBYTE)
+	str += fmt.Sprintf("\tMode: %s", RingModeText[rg.Mode])
+	str += fmt.Sprintf("\tIdle: %v", rg.Idle)
+	str += fmt.Sprintf("\tRetention: %v\n", rg.Retention)
+	for _, name := range names {
+		ring, err := rg.Get(name)
+		if err != nil {
+			continue
+		}
+		str += fmt.Sprintf("\t[%s] %s\n", name, ring.BaseString())
+	}
+	return str
+}
+
+//----------------------------------------------------------------------------------
+// get the path name of the track ring, ex) 1/11/111
+//----------------------------------------------------------------------------------
+func GetRingPath(channel string, source string, track string) string {
+	return channel + "/" + source + "/" + track
+}
+
+//----------------------------------------------------------------------------------
+// get the ring name following the prefix of the url path, ex) /stream/lobby-cam
+//----------------------------------------------------------------------------------
+func GetRingNameFromPath(path string, prefix string) (string, error) {
+	if !strings.HasPrefix(path, prefix) {
+		return "", sb.ErrFound
+	}
+
+	name := strings.Trim(path[len(prefix):], "/")
+	err := CheckRingName(name)
+	if err != nil {
+		return "", err
+	}
+
+	return name, nil
+}
+
+//----------------------------------------------------------------------------------
+// check the name made of up to 3 parts of letters, digits, '-', '_' or '.'
+//----------------------------------------------------------------------------------
+func CheckRingName(name string) error {
+	parts := strings.Split(name, "/")
+	if len(parts) > NUM_MAX_NAME_PARTS {
+		return sb.ErrValue
+	}
+
+	for _, part := range parts {
+		if part == "" || part == "." || part == ".." {
+			return sb.ErrValue
+		}
+		for _, c := range part {
+			switch {
+			case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+			case c == '-', c == '_', c == '.':
+			default:
+				return sb.ErrValue
+			}
+		}
+	}
+
+	return nil
+}
+
+//----------------------------------------------------------------------------------
+// add the ring made outside under the name, it is kept until removed
+//----------------------------------------------------------------------------------
+func (rg *RingRegistry) Add(name string, ring *StreamRing) error {
+	err := CheckRingName(name)
+	if err != nil {
+		return err
+	}
+
+	rg.Lock()
+	defer rg.Unlock()
+
+	rg.Rings[name] = ring
+	rg.pinned[name] = true
+
+	return nil
+}
+
+//----------------------------------------------------------------------------------
+// get the ring of the name
+//----------------------------------------------------------------------------------
+func (rg *RingRegistry) Get(name string) (*StreamRing, error) {
+	rg.Lock()
+	defer rg.Unlock()
+
+	ring, ok := rg.Rings[name]
+	if !ok {
+		return nil, sb.ErrFound
+	}
+
+	return ring, nil
+}
+
+//----------------------------------------------------------------------------------
+// get the ring of the name, or create a new one if not found at the first
+// publish, created is true for the new one
+//----------------------------------------------------------------------------------
+func (rg *RingRegistry) GetOrCreate(name string) (*StreamRing, bool, error) {
+	return rg.GetOrCreateWithSize(name, 0, 0)
+}
+
+// num and size of the ring created, the defaults of registry if not positive
+func (rg *RingRegistry) GetOrCreateWithSize(name string, num int, size int) (*StreamRing, bool, error) {
+	return rg.GetOrCreateWithMode(name, -1, num, size)
+}
+
+// mode of the ring created, the default of registry if negative
+func (rg *RingRegistry) GetOrCreateWithMode(name string, mode int, num int, size int) (ring *StreamRing, created bool, err error) {
+	err = CheckRingName(name)
+	if err != nil {
+		return nil, false, err
+	}
+
+	rg.Lock()
+	defer rg.Unlock()
+
+	ring, ok := rg.Rings[name]
+	if ok {
+		return ring, false, nil
+	}
+
+	if num < 1 {
+		num = rg.Num
+	}
+	if size < 1 {
+		size = rg.Size
+	}
+	if num > NUM_MAX_SLOTS || size > LEN_MAX_SLOT {
+		return nil, false, sb.ErrSize
+	}
+	if mode < 0 {
+		mode = rg.Mode
+	}
+
+	switch mode {
+	case RING_MODE_SHARED:
+		ring = NewStreamRingShared(num, size, "ring of "+name)
+	case RING_MODE_BYTES:
+		ring = NewStreamRingWithBytes(int64(num)*int64(size), size, "ring of "+name)
+	default:
+		ring = NewStreamRingWithParams(num, size, "ring of "+name)
+	}
+	ring.Id = name
+	ring.Retention = rg.Retention
+	rg.Rings[name] = ring
+	log.Printf("ring %s is created\n", name)
+
+	return ring, true, nil
+}
+
+//----------------------------------------------------------------------------------
+// check the ring of the name is added by hand, not to be removed by users
+//----------------------------------------------------------------------------------
+func (rg *RingRegistry) IsPinned(name string) bool {
+	rg.Lock()
+	defer rg.Unlock()
+
+	return rg.pinned[name]
+}
+
+//----------------------------------------------------------------------------------
+// remove the ring of the name, the caster publishing to it is stopped
+//----------------------------------------------------------------------------------
+func (rg *RingRegistry) Remove(name string) error {
+	rg.Lock()
+	ring, ok := rg.Rings[name]
+	if ok {
+		delete(rg.Rings, name)
+		delete(rg.pinned, name)
+	}
+	rg.Unlock()
+
+	if !ok {
+		return sb.ErrFound
+	}
+
+	ring.SetStatus(sb.STATUS_IDLE)
+	log.Printf("ring %s is removed\n", name)
+
+	return nil
+}
+
+//----------------------------------------------------------------------------------
+// look up the ring named in the request path for the method, the caster
+// creates it by POST and the player gets it by GET, the default ring is given
+// for the path without a name or the nil registry
+//----------------------------------------------------------------------------------
+func (rg *RingRegistry) LookupRing(method string, path string, def *StreamRing) (*StreamRing, error) {
+	if rg == nil || !strings.HasPrefix(path, STR_STREAM_PREFIX) || path == STR_STREAM_PREFIX {
+		return def, nil
+	}
+
+	name, err := GetRingNameFromPath(path, STR_STREAM_PREFIX)
+	if err != nil {
+		return nil, err
+	}
+
+	if method == "POST" {
+		ring, _, err := rg.GetOrCreate(name)
+		return ring, err
+	}
+
+	return rg.Get(name)
+}
+
+//----------------------------------------------------------------------------------
+// get the names of rings in order
+//----------------------------------------------------------------------------------
+func (rg *RingRegistry) Names() []string {
+	rg.Lock()
+	defer rg.Unlock()
+
+	var names []string
+	for name := range rg.Rings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+//----------------------------------------------------------------------------------
+// remove the rings created and not used for the idle time, and return
+// their names
+//----------------------------------------------------------------------------------
+func (rg *RingRegistry) CollectIdle() []string {
+	rg.Lock()
+	defer rg.Unlock()
+
+	var names []string
+	if rg.Idle <= 0 {
+		return names
+	}
+
+	for name, ring := range rg.Rings {
+		if rg.pinned[name] || !ring.isIdleFor(rg.Idle) {
+			continue
+		}
+		delete(rg.Rings, name)
+		names = append(names, name)
+		log.Printf("ring %s is collected after idle %v\n", name, rg.Idle)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+//----------------------------------------------------------------------------------
+// collect the idle rings periodically until the context is done
+//----------------------------------------------------------------------------------
+func (rg *RingRegistry) CollectLoop(ctx context.Context) {
+	if rg.Idle <= 0 {
+		return
+	}
+
+	period := rg.Idle / 4
+	if period < time.Second {
+		period = time.Second
+	}
+
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			rg.CollectIdle()
+		}
+	}
+}
+
+//----------------------------------------------------------------------------------
+// check if no one publishes or reads the ring for the duration
+//----------------------------------------------------------------------------------
+func (sr *StreamRing) isIdleFor(d time.Duration) bool {
+	sr.Lock()
+	defer sr.Unlock()
+
+	return sr.Status == sb.STATUS_IDLE && len(sr.readers) == 0 && time.Since(sr.active) >= d
+}
+
+// ---------------------------------E-----N-----D-----------------------------------
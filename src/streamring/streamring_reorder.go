@@ -0,0 +1,354 @@
+//==================================================================================
+// Author : Stoney Kang, sikang99@gmail.com, 2015
+// Reorder stage in front of the ring for frames arriving out of order,
+// ex) from UDP or several relays
+// - frames are held for the latency window and published in order
+// - duplicates and frames older than the ones published are discarded,
+//   by the sequence of the sender, or the timestamp with the length and content
+//==================================================================================
+
+package streamring
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"hash/crc32"
+	"sync"
+	"time"
+
+	sb "stoney/httpserver/src/streambase"
+)
+
+//----------------------------------------------------------------------------------
+const (
+	ORDER_TIMESTAMP = iota // by Timestamp of the slot
+	ORDER_SEQUENCE         // by Seq of the slot given by the sender
+)
+
+var OrderText = map[int]string{
+	ORDER_TIMESTAMP: "timestamp",
+	ORDER_SEQUENCE:  "sequence",
+}
+
+const (
+	TIME_DEF_REORDER = 100 * time.Millisecond // latency window to hold frames
+)
+
+//----------------------------------------------------------------------------------
+// get the order by its name
+//----------------------------------------------------------------------------------
+func GetOrderByName(name string) (int, error) {
+	for order, text := range OrderText {
+		if text == name {
+			return order, nil
+		}
+	}
+	return ORDER_TIMESTAMP, fmt.Errorf("unknown order: %s", name)
+}
+
+//==================================================================================
+// frames held in the min-heap by the order key
+//----------------------------------------------------------------------------------
+type heldSlot struct {
+	key     int64
+	id      frameId
+	n       int64 // order of arrival for the same key
+	arrival time.Time
+	slot    *StreamSlot
+	done    bool // popped, left in the arrivals until it comes first
+}
+
+// identity of the frame to find duplicates, the key only by sequence
+type frameId struct {
+	key    int64
+	length int
+	sum    uint32
+}
+
+type heldHeap []*heldSlot
+
+func (h heldHeap) Len() int { return len(h) }
+func (h heldHeap) Less(i, j int) bool {
+	if h[i].key == h[j].key {
+		return h[i].n < h[j].n
+	}
+	return h[i].key < h[j].key
+}
+func (h heldHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *heldHeap) Push(x interface{}) { *h = append(*h, x.(*heldSlot)) }
+func (h *heldHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
+
+//==================================================================================
+// ring reorder struc
+//----------------------------------------------------------------------------------
+type RingReorder struct {
+	sync.Mutex
+	Ring     *StreamRing
+	Order    int
+	Latency  time.Duration // time to hold a frame for the ones before it
+	Max      int           // frames held at most, the oldest is published if over
+	pub      sync.Mutex    // orders the frames published, taken before the lock
+	held     heldHeap
+	arrivals []*heldSlot      // frames held in the order of arrival, the earliest first
+	newest   int64            // key of the newest frame held
+	ids      map[frameId]bool // frames held
+	lastIds  map[frameId]bool // frames published with the last key
+	last     int64            // key of the frame published last
+	started  bool             // any frame published
+	arrived  int64            // count of the frames held
+}
+
+//----------------------------------------------------------------------------------
+// make a new reorder stage publishing to the ring
+//----------------------------------------------------------------------------------
+func NewRingReorder(ring *StreamRing, order int, latency time.Duration) *RingReorder {
+	if latency <= 0 {
+		latency = TIME_DEF_REORDER
+	}
+
+	return &RingReorder{
+		Ring:    ring,
+		Order:   order,
+		Latency: latency,
+		Max:     ring.Len(),
+		ids:     make(map[frameId]bool),
+		lastIds: make(map[frameId]bool),
+	}
+}
+
+//----------------------------------------------------------------------------------
+// string information for the reorder
+//----------------------------------------------------------------------------------
+func (ro *RingReorder) String() string {
+	ro.Lock()
+	defer ro.Unlock()
+
+	str := fmt.Sprintf("[RingReorder] %s", OrderText[ro.Order])
+	str += fmt.Sprintf("\tLatency: %v", ro.Latency)
+	str += fmt.Sprintf("\tHeld: %d/%d", len(ro.held), ro.Max)
+	str += fmt.Sprintf("\tLast: %d", ro.last)
+	return str
+}
+
+//----------------------------------------------------------------------------------
+// key of the slot by the order
+//----------------------------------------------------------------------------------
+func (ro *RingReorder) keyOf(slot *StreamSlot) int64 {
+	if ro.Order == ORDER_SEQUENCE {
+		return slot.Seq
+	}
+	return slot.Timestamp
+}
+
+//----------------------------------------------------------------------------------
+// identity of the slot, frames of the same timestamp differ by the content
+//----------------------------------------------------------------------------------
+func (ro *RingReorder) idOf(slot *StreamSlot) frameId {
+	id := frameId{key: ro.keyOf(slot)}
+	if ro.Order == ORDER_TIMESTAMP {
+		id.length = slot.Length
+		id.sum = crc32.ChecksumIEEE(slot.Content[:slot.Length])
+	}
+	return id
+}
+
+//----------------------------------------------------------------------------------
+// put the slot to be published in order, the slot is copied and can be reused
+// - ErrLate : the slot is older than the ones published, discarded
+// - ErrDup  : the slot is a duplicate, discarded
+//----------------------------------------------------------------------------------
+func (ro *RingReorder) PutSlot(slot *StreamSlot) error {
+	return ro.putSlot(slot, time.Now())
+}
+
+func (ro *RingReorder) putSlot(slot *StreamSlot, now time.Time) error {
+	var err error
+
+	id := ro.idOf(slot)
+	key := id.key
+
+	ro.Lock()
+	switch {
+	case ro.ids[id], ro.started && key == ro.last && ro.lastIds[id]:
+		err = sb.ErrDup
+	case ro.started && key < ro.last:
+		err = sb.ErrLate
+	}
+	if err != nil {
+		ro.Unlock()
+		ro.Ring.countReorder(0, 1)
+		return err
+	}
+
+	// arrived after a newer one
+	late := 0
+	if len(ro.held) > 0 && key < ro.newest {
+		late = 1
+	}
+
+	ss := NewStreamSlotFromPool(slot.Length)
+	ss.Type = slot.Type
+	ss.Length = slot.Length
+	ss.Timestamp = slot.Timestamp
+	ss.Seq = slot.Seq
+	ss.Keyframe = slot.Keyframe
+	ss.Origin = slot.Origin
+	copy(ss.Content, slot.Content[:slot.Length])
+
+	ro.arrived++
+	hs := &heldSlot{key: key, id: id, n: ro.arrived, arrival: now, slot: ss}
+	heap.Push(&ro.held, hs)
+	ro.arrivals = append(ro.arrivals, hs)
+	if len(ro.held) == 1 || key > ro.newest {
+		ro.newest = key
+	}
+	ro.ids[id] = true
+	due := ro.isDue(now)
+	ro.Unlock()
+
+	ro.Ring.countReorder(late, 0)
+	if !due {
+		return nil
+	}
+	return ro.publishDue(now)
+}
+
+//----------------------------------------------------------------------------------
+// pop the frames to be published in order, until the earliest arrival is
+// in the window and not over the max, must be called in lock
+//----------------------------------------------------------------------------------
+func (ro *RingReorder) popDue(now time.Time) []*StreamSlot {
+	var out []*StreamSlot
+
+	for ro.isDue(now) {
+		out = append(out, ro.pop())
+	}
+
+	return out
+}
+
+//----------------------------------------------------------------------------------
+// check the first frame is to be published, must be called in lock
+//----------------------------------------------------------------------------------
+func (ro *RingReorder) isDue(now time.Time) bool {
+	if len(ro.held) == 0 {
+		return false
+	}
+
+	// the ones popped before the earliest held are dropped
+	for ro.arrivals[0].done {
+		ro.arrivals[0] = nil
+		ro.arrivals = ro.arrivals[1:]
+	}
+	earliest := ro.arrivals[0].arrival
+
+	return now.Sub(earliest) >= ro.Latency || (ro.Max > 0 && len(ro.held) > ro.Max)
+}
+
+//----------------------------------------------------------------------------------
+// pop the first frame in order, must be called in lock
+//----------------------------------------------------------------------------------
+func (ro *RingReorder) pop() *StreamSlot {
+	hs := heap.Pop(&ro.held).(*heldSlot)
+	hs.done = true
+	if len(ro.held) == 0 {
+		ro.arrivals = nil
+	}
+	delete(ro.ids, hs.id)
+	if !ro.started || hs.key != ro.last {
+		ro.lastIds = make(map[frameId]bool)
+	}
+	ro.lastIds[hs.id] = true
+	ro.last = hs.key
+	ro.started = true
+	return hs.slot
+}
+
+//----------------------------------------------------------------------------------
+// publish the frames popped in order out of the lock, not to block the others
+// putting frames while the ring blocks the writer by its policy
+//----------------------------------------------------------------------------------
+func (ro *RingReorder) publishDue(now time.Time) error {
+	return ro.publishPopped(func() []*StreamSlot { return ro.popDue(now) })
+}
+
+func (ro *RingReorder) publishPopped(popped func() []*StreamSlot) error {
+	var err error
+
+	// popped and published under pub not to be mixed with the others
+	ro.pub.Lock()
+	defer ro.pub.Unlock()
+
+	ro.Lock()
+	out := popped()
+	ro.Unlock()
+
+	for _, ss := range out {
+		_, err = ro.Ring.PutSlotInNext(ss)
+		ss.Free()
+	}
+
+	return err
+}
+
+//----------------------------------------------------------------------------------
+// publish the frames due now, called periodically by Run
+//----------------------------------------------------------------------------------
+func (ro *RingReorder) Tick() error {
+	return ro.publishDue(time.Now())
+}
+
+//----------------------------------------------------------------------------------
+// publish all the frames held in order
+//----------------------------------------------------------------------------------
+func (ro *RingReorder) Flush() error {
+	return ro.publishPopped(func() []*StreamSlot {
+		var out []*StreamSlot
+		for len(ro.held) > 0 {
+			out = append(out, ro.pop())
+		}
+		return out
+	})
+}
+
+//----------------------------------------------------------------------------------
+// publish the frames held for the window until ctx is done, then flush them
+//----------------------------------------------------------------------------------
+func (ro *RingReorder) Run(ctx context.Context) error {
+	period := ro.Latency / 4
+	if period < time.Millisecond {
+		period = time.Millisecond
+	}
+
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ro.Flush()
+		case <-ticker.C:
+			ro.Tick()
+		}
+	}
+}
+
+//----------------------------------------------------------------------------------
+// count the frames reordered or discarded by the reorder stage
+//----------------------------------------------------------------------------------
+func (sr *StreamRing) countReorder(late int, drops int) {
+	sr.Lock()
+	defer sr.Unlock()
+
+	sr.stats.late += int64(late)
+	sr.stats.dropsOrd += int64(drops)
+}
+
+// ---------------------------------E-----N-----D-----------------------------------
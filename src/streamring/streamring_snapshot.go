@@ -0,0 +1,344 @@
+//==================================================================================
+// Author : Stoney Kang, sikang99@gmail.com, 2015
+// Snapshot of the ring saved at shutdown and restored at start for warm restart
+// - magic(4) version(2) header strings slots... crc32(4), in big endian
+//==================================================================================
+
+package streamring
+
+import (
+	"bufio"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+
+	sb "stoney/httpserver/src/streambase"
+)
+
+//----------------------------------------------------------------------------------
+const (
+	STR_SNAP_MAGIC   = "HMRS" // Happy Media Ring Snapshot
+	NUM_SNAP_VERSION = 1
+)
+
+//----------------------------------------------------------------------------------
+// fixed parts of the snapshot
+//----------------------------------------------------------------------------------
+type snapHeader struct {
+	Num    int32
+	Size   int32
+	In     int32
+	Out    int32
+	Seq    int64
+	KeySeq int64
+	Count  int32 // number of slots saved
+}
+
+type snapSlot struct {
+	Seq       int64
+	Timestamp int64
+	Length    int32
+	Flags     int32 // 1 for keyframe
+}
+
+//----------------------------------------------------------------------------------
+// snapshot of the ring read into memory before restored
+//----------------------------------------------------------------------------------
+type ringSnapshot struct {
+	snapHeader
+	Id       string
+	Boundary string
+	Desc     string
+	Slots    []*StreamSlot
+}
+
+//----------------------------------------------------------------------------------
+// write the slots readable and metadata of the ring
+//----------------------------------------------------------------------------------
+func (sr *StreamRing) WriteSnapshot(w io.Writer) error {
+	var err error
+
+	snap := sr.takeSnapshot()
+
+	crc := crc32.NewIEEE()
+	bw := bufio.NewWriter(io.MultiWriter(w, crc))
+
+	bw.WriteString(STR_SNAP_MAGIC)
+	binary.Write(bw, binary.BigEndian, uint16(NUM_SNAP_VERSION))
+	binary.Write(bw, binary.BigEndian, &snap.snapHeader)
+	writeSnapString(bw, snap.Id)
+	writeSnapString(bw, snap.Boundary)
+	writeSnapString(bw, snap.Desc)
+
+	for _, ss := range snap.Slots {
+		rec := snapSlot{
+			Seq:       ss.Seq,
+			Timestamp: ss.Timestamp,
+			Length:    int32(ss.Length),
+		}
+		if ss.Keyframe {
+			rec.Flags = 1
+		}
+		binary.Write(bw, binary.BigEndian, &rec)
+		writeSnapString(bw, ss.Type)
+		writeSnapString(bw, ss.Origin)
+		bw.Write(ss.Content[:ss.Length])
+	}
+
+	err = bw.Flush()
+	if err != nil {
+		return err
+	}
+
+	return binary.Write(w, binary.BigEndian, crc.Sum32())
+}
+
+//----------------------------------------------------------------------------------
+// copy the slots readable and metadata in lock
+//----------------------------------------------------------------------------------
+func (sr *StreamRing) takeSnapshot() *ringSnapshot {
+	sr.Lock()
+	defer sr.Unlock()
+
+	snap := &ringSnapshot{
+		Id:       sr.Id,
+		Boundary: sr.Boundary,
+		Desc:     sr.Desc,
+	}
+	snap.Num = int32(sr.Num)
+	snap.Size = int32(sr.Size)
+	snap.In = int32(sr.In)
+	snap.Out = int32(sr.Out)
+	snap.Seq = sr.Seq
+	snap.KeySeq = sr.KeySeq
+
+	for seq := sr.oldest(); seq < sr.Seq; seq++ {
+		st := &sr.Slots[sr.posOfSeq(seq)]
+		ss := NewStreamSlotBySize(st.Length)
+		ss.Type = st.Type
+		ss.Length = st.Length
+		ss.Timestamp = st.Timestamp
+		ss.Seq = st.Seq
+		ss.Keyframe = st.Keyframe
+		ss.Origin = st.Origin
+		copy(ss.Content, st.Content[:st.Length])
+		snap.Slots = append(snap.Slots, ss)
+	}
+	snap.Count = int32(len(snap.Slots))
+
+	return snap
+}
+
+//----------------------------------------------------------------------------------
+// read the snapshot and restore the ring by it, the ring is not changed
+// if the snapshot is invalid, the slots restored are readable while idle
+// - ErrParse   : not a snapshot
+// - ErrSupport : version not supported
+// - ErrCheck   : checksum mismatched, broken
+//----------------------------------------------------------------------------------
+func (sr *StreamRing) ReadSnapshot(r io.Reader) error {
+	snap, err := readSnapshot(r, sr.snapLimit(r))
+	if err != nil {
+		return err
+	}
+
+	sr.Lock()
+	defer sr.Unlock()
+
+	sr.reset()
+	sr.Boundary = snap.Boundary
+
+	// to be at the same position after restored in the ring of the same size
+	n := len(snap.Slots)
+	sr.In = ((int(snap.In)-n)%sr.Num + sr.Num) % sr.Num
+	sr.Seq = snap.Seq - int64(n)
+	sr.Tail = sr.Seq
+
+	for _, ss := range snap.Slots {
+		if !sr.IsBytes() && ss.Length > sr.Size {
+			log.Printf("slot %d of %d bytes is too big for the ring\n", ss.Seq, ss.Length)
+			ss.Length = 0
+		}
+		sr.putSlotIn(ss)
+	}
+	sr.Out = int(snap.Out) % sr.Num
+	sr.stats = ringStats{}
+	sr.restored = n > 0
+	sr.notifyAll()
+
+	return nil
+}
+
+//----------------------------------------------------------------------------------
+// bytes the slots of the snapshot may take, what is left to read if known,
+// else the budget of the ring, not to allocate by the lengths unchecked
+//----------------------------------------------------------------------------------
+func (sr *StreamRing) snapLimit(r io.Reader) int64 {
+	switch rd := r.(type) {
+	case interface{ Len() int }:
+		return int64(rd.Len())
+	case *os.File:
+		fi, err := rd.Stat()
+		if err == nil && fi.Mode().IsRegular() {
+			pos, err := rd.Seek(0, io.SeekCurrent)
+			if err == nil {
+				return fi.Size() - pos
+			}
+		}
+	}
+
+	sr.Lock()
+	defer sr.Unlock()
+
+	if sr.IsBytes() {
+		return sr.MaxBytes
+	}
+	return int64(sr.NumMax) * int64(sr.Size)
+}
+
+//----------------------------------------------------------------------------------
+// read and check the whole snapshot into memory, the slots up to limit bytes
+//----------------------------------------------------------------------------------
+func readSnapshot(r io.Reader, limit int64) (*ringSnapshot, error) {
+	var err error
+
+	crc := crc32.NewIEEE()
+	br := io.TeeReader(bufio.NewReader(r), crc)
+
+	magic := make([]byte, len(STR_SNAP_MAGIC))
+	_, err = io.ReadFull(br, magic)
+	if err != nil || string(magic) != STR_SNAP_MAGIC {
+		return nil, sb.ErrParse
+	}
+
+	var version uint16
+	err = binary.Read(br, binary.BigEndian, &version)
+	if err != nil {
+		return nil, sb.ErrParse
+	}
+	if version != NUM_SNAP_VERSION {
+		return nil, sb.ErrSupport
+	}
+
+	snap := &ringSnapshot{}
+	err = binary.Read(br, binary.BigEndian, &snap.snapHeader)
+	if err != nil || snap.Count < 0 || snap.Count > NUM_MAX_SLOTS {
+		return nil, sb.ErrParse
+	}
+
+	for _, str := range []*string{&snap.Id, &snap.Boundary, &snap.Desc} {
+		*str, err = readSnapString(br)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for i := 0; i < int(snap.Count); i++ {
+		var rec snapSlot
+		err = binary.Read(br, binary.BigEndian, &rec)
+		if err != nil || rec.Length < 0 || rec.Length > LEN_MAX_SLOT || int64(rec.Length) > limit {
+			return nil, sb.ErrParse
+		}
+		limit -= int64(rec.Length)
+
+		ss := NewStreamSlotBySize(int(rec.Length))
+		ss.Seq = rec.Seq
+		ss.Timestamp = rec.Timestamp
+		ss.Length = int(rec.Length)
+		ss.Keyframe = rec.Flags&1 != 0
+		ss.Type, err = readSnapString(br)
+		if err != nil {
+			return nil, err
+		}
+		ss.Origin, err = readSnapString(br)
+		if err != nil {
+			return nil, err
+		}
+		_, err = io.ReadFull(br, ss.Content)
+		if err != nil {
+			return nil, sb.ErrParse
+		}
+		snap.Slots = append(snap.Slots, ss)
+	}
+
+	// the checksum is not a part of itself
+	sum := crc.Sum32()
+	var check uint32
+	err = binary.Read(br, binary.BigEndian, &check)
+	if err != nil || check != sum {
+		return nil, sb.ErrCheck
+	}
+
+	return snap, nil
+}
+
+//----------------------------------------------------------------------------------
+// string in length(2) and bytes
+//----------------------------------------------------------------------------------
+func writeSnapString(w io.Writer, str string) {
+	if len(str) > 0xffff {
+		str = str[:0xffff]
+	}
+	binary.Write(w, binary.BigEndian, uint16(len(str)))
+	io.WriteString(w, str)
+}
+
+func readSnapString(r io.Reader) (string, error) {
+	var n uint16
+	err := binary.Read(r, binary.BigEndian, &n)
+	if err != nil {
+		return "", sb.ErrParse
+	}
+
+	buf := make([]byte, n)
+	_, err = io.ReadFull(r, buf)
+	if err != nil {
+		return "", sb.ErrParse
+	}
+
+	return string(buf), nil
+}
+
+//----------------------------------------------------------------------------------
+// save the snapshot to the file, replaced at once not to be broken
+//----------------------------------------------------------------------------------
+func (sr *StreamRing) SaveSnapshot(path string) error {
+	var err error
+
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	err = sr.WriteSnapshot(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	f.Close()
+	if err != nil {
+		log.Println(err)
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+//----------------------------------------------------------------------------------
+// load the snapshot from the file
+//----------------------------------------------------------------------------------
+func (sr *StreamRing) LoadSnapshot(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return sr.ReadSnapshot(f)
+}
+
+// ---------------------------------E-----N-----D-----------------------------------
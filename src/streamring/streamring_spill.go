@@ -0,0 +1,199 @@
+//==================================================================================
+// Author : Stoney Kang, sikang99@gmail.com, 2015
+// Spill tier of the ring keeping the slots evicted, e.g. on disk
+// - the slots evicted are queued and spilled by a worker, never in the lock
+//==================================================================================
+
+package streamring
+
+import (
+	"errors"
+	"log"
+	"sort"
+
+	sb "stoney/httpserver/src/streambase"
+)
+
+//----------------------------------------------------------------------------------
+const (
+	NUM_MAX_SPILL_QUEUE = 256 // slots waiting for the spill worker, dropped over it
+)
+
+// the slot of the reader is to be read from the spill tier out of the lock
+var errSpilled = errors.New("spilled")
+
+//==================================================================================
+// slot spiller interface, called out of the lock of the ring
+//----------------------------------------------------------------------------------
+type SlotSpiller interface {
+	// keep the slot evicted from the ring, given in the order of sequence
+	SpillSlot(ss *StreamSlot) error
+	// read the slot kept into the given one, ErrFound if not kept
+	ReadSpilled(seq int64, ss *StreamSlot) error
+	// sequence number of the slot at or just before the time, ErrFound if none
+	SeqByTime(ts int64) (int64, error)
+}
+
+//----------------------------------------------------------------------------------
+// set the spill tier of the ring, nil to stop spilling, the slots not spilled
+// yet to the previous one are dropped
+//----------------------------------------------------------------------------------
+func (sr *StreamRing) SetSpill(sp SlotSpiller) {
+	sr.Lock()
+	defer sr.Unlock()
+
+	if sr.spillStop != nil {
+		close(sr.spillStop)
+		sr.spillStop = nil
+	}
+	sr.dropSpilling()
+
+	sr.Spill = sp
+	if sp != nil {
+		sr.spillStop = make(chan struct{})
+		sr.spillWake = make(chan struct{}, 1)
+		go sr.spillWorker(sp, sr.spillWake, sr.spillStop)
+	}
+}
+
+//----------------------------------------------------------------------------------
+// wait until the slots evicted are all spilled
+//----------------------------------------------------------------------------------
+func (sr *StreamRing) SyncSpill() {
+	sr.Lock()
+	done := sr.spillDone
+	sr.Unlock()
+
+	if done != nil {
+		<-done
+	}
+}
+
+//----------------------------------------------------------------------------------
+// queue a copy of the slot evicted for the spill worker, must be called in lock
+//----------------------------------------------------------------------------------
+func (sr *StreamRing) queueSpill(ev *StreamSlot) {
+	if len(sr.spilling) >= NUM_MAX_SPILL_QUEUE {
+		log.Printf("spill is behind, slot %d is dropped\n", ev.Seq)
+		return
+	}
+
+	ss := &StreamSlot{
+		Type:      ev.Type,
+		Length:    ev.Length,
+		LengthMax: ev.Length,
+		Content:   append([]byte(nil), ev.Content[:ev.Length]...),
+		Timestamp: ev.Timestamp,
+		Seq:       ev.Seq,
+		Keyframe:  ev.Keyframe,
+		Origin:    ev.Origin,
+	}
+
+	if len(sr.spilling) == 0 {
+		sr.spillDone = make(chan struct{})
+	}
+	sr.spilling = append(sr.spilling, ss)
+
+	select {
+	case sr.spillWake <- struct{}{}:
+	default:
+	}
+}
+
+//----------------------------------------------------------------------------------
+// forget the slots queued, must be called in lock
+//----------------------------------------------------------------------------------
+func (sr *StreamRing) dropSpilling() {
+	sr.spilling = nil
+	if sr.spillDone != nil {
+		close(sr.spillDone)
+		sr.spillDone = nil
+	}
+}
+
+//----------------------------------------------------------------------------------
+// spill the slots queued in order until the spill tier is changed, a slot
+// is left in the queue while written not to be missed by the readers
+//----------------------------------------------------------------------------------
+func (sr *StreamRing) spillWorker(sp SlotSpiller, wake <-chan struct{}, stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-wake:
+		}
+
+		for {
+			sr.Lock()
+			if sr.Spill != sp || len(sr.spilling) == 0 {
+				sr.Unlock()
+				break
+			}
+			ss := sr.spilling[0]
+			sr.Unlock()
+
+			err := sp.SpillSlot(ss)
+			if err != nil {
+				log.Println(err)
+			}
+
+			sr.Lock()
+			if sr.Spill == sp && len(sr.spilling) > 0 && sr.spilling[0] == ss {
+				sr.spilling = sr.spilling[1:]
+				if len(sr.spilling) == 0 {
+					sr.dropSpilling()
+				}
+			}
+			sr.Unlock()
+		}
+	}
+}
+
+//----------------------------------------------------------------------------------
+// get the slot queued to be spilled, nil if not, must be called in lock
+//----------------------------------------------------------------------------------
+func (sr *StreamRing) getSpilling(seq int64) *StreamSlot {
+	i := sort.Search(len(sr.spilling), func(i int) bool {
+		return sr.spilling[i].Seq >= seq
+	})
+	if i < len(sr.spilling) && sr.spilling[i].Seq == seq {
+		return sr.spilling[i]
+	}
+	return nil
+}
+
+//----------------------------------------------------------------------------------
+// read the slot of the reader from the spill tier out of the lock and move
+// to the next, or to the last keyframe if it is not kept
+//----------------------------------------------------------------------------------
+func (rr *RingReader) readSpilled(sp SlotSpiller) (*StreamSlot, int, error) {
+	sr := rr.Ring
+
+	if rr.spill == nil {
+		rr.spill = NewStreamSlotBySize(sr.Size)
+	}
+
+	// the cursor is moved only by the reader itself
+	seq := rr.Seq
+	err := sp.ReadSpilled(seq, rr.spill)
+
+	sr.Lock()
+	defer sr.Unlock()
+
+	if err != nil {
+		old := rr.Seq
+		rr.resync()
+		skip := int(rr.Seq - old)
+		rr.Drops += int64(skip)
+		return nil, skip, sb.ErrOverrun
+	}
+
+	rr.Last = seq
+	rr.Seq = seq + 1
+	rr.Pos = sr.posOfSeq(rr.Seq)
+	rr.Reads++
+
+	return rr.spill, 0, err
+}
+
+// ---------------------------------E-----N-----D-----------------------------------
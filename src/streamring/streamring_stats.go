@@ -0,0 +1,209 @@
+//==================================================================================
+// Author : Stoney Kang, sikang99@gmail.com, 2015
+// Live statistics of the ring: fps, bitrate, jitter and drops
+//==================================================================================
+
+package streamring
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	sb "stoney/httpserver/src/streambase"
+)
+
+//----------------------------------------------------------------------------------
+const (
+	TIME_STATS_WINDOW = time.Second // window for the instantaneous rates
+)
+
+//==================================================================================
+// ring stats struc, a snapshot given by Stats()
+//----------------------------------------------------------------------------------
+type RingStats struct {
+	FramesIn int64
+	BytesIn  int64
+	FpsNow   float64 // in the last window
+	FpsAvg   float64 // from the first frame
+	KbpsNow  float64
+	KbpsAvg  float64
+	Jitter   float64 // inter-arrival jitter in ms
+	MaxFrame int     // largest frame in bytes
+	Drops    int64   // slots skipped by overruns of all readers
+	DropsIn  int64   // slots dropped by the policy
+	Blocks   int64   // timeouts of the writer blocked by the policy
+	Late     int64   // frames put in order by the reorder stage
+	DropsOrd int64   // frames too late or duplicated, discarded by the reorder
+	Oversize int64   // parts over the slot, skipped at ingest
+	Readers  []ReaderStats
+}
+
+type ReaderStats struct {
+	Id       int64 // id of the reader in the ring
+	Reads    int64 // frames out
+	Drops    int64
+	Lag      int64 // slots behind the newest
+	Lossless bool
+}
+
+//----------------------------------------------------------------------------------
+// string information for the stats, BaseString without the readers
+//----------------------------------------------------------------------------------
+func (rs *RingStats) BaseString() string {
+	str := fmt.Sprintf("[RingStats] In: %d/%d KB", rs.FramesIn, rs.BytesIn/sb.KBYTE)
+	str += fmt.Sprintf("\tFps: %.1f/%.1f", rs.FpsNow, rs.FpsAvg)
+	str += fmt.Sprintf("\tKbps: %.1f/%.1f", rs.KbpsNow, rs.KbpsAvg)
+	str += fmt.Sprintf("\tJitter: %.1f ms", rs.Jitter)
+	str += fmt.Sprintf("\tMaxFrame: %d", rs.MaxFrame)
+	str += fmt.Sprintf("\tDrops: %d,%d", rs.Drops, rs.DropsIn)
+	str += fmt.Sprintf("\tBlocks: %d", rs.Blocks)
+	str += fmt.Sprintf("\tLate: %d,%d", rs.Late, rs.DropsOrd)
+	str += fmt.Sprintf("\tOversize: %d", rs.Oversize)
+	str += fmt.Sprintf("\tReaders: %d", len(rs.Readers))
+	return str
+}
+
+func (rs *RingStats) String() string {
+	str := rs.BaseString()
+	for _, rs := range rs.Readers {
+		str += fmt.Sprintf("\n\t\t[%d] Out: %d\tDrops: %d\tLag: %d\tLossless: %v", rs.Id, rs.Reads, rs.Drops, rs.Lag, rs.Lossless)
+	}
+	return str
+}
+
+//==================================================================================
+// counters of the ring updated when a slot is published
+//----------------------------------------------------------------------------------
+type ringStats struct {
+	first     int64   // timestamp of the first frame
+	last      int64   // timestamp of the last frame
+	gap       float64 // last inter-arrival in ms
+	jitter    float64
+	maxFrame  int
+	drops     int64 // drops of the readers closed
+	dropsIn   int64
+	blocks    int64
+	late      int64
+	dropsOrd  int64
+	oversize  int64
+	winStart  int64
+	winFrames int64
+	winBytes  int64
+	winWall   time.Time // wall time the window began, to decay the rates
+	arrived   time.Time // wall time of the last frame
+	fpsNow    float64
+	kbpsNow   float64
+}
+
+//----------------------------------------------------------------------------------
+// account the slot published, must be called in lock
+//----------------------------------------------------------------------------------
+func (sr *StreamRing) updateStats(st *StreamSlot) {
+	rs := &sr.stats
+
+	sr.TotalBytes += int64(st.Length)
+	if st.Length > rs.maxFrame {
+		rs.maxFrame = st.Length
+	}
+
+	rs.arrived = time.Now()
+	if rs.first == 0 {
+		rs.first = st.Timestamp
+		rs.winStart = st.Timestamp
+		rs.winWall = rs.arrived
+	} else {
+		// smoothed deviation of inter-arrival, RFC 3550
+		gap := sb.GetDuration(st.Timestamp-rs.last).Seconds() * 1000
+		if rs.gap > 0 {
+			rs.jitter += (math.Abs(gap-rs.gap) - rs.jitter) / 16
+		}
+		rs.gap = gap
+	}
+	rs.last = st.Timestamp
+
+	rs.winFrames++
+	rs.winBytes += int64(st.Length)
+
+	elapsed := sb.GetDuration(st.Timestamp - rs.winStart)
+	if elapsed >= TIME_STATS_WINDOW {
+		rs.fpsNow = float64(rs.winFrames) / elapsed.Seconds()
+		rs.kbpsNow = float64(rs.winBytes*8) / 1000 / elapsed.Seconds()
+		rs.winStart = st.Timestamp
+		rs.winWall = rs.arrived
+		rs.winFrames = 0
+		rs.winBytes = 0
+	}
+}
+
+//----------------------------------------------------------------------------------
+// get the rates of the last window, decayed by the time of the window so far
+// if no frame for a window, must be called in lock
+//----------------------------------------------------------------------------------
+func (rs *ringStats) ratesNow(now time.Time) (float64, float64) {
+	if rs.arrived.IsZero() || now.Sub(rs.arrived) <= TIME_STATS_WINDOW {
+		return rs.fpsNow, rs.kbpsNow
+	}
+
+	elapsed := now.Sub(rs.winWall).Seconds()
+	return float64(rs.winFrames) / elapsed, float64(rs.winBytes*8) / 1000 / elapsed
+}
+
+//----------------------------------------------------------------------------------
+// count the part skipped at ingest as over the size of the slot
+//----------------------------------------------------------------------------------
+func (sr *StreamRing) CountOversize() {
+	sr.Lock()
+	sr.stats.oversize++
+	sr.Unlock()
+}
+
+//----------------------------------------------------------------------------------
+// get the statistics of the ring and its readers
+//----------------------------------------------------------------------------------
+func (sr *StreamRing) Stats() RingStats {
+	sr.Lock()
+	defer sr.Unlock()
+
+	rs := &sr.stats
+
+	st := RingStats{
+		FramesIn: sr.Seq,
+		BytesIn:  sr.TotalBytes,
+		Jitter:   rs.jitter,
+		MaxFrame: rs.maxFrame,
+		Drops:    rs.drops,
+		DropsIn:  rs.dropsIn,
+		Blocks:   rs.blocks,
+		Late:     rs.late,
+		DropsOrd: rs.dropsOrd,
+		Oversize: rs.oversize,
+	}
+
+	st.FpsNow, st.KbpsNow = rs.ratesNow(time.Now())
+
+	elapsed := sb.GetDuration(rs.last - rs.first).Seconds()
+	if elapsed > 0 {
+		st.FpsAvg = float64(sr.Seq-1) / elapsed
+		st.KbpsAvg = float64(sr.TotalBytes*8) / 1000 / elapsed
+	}
+
+	for rr := range sr.readers {
+		st.Drops += rr.Drops
+		st.Readers = append(st.Readers, ReaderStats{
+			Id:       rr.Id,
+			Reads:    rr.Reads,
+			Drops:    rr.Drops,
+			Lag:      sr.Seq - rr.Seq,
+			Lossless: rr.Lossless,
+		})
+	}
+	sort.Slice(st.Readers, func(i, j int) bool {
+		return st.Readers[i].Id < st.Readers[j].Id
+	})
+
+	return st
+}
+
+// ---------------------------------E-----N-----D-----------------------------------
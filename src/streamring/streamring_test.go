@@ -6,14 +6,26 @@
 package streamring
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
 	"fmt"
+	"io"
 	"log"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 
+	bp "stoney/httpserver/src/base"
 	sb "stoney/httpserver/src/streambase"
+	sm "stoney/httpserver/src/streamimage"
+	si "stoney/httpserver/src/streaminfo"
 )
 
 func init() {
@@ -99,6 +111,64 @@ func TestStreamSlot(t *testing.T) {
 	fmt.Println(slot)
 }
 
+//----------------------------------------------------------------------------------
+// test for the slots drawn from the buffer pool
+//----------------------------------------------------------------------------------
+func TestStreamSlotPool(t *testing.T) {
+	pool := bp.NewBufferPool(bp.LEN_MIN_CLASS, sb.MBYTE)
+	events := make(map[int]int)
+	pool.SetHook(func(event int, size int) {
+		events[event]++
+	})
+
+	buf := pool.Get(1500)
+	assert.Equal(t, 1500, len(buf))
+	assert.Equal(t, 2*bp.LEN_MIN_CLASS, cap(buf))
+	pool.Put(buf)
+	pool.Put(make([]byte, 1000)) // not fit to any class
+	pool.Put(pool.Get(2 * sb.MBYTE))
+	t.Log(pool)
+
+	assert.Equal(t, 2, events[bp.POOL_GET])
+	assert.Equal(t, 2, events[bp.POOL_ALLOC])
+	assert.Equal(t, 1, events[bp.POOL_PUT])
+	assert.Equal(t, 2, events[bp.POOL_DROP])
+
+	// freed slots go back to the default pool
+	slot := NewStreamSlotFromPool(sb.KBYTE * 3)
+	assert.Equal(t, sb.KBYTE*3, slot.LengthMax)
+	slot.Free()
+	assert.Nil(t, slot.Content)
+
+	// the shared frame is released and the buffer drawn is put to the pool
+	sr := NewStreamRingShared(4, sb.KBYTE, "Pool shared ring")
+	sr.PutSlotInNext(NewStreamSlotByData(4, "text/plain", 4, []byte("test")))
+	rr := sr.NewRingReader()
+	slot = NewStreamSlotFromPool(sb.KBYTE)
+	_, err := rr.ReadSlotTo(slot)
+	assert.Nil(t, err)
+	frame := slot.Frame
+	assert.Equal(t, 2, frame.Refs())
+	puts := atomic.LoadInt64(&bp.DefaultPool.Puts)
+	slot.Free()
+	assert.Equal(t, 1, frame.Refs())
+	assert.Equal(t, puts+1, atomic.LoadInt64(&bp.DefaultPool.Puts))
+	rr.Close()
+
+	// the buffer drawn is used again after the frame is released
+	cr := NewStreamRingWithSize(4, sb.KBYTE)
+	cr.PutSlotInNext(NewStreamSlotByData(4, "text/plain", 4, []byte("copy")))
+	slot = NewStreamSlotFromPool(sb.KBYTE)
+	buf = slot.Content
+	assert.Nil(t, sr.ReadSlotLatestTo(slot))
+	assert.NotNil(t, slot.Frame)
+	assert.Nil(t, cr.ReadSlotLatestTo(slot))
+	assert.Nil(t, slot.Frame)
+	assert.Equal(t, "copy", string(slot.Content[:slot.Length]))
+	assert.Equal(t, &buf[0], &slot.Content[0])
+	slot.Free()
+}
+
 //----------------------------------------------------------------------------------
 // test for handling functions of the stream buffer
 //----------------------------------------------------------------------------------
@@ -236,18 +306,18 @@ func TestStreamReadWrite(t *testing.T) {
 	sr := NewStreamRingWithSize(nb, sb.MBYTE)
 	sr.Desc = "Test buffer"
 
-	var fend bool = false
+	var fend int32
 
-	// define buffer reader()
+	// define buffer reader(), the slot is not printed being written
 	reader := func(i int) {
 		var pos int
-		for fend == false {
+		for atomic.LoadInt32(&fend) == 0 {
 			out, npos, err := sr.GetSlotNextByPos(pos)
 			if out == nil && err == sb.ErrEmpty {
 				time.Sleep(time.Millisecond)
 				continue
 			}
-			fmt.Println("R>", i, out, pos, npos)
+			fmt.Println("R>", i, pos, npos)
 			pos = npos
 		}
 	}
@@ -269,10 +339,10 @@ func TestStreamReadWrite(t *testing.T) {
 				fmt.Println(sr)
 				sr.SetPosInByPos(pos + 1)
 			*/
-			fmt.Println("W>", i, ss)
+			fmt.Println("W>", i, ss != nil)
 			time.Sleep(time.Millisecond)
 		}
-		fend = true
+		atomic.StoreInt32(&fend, 1)
 	}
 
 	// multi reader, single writer
@@ -284,6 +354,1287 @@ func TestStreamReadWrite(t *testing.T) {
 	writer(10)
 }
 
+//----------------------------------------------------------------------------------
+// test for reader cursor with overrun detection
+//----------------------------------------------------------------------------------
+func TestRingReader(t *testing.T) {
+	nb := 4
+	sr := NewStreamRingWithSize(nb, sb.KBYTE)
+
+	put := func(n int) {
+		for i := 0; i < n; i++ {
+			data := []byte(fmt.Sprintf("frame %d", sr.Seq))
+			in := NewStreamSlotByData(sb.KBYTE, "text/plain", len(data), data)
+			sr.PutSlotInNext(in)
+		}
+	}
+
+	// start at the newest slot
+	put(2)
+	rr := sr.NewRingReader()
+	t.Log(rr)
+
+	out, skip, err := rr.ReadSlot()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), out.Seq)
+	assert.Equal(t, 0, skip)
+
+	_, _, err = rr.ReadSlot()
+	assert.Equal(t, sb.ErrEmpty, err)
+
+	// read in order while not lapped
+	put(3)
+	for i := 2; i < 5; i++ {
+		out, _, err = rr.ReadSlot()
+		assert.Nil(t, err)
+		assert.Equal(t, int64(i), out.Seq)
+	}
+	assert.Nil(t, rr.Check())
+
+	// lapped by the writer, skip and resync to the newest
+	put(10)
+	assert.Equal(t, sb.ErrOverrun, rr.Check())
+
+	_, skip, err = rr.ReadSlot()
+	assert.Equal(t, sb.ErrOverrun, err)
+	assert.Equal(t, 9, skip)
+	assert.Equal(t, int64(9), rr.Drops)
+
+	slot := NewStreamSlotBySize(sb.KBYTE)
+	skip, err = rr.ReadSlotTo(slot)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(14), slot.Seq)
+	assert.Equal(t, "frame 14", string(slot.Content[:slot.Length]))
+	t.Log(rr)
+
+	// reset under the reader
+	sr.Reset()
+	put(1)
+	out, _, err = rr.ReadSlot()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), out.Seq)
+}
+
+//----------------------------------------------------------------------------------
+// test for blocking wait of reader until a new slot is published
+//----------------------------------------------------------------------------------
+func TestRingReaderWait(t *testing.T) {
+	sr := NewStreamRingWithSize(4, sb.KBYTE)
+	rr := sr.NewRingReader()
+
+	// nothing to read until timeout
+	_, _, err := rr.WaitSlot(context.Background(), 10*time.Millisecond)
+	assert.Equal(t, sb.ErrTimeout, err)
+
+	// woken up by the writer
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		data := []byte("wake up")
+		sr.PutSlotInNext(NewStreamSlotByData(sb.KBYTE, "text/plain", len(data), data))
+	}()
+
+	out, _, err := rr.WaitSlot(context.Background(), time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, "wake up", string(out.Content[:out.Length]))
+
+	// woken up by status change without a new slot
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		sr.SetStatusUsing()
+	}()
+
+	_, _, err = rr.WaitSlot(context.Background(), time.Second)
+	assert.Equal(t, sb.ErrEmpty, err)
+
+	// cancelled by the context
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+
+	_, _, err = rr.WaitSlot(ctx, 0)
+	assert.Equal(t, context.Canceled, err)
+}
+
+//----------------------------------------------------------------------------------
+// test for shared mode with reference counted frames
+//----------------------------------------------------------------------------------
+func TestStreamRingShared(t *testing.T) {
+	sr := NewStreamRingShared(3, sb.KBYTE, "Shared ring")
+	assert.True(t, sr.IsShared())
+
+	rr := sr.NewRingReader()
+	view := NewStreamSlotBySize(0)
+
+	// writer hands a frame over to the ring
+	frame := sr.Pool.Get(sb.KBYTE)
+	n := copy(frame.Data, "first frame")
+	sr.PutFrameInNext("text/plain", frame, n, sb.GetTimestampNow())
+
+	_, err := rr.ReadSlotTo(view)
+	assert.Nil(t, err)
+	assert.Equal(t, "first frame", string(view.Content[:view.Length]))
+	assert.True(t, view.Frame == frame)
+	assert.Equal(t, 2, frame.Refs())
+
+	// writer writes directly into the slot at the input position
+	slot, pos := sr.GetSlotIn()
+	n = copy(slot.Content, "second frame")
+	slot.Type, slot.Length = "text/plain", n
+	sr.SetPosInByPos(pos + 1)
+
+	// the view stays valid even after the writer laps it
+	for i := 0; i < 5; i++ {
+		data := []byte(fmt.Sprintf("frame %d", i))
+		sr.PutSlotInNext(NewStreamSlotByData(sb.KBYTE, "text/plain", len(data), data))
+	}
+	assert.Equal(t, 1, frame.Refs())
+	assert.Equal(t, "first frame", string(view.Content[:view.Length]))
+
+	_, err = sr.PutSlotInByPos(view, 0)
+	assert.Equal(t, sb.ErrSupport, err)
+
+	// the frame goes back to the pool with the last reference
+	view.Release()
+	assert.Equal(t, 0, frame.Refs())
+	assert.Nil(t, view.Content)
+
+	_, err = rr.ReadSlotTo(view)
+	assert.Equal(t, sb.ErrOverrun, err)
+	_, err = rr.ReadSlotTo(view)
+	assert.Nil(t, err)
+	assert.Equal(t, "frame 4", string(view.Content[:view.Length]))
+	view.Release()
+
+	sr.Reset()
+	t.Log(sr.Pool)
+	assert.True(t, sr.Pool.Allocs < sr.Pool.Gets)
+}
+
+//----------------------------------------------------------------------------------
+// test for byte budgeted ring with variable size slots
+//----------------------------------------------------------------------------------
+func TestStreamRingBytes(t *testing.T) {
+	sr := NewStreamRingWithBytes(140, 40, "Bytes ring")
+	assert.True(t, sr.IsBytes())
+
+	put := func(n int) {
+		data := make([]byte, n)
+		data[0] = byte(n)
+		_, err := sr.PutSlotInNext(NewStreamSlotByData(n, "application/octet-stream", n, data))
+		assert.Nil(t, err)
+	}
+
+	rr := sr.NewRingReader()
+
+	// small parts are kept with large ones
+	put(10)
+	put(30)
+	put(40)
+	// charged by the capacity, 16 + 32 + 64
+	assert.Equal(t, int64(112), sr.BytesUsed())
+	assert.Equal(t, int64(140), sr.BytesAllowed())
+
+	// the oldest is evicted over the budget
+	put(40)
+	assert.Equal(t, int64(128), sr.BytesUsed())
+	assert.Equal(t, int64(2), sr.Tail)
+	t.Log(sr.BaseString())
+
+	_, skip, err := rr.ReadSlot()
+	assert.Equal(t, sb.ErrOverrun, err)
+	assert.Equal(t, 3, skip)
+
+	// written through the input slot
+	slot, pos := sr.GetSlotIn()
+	slot.Type = "text/plain"
+	slot.Length = copy(slot.Content, "tiny")
+	sr.SetPosInByPos(pos + 1)
+	assert.Equal(t, int64(132), sr.BytesUsed())
+
+	out := NewStreamSlotBySize(0)
+	_, err = rr.ReadSlotTo(out)
+	assert.Nil(t, err)
+	assert.Equal(t, 40, out.Length)
+	_, err = rr.ReadSlotTo(out)
+	assert.Nil(t, err)
+	assert.Equal(t, "tiny", string(out.Content[:out.Length]))
+
+	// too big for the budget
+	_, err = sr.PutSlotInNext(NewStreamSlotBySize(50))
+	assert.Nil(t, err)
+	big := NewStreamSlotBySize(50)
+	big.Length = 50
+	_, err = sr.PutSlotInNext(big)
+	assert.NotNil(t, err)
+
+	sr.Reset()
+	assert.Equal(t, int64(0), sr.BytesUsed())
+
+	// slots added for the small frames under the budget
+	sr = NewStreamRingWithBytes(1000, 100, "Bytes ring")
+	assert.Equal(t, NUM_DEF_SLOTS, sr.Cap())
+	for i := 0; i < 100; i++ {
+		put(5)
+	}
+	assert.Equal(t, NUM_DEF_SLOTS*4, sr.Cap())
+	assert.Equal(t, int64(0), sr.Tail)
+	assert.Equal(t, int64(800), sr.BytesUsed())
+
+	// the buffers of the evicted go back to the pool of the ring, not shared
+	puts := atomic.LoadInt64(&bp.DefaultPool.Puts)
+	for i := 0; i < 10; i++ {
+		put(100)
+	}
+	assert.Equal(t, NUM_DEF_SLOTS*4, sr.Cap())
+	assert.True(t, atomic.LoadInt64(&sr.buffers.Puts) > 0)
+	assert.Equal(t, puts, atomic.LoadInt64(&bp.DefaultPool.Puts))
+	assert.True(t, sr.BytesUsed() <= 1000)
+}
+
+//----------------------------------------------------------------------------------
+// test for time based seek and retention
+//----------------------------------------------------------------------------------
+func TestStreamRingTime(t *testing.T) {
+	sr := NewStreamRingWithParams(10, sb.KBYTE, "Time ring")
+
+	put := func(ts int64) {
+		slot := NewStreamSlotByData(4, "text/plain", 4, []byte("time"))
+		slot.Timestamp = ts
+		_, err := sr.PutSlotInNext(slot)
+		assert.Nil(t, err)
+	}
+
+	_, err := sr.GetSlotByTime(100)
+	assert.Equal(t, sb.ErrEmpty, err)
+
+	for ts := int64(100); ts <= 500; ts += 100 {
+		put(ts)
+	}
+
+	slot, err := sr.GetSlotByTime(350)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(300), slot.Timestamp)
+
+	// the oldest if all are after the time
+	slot, err = sr.GetSlotByTime(50)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(100), slot.Timestamp)
+
+	rr := sr.NewRingReader()
+	err = rr.SeekTime(200)
+	assert.Nil(t, err)
+
+	out := NewStreamSlotBySize(sb.KBYTE)
+	_, err = rr.ReadSlotTo(out)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(200), out.Timestamp)
+	assert.Equal(t, int64(1), out.Seq)
+
+	// slots out of the window are expired
+	sr.SetRetention(250 * time.Millisecond)
+	put(600)
+	slot, err = sr.GetSlotByTime(0)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(400), slot.Timestamp)
+
+	_, skip, err := rr.ReadSlot()
+	assert.Equal(t, sb.ErrOverrun, err)
+	assert.Equal(t, 3, skip)
+	t.Log(sr.BaseString())
+
+	// relative to now
+	now := sb.GetTimestampNow()
+	put(now - 3000)
+	put(now)
+	sr.SetRetention(0)
+	err = rr.SeekAgo(time.Second)
+	assert.Nil(t, err)
+	_, err = rr.ReadSlotTo(out)
+	assert.Nil(t, err)
+	assert.Equal(t, now, out.Timestamp)
+}
+
+//----------------------------------------------------------------------------------
+// spiller kept in memory, blocked until the gate is opened
+//----------------------------------------------------------------------------------
+type gateSpiller struct {
+	sync.Mutex
+	gate  chan struct{}
+	slots map[int64]*StreamSlot
+}
+
+func (gs *gateSpiller) SpillSlot(ss *StreamSlot) error {
+	<-gs.gate
+
+	gs.Lock()
+	defer gs.Unlock()
+
+	gs.slots[ss.Seq] = ss
+	return nil
+}
+
+func (gs *gateSpiller) ReadSpilled(seq int64, ss *StreamSlot) error {
+	gs.Lock()
+	defer gs.Unlock()
+
+	in, ok := gs.slots[seq]
+	if !ok {
+		return sb.ErrFound
+	}
+	ss.copyFrom(in)
+	return nil
+}
+
+func (gs *gateSpiller) SeqByTime(ts int64) (int64, error) {
+	return 0, sb.ErrFound
+}
+
+//----------------------------------------------------------------------------------
+// test for the slots evicted spilled by the worker, out of the lock
+//----------------------------------------------------------------------------------
+func TestStreamRingSpill(t *testing.T) {
+	sr := NewStreamRingWithParams(4, sb.KBYTE, "Spill ring")
+	gs := &gateSpiller{gate: make(chan struct{}), slots: make(map[int64]*StreamSlot)}
+	sr.SetSpill(gs)
+
+	// not blocked by the spiller
+	for i := 0; i < 8; i++ {
+		data := []byte(fmt.Sprintf("slot %d", i))
+		slot := NewStreamSlotByData(len(data), "text/plain", len(data), data)
+		slot.Timestamp = int64(100 * (i + 1))
+		_, err := sr.PutSlotInNext(slot)
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, 0, len(gs.slots))
+
+	// the slots queued are read while spilled
+	rr := sr.NewRingReader()
+	defer rr.Close()
+	assert.Nil(t, rr.SeekTime(250))
+	assert.Equal(t, int64(1), rr.Seq)
+	out := NewStreamSlotBySize(sb.KBYTE)
+	_, err := rr.ReadSlotTo(out)
+	assert.Nil(t, err)
+	assert.Equal(t, "slot 1", string(out.Content[:out.Length]))
+
+	// and from the spill tier after spilled
+	close(gs.gate)
+	sr.SyncSpill()
+	assert.Equal(t, 5, len(gs.slots))
+	for i := 2; i < 8; i++ {
+		_, err = rr.ReadSlotTo(out)
+		assert.Nil(t, err)
+		assert.Equal(t, fmt.Sprintf("slot %d", i), string(out.Content[:out.Length]))
+	}
+	assert.Equal(t, int64(0), rr.Drops)
+
+	// not spilled any more
+	sr.SetSpill(nil)
+	sr.PutSlotInNext(NewStreamSlotByData(4, "text/plain", 4, []byte("more")))
+	sr.SyncSpill()
+	assert.Equal(t, 5, len(gs.slots))
+}
+
+//----------------------------------------------------------------------------------
+// test for keyframe aware GOP cache
+//----------------------------------------------------------------------------------
+func TestStreamRingGop(t *testing.T) {
+	put := func(sr *StreamRing, n int, key bool) {
+		slot := NewStreamSlotByData(n, "video/h264", n, make([]byte, n))
+		slot.Keyframe = key
+		_, err := sr.PutSlotInNext(slot)
+		assert.Nil(t, err)
+	}
+
+	sr := NewStreamRingWithParams(10, sb.KBYTE, "Gop ring")
+
+	// no keyframe, from the newest
+	put(sr, 10, false)
+	rr := sr.NewRingReader()
+	assert.Equal(t, int64(0), rr.Seq)
+	rr.Close()
+
+	put(sr, 10, true)
+	put(sr, 10, false)
+	put(sr, 10, false)
+	_, err := sr.GetSlotKeyframe()
+	assert.Nil(t, err)
+
+	// new joiner from the last keyframe
+	rr = sr.NewRingReader()
+	out := NewStreamSlotBySize(sb.KBYTE)
+	_, err = rr.ReadSlotTo(out)
+	assert.Nil(t, err)
+	assert.True(t, out.Keyframe)
+	assert.Equal(t, int64(1), out.Seq)
+	rr.Close()
+
+	// the GOP in use is not evicted over the budget
+	sr = NewStreamRingWithBytes(160, 50, "Gop bytes ring")
+	put(sr, 40, true)
+	rr = sr.NewRingReader()
+	put(sr, 40, false)
+	put(sr, 40, false)
+	assert.Equal(t, int64(192), sr.BytesUsed())
+	assert.Equal(t, int64(0), sr.Tail)
+	t.Log(sr.BaseString())
+
+	// the previous GOP can be evicted when a new one starts
+	put(sr, 40, true)
+	assert.Equal(t, int64(2), sr.Tail)
+	assert.Equal(t, int64(3), sr.KeySeq)
+
+	_, skip, err := rr.ReadSlot()
+	assert.Equal(t, sb.ErrOverrun, err)
+	assert.Equal(t, 3, skip)
+	slot, _, err := rr.ReadSlot()
+	assert.Nil(t, err)
+	assert.True(t, slot.Keyframe)
+
+	// free to evict without readers
+	rr.Close()
+	put(sr, 40, false)
+	put(sr, 40, false)
+	put(sr, 40, false)
+	assert.Equal(t, int64(128), sr.BytesUsed())
+
+	// the GOP in use grows the ring in count mode
+	sr = NewStreamRingWithParams(4, sb.KBYTE, "Gop count ring")
+	put(sr, 10, true)
+	rr = sr.NewRingReader()
+	for i := 0; i < 5; i++ {
+		put(sr, 10, false)
+	}
+	t.Log(sr.BaseString())
+	assert.Equal(t, 8, sr.Num)
+	assert.Equal(t, int64(0), sr.Tail)
+	for i := 0; i < 6; i++ {
+		slot, _, err = rr.ReadSlot()
+		assert.Nil(t, err)
+		assert.Equal(t, int64(i), slot.Seq)
+	}
+	rr.Close()
+
+	// neither dropped nor blocked by the policy for the GOP
+	for _, policy := range []int{POLICY_DROP_NEWEST, POLICY_BLOCK_WRITER} {
+		sr = NewStreamRingWithParams(4, sb.KBYTE, "Gop policy ring")
+		sr.SetPolicy(policy, time.Second)
+
+		// a lossy reader caught up, the ring goes on as is
+		put(sr, 10, true)
+		rr = sr.NewRingReader()
+		start := time.Now()
+		for i := 0; i < 40; i++ {
+			put(sr, 10, i%10 == 9)
+			_, err = rr.ReadSlotTo(out)
+			assert.Nil(t, err)
+		}
+		assert.True(t, time.Since(start) < 500*time.Millisecond)
+		assert.Equal(t, int64(41), sr.Seq)
+		assert.Equal(t, 4, sr.Num)
+		assert.Equal(t, int64(0), sr.Stats().DropsIn)
+
+		// a lossy reader behind in the GOP, grown for it
+		rr.Close()
+		rr = sr.NewRingReader()
+		put(sr, 10, false)
+		put(sr, 10, false)
+		put(sr, 10, false)
+		assert.Equal(t, int64(44), sr.Seq)
+		assert.Equal(t, 8, sr.Num)
+		rr.Close()
+	}
+}
+
+//----------------------------------------------------------------------------------
+// test for rings of tracks
+//----------------------------------------------------------------------------------
+func TestStreamTracks(t *testing.T) {
+	src := si.NewSource(3)
+	src.Trks[0].Type = si.STR_TRACK_VIDEO
+	src.Trks[1].Type = si.STR_TRACK_AUDIO
+	src.Trks[2].Type = si.STR_TRACK_TEXT
+
+	st := NewStreamTracks(src, 5, sb.KBYTE)
+	t.Log(st)
+
+	// demultiplexed by the type of content
+	for _, ctype := range []string{"image/jpeg", "audio/wav", "text/plain", "image/jpeg"} {
+		_, err := st.PutSlotByType(NewStreamSlotByData(4, ctype, 4, []byte("data")))
+		assert.Nil(t, err)
+	}
+	_, err := st.PutSlotByType(NewStreamSlotByData(4, "model/mesh", 4, []byte("data")))
+	assert.Equal(t, sb.ErrFound, err)
+
+	rings, err := st.GetRings()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), rings[0].Seq)
+	assert.Equal(t, int64(1), rings[1].Seq)
+	assert.Equal(t, int64(1), rings[2].Seq)
+
+	// subset of the tracks
+	rings, err = st.GetRings(src.Trks[2].Id, src.Trks[0].Id)
+	assert.Nil(t, err)
+	assert.Equal(t, src.Trks[2].Id, rings[0].Id)
+	assert.Equal(t, src.Trks[0].Id, rings[1].Id)
+
+	_, err = st.GetRings("999")
+	assert.Equal(t, sb.ErrFound, err)
+
+	// bound to the ring given
+	ring := NewStreamRingWithParams(3, sb.KBYTE, "bound ring")
+	err = st.BindRing(src.Trks[0].Id, ring)
+	assert.Nil(t, err)
+	found, _ := st.GetRingByType("video/h264")
+	assert.Equal(t, ring, found)
+
+	err = st.SetStatusUsing()
+	assert.Nil(t, err)
+	assert.True(t, st.IsUsing())
+	st.SetStatusIdle()
+	assert.False(t, st.IsUsing())
+}
+
+//----------------------------------------------------------------------------------
+// test for statistics of the ring
+//----------------------------------------------------------------------------------
+func TestStreamRingStats(t *testing.T) {
+	sr := NewStreamRingWithParams(5, sb.KBYTE, "Stats ring")
+
+	rr := sr.NewRingReader()
+	defer rr.Close()
+
+	// 10 fps of 100 bytes with one late frame
+	ts := int64(1000)
+	for i := 0; i < 21; i++ {
+		slot := NewStreamSlotByData(100, "image/jpeg", 100, make([]byte, 100))
+		if i == 10 {
+			slot.Length = 500
+			slot.Content = make([]byte, 500)
+			ts += 50
+		}
+		slot.Timestamp = ts
+		_, err := sr.PutSlotInNext(slot)
+		assert.Nil(t, err)
+		ts += 100
+	}
+
+	// the reader is lapped
+	_, skip, err := rr.ReadSlot()
+	assert.Equal(t, sb.ErrOverrun, err)
+	assert.Equal(t, 20, skip)
+
+	stats := sr.Stats()
+	t.Log(stats.String())
+	t.Log(sr.BaseString())
+
+	assert.Equal(t, int64(21), stats.FramesIn)
+	assert.Equal(t, int64(2500), stats.BytesIn)
+	assert.Equal(t, sr.TotalBytes, stats.BytesIn)
+	assert.Equal(t, 500, stats.MaxFrame)
+	assert.InDelta(t, 10.0, stats.FpsNow, 0.5)
+	assert.InDelta(t, 10.0, stats.FpsAvg, 0.5)
+	assert.True(t, stats.Jitter > 0)
+	assert.Equal(t, int64(20), stats.Drops)
+	assert.Equal(t, 1, len(stats.Readers))
+
+	// decayed on the ring stalled over a window
+	sr.Lock()
+	sr.stats.arrived = sr.stats.arrived.Add(-2 * TIME_STATS_WINDOW)
+	sr.stats.winWall = sr.stats.winWall.Add(-2 * TIME_STATS_WINDOW)
+	sr.Unlock()
+	stalled := sr.Stats()
+	assert.True(t, stalled.FpsNow < stats.FpsNow)
+	assert.True(t, stalled.KbpsNow < stats.KbpsNow)
+	assert.Equal(t, stats.FpsAvg, stalled.FpsAvg)
+
+	// drops of the reader closed are kept
+	rr.Close()
+	assert.Equal(t, int64(20), sr.Stats().Drops)
+
+	// readers in the order made, by their ids
+	var rrs []*RingReader
+	for i := 0; i < 8; i++ {
+		rrs = append(rrs, sr.NewRingReader())
+	}
+	rrs[3].Close()
+	readers := sr.Stats().Readers
+	assert.Equal(t, 7, len(readers))
+	for i := 1; i < len(readers); i++ {
+		assert.True(t, readers[i-1].Id < readers[i].Id)
+	}
+	assert.Equal(t, rrs[4].Id, readers[3].Id)
+	for _, rr := range rrs {
+		rr.Close()
+	}
+
+	sr.Reset()
+	assert.Equal(t, int64(0), sr.Stats().BytesIn)
+}
+
+//----------------------------------------------------------------------------------
+// test for back-pressure policy of the ring
+//----------------------------------------------------------------------------------
+func TestStreamRingPolicy(t *testing.T) {
+	sr := NewStreamRingWithParams(4, sb.KBYTE, "Policy ring")
+	put := func() error {
+		_, err := sr.PutSlotInNext(NewStreamSlotByData(4, "text/plain", 4, []byte("data")))
+		return err
+	}
+
+	rr := sr.NewRingReader()
+	defer rr.Close()
+	rr.SetLossless(true)
+
+	// drop the newest when the lossless reader is to be lapped
+	err := sr.SetPolicy(POLICY_DROP_NEWEST, 0)
+	assert.Nil(t, err)
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, put())
+	}
+	assert.Equal(t, sb.ErrFull, put())
+	assert.Equal(t, int64(1), sr.Stats().DropsIn)
+
+	_, _, err = rr.ReadSlot()
+	assert.Nil(t, err)
+	assert.Nil(t, put())
+
+	// block the writer until the reader catches up
+	sr.SetPolicy(POLICY_BLOCK_WRITER, time.Second)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		rr.ReadSlot()
+	}()
+	start := time.Now()
+	assert.Nil(t, put())
+	assert.True(t, time.Since(start) >= 50*time.Millisecond)
+	assert.Equal(t, int64(0), sr.Stats().Blocks)
+
+	// and overwrite after the timeout
+	sr.SetPolicy(POLICY_BLOCK_WRITER, 10*time.Millisecond)
+	assert.Nil(t, put())
+	assert.Equal(t, int64(1), sr.Stats().Blocks)
+	_, _, err = rr.ReadSlot()
+	assert.Equal(t, sb.ErrOverrun, err)
+
+	// lossy readers are not considered
+	rr.SetLossless(false)
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, put())
+	}
+	t.Log(sr.BaseString())
+}
+
+//----------------------------------------------------------------------------------
+// test for the registry of named rings
+//----------------------------------------------------------------------------------
+func TestRingRegistry(t *testing.T) {
+	rg := NewRingRegistry(4, sb.KBYTE, 20*time.Millisecond)
+
+	// pinned by hand, not collected
+	err := rg.Add("100/110/111", NewStreamRingWithSize(3, sb.KBYTE))
+	assert.Nil(t, err)
+	assert.NotNil(t, rg.Add("../etc", NewStreamRing()))
+	assert.NotNil(t, rg.Add("a/b/c/d", NewStreamRing()))
+
+	// created at the first publish
+	ring, created, err := rg.GetOrCreate("lobby-cam")
+	assert.Nil(t, err)
+	assert.True(t, created)
+	assert.Equal(t, 4, ring.Num)
+	again, created, _ := rg.GetOrCreate("lobby-cam")
+	assert.False(t, created)
+	assert.Equal(t, ring, again)
+
+	// with the time window of the registry
+	rg.Retention = time.Minute
+	hall, _, _ := rg.GetOrCreate("hall-cam")
+	assert.Equal(t, time.Minute, hall.Retention)
+	rg.Remove("hall-cam")
+
+	// in shared mode of the registry or by the caller
+	rg.Mode = RING_MODE_SHARED
+	hall, _, _ = rg.GetOrCreate("hall-cam")
+	assert.True(t, hall.IsShared())
+	assert.Equal(t, sb.KBYTE, hall.Pool.Size)
+	rg.Remove("hall-cam")
+	rg.Mode = RING_MODE_COPY
+	hall, _, _ = rg.GetOrCreateWithMode("hall-cam", RING_MODE_SHARED, 0, 0)
+	assert.Equal(t, RING_MODE_SHARED, hall.GetMode())
+	rg.Remove("hall-cam")
+	hall, _, _ = rg.GetOrCreateWithMode("hall-cam", RING_MODE_BYTES, 0, 0)
+	assert.Equal(t, RING_MODE_BYTES, hall.GetMode())
+	assert.Equal(t, int64(4*sb.KBYTE), hall.BytesAllowed())
+	rg.Remove("hall-cam")
+	_, err = GetRingModeByName("mirror")
+	assert.NotNil(t, err)
+
+	_, err = rg.Get("no-cam")
+	assert.Equal(t, sb.ErrFound, err)
+	assert.Equal(t, []string{"100/110/111", "lobby-cam"}, rg.Names())
+	assert.True(t, rg.IsPinned("100/110/111"))
+	assert.False(t, rg.IsPinned("lobby-cam"))
+
+	// lookup by the request path
+	def := NewStreamRing()
+	found, err := rg.LookupRing("GET", "/stream/lobby-cam", def)
+	assert.Nil(t, err)
+	assert.Equal(t, ring, found)
+	found, _ = rg.LookupRing("GET", "/stream", def)
+	assert.Equal(t, def, found)
+	_, err = rg.LookupRing("GET", "/stream/door-cam", def)
+	assert.Equal(t, sb.ErrFound, err)
+	found, _ = rg.LookupRing("POST", "/stream/door-cam", def)
+	assert.Equal(t, "door-cam", found.Id)
+
+	// kept while read, collected after idle
+	rr := ring.NewRingReader()
+	time.Sleep(30 * time.Millisecond)
+	assert.Equal(t, []string{"door-cam"}, rg.CollectIdle())
+	rr.Close()
+	time.Sleep(30 * time.Millisecond)
+	assert.Equal(t, []string{"lobby-cam"}, rg.CollectIdle())
+
+	assert.Nil(t, rg.Remove("100/110/111"))
+	assert.Equal(t, sb.ErrFound, rg.Remove("100/110/111"))
+	assert.Equal(t, 0, len(rg.Names()))
+	t.Log(rg)
+}
+
+//----------------------------------------------------------------------------------
+// test for the combiner of rings
+//----------------------------------------------------------------------------------
+func TestRingCombiner(t *testing.T) {
+	cam1 := NewStreamRingWithSize(4, sb.KBYTE*64)
+	cam1.Id = "cam1"
+	cam2 := NewStreamRingWithSize(4, sb.KBYTE*64)
+	cam2.Id = "cam2"
+
+	// interleave the frames tagged with their origin
+	out := NewStreamRingWithSize(8, sb.KBYTE*64)
+	rc := NewRingCombiner(COMBINE_INTERLEAVE, out, cam1, cam2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- rc.Run(ctx) }()
+
+	rr := out.NewRingReader()
+	defer rr.Close()
+	time.Sleep(20 * time.Millisecond)
+	assert.True(t, out.IsUsing())
+
+	cam1.PutSlotInNext(NewStreamSlotByData(4, "text/plain", 4, []byte("cam1")))
+	cam2.PutSlotInNext(NewStreamSlotByData(4, "text/plain", 4, []byte("cam2")))
+
+	origins := make(map[string]string)
+	for len(origins) < 2 {
+		slot, _, err := rr.WaitSlot(ctx, time.Second)
+		assert.NotEqual(t, sb.ErrTimeout, err)
+		if err == sb.ErrTimeout {
+			break
+		}
+		if slot != nil {
+			origins[slot.Origin] = string(slot.Content[:slot.Length])
+		}
+	}
+	assert.Equal(t, map[string]string{"cam1": "cam1", "cam2": "cam2"}, origins)
+
+	cancel()
+	assert.Nil(t, <-done)
+	assert.False(t, out.IsUsing())
+
+	// tile the JPEG frames at the target fps
+	data, err := sm.PutImageToBuffer(sm.GenSimpleImage(64, 48), "jpg", 80)
+	assert.Nil(t, err)
+
+	wall := NewStreamRingWithSize(4, sb.KBYTE*64)
+	rc = NewRingCombiner(COMBINE_MOSAIC, wall, cam1, cam2)
+	rc.Fps = 50
+	rc.TileX, rc.TileY = 32, 24
+
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() { done <- rc.Run(ctx) }()
+	time.Sleep(20 * time.Millisecond)
+	cam1.PutSlotInNext(NewStreamSlotByData(len(data), "image/jpeg", len(data), data))
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	assert.Nil(t, <-done)
+
+	slot, err := wall.GetSlotKeyframe()
+	assert.Nil(t, err)
+	img, err := sm.GetImageFromBuffer(slot.Content[:slot.Length])
+	assert.Nil(t, err)
+	assert.Equal(t, 64, img.Bounds().Dx())
+	assert.True(t, rc.Frames > 0)
+	t.Log(rc)
+}
+
+//----------------------------------------------------------------------------------
+// test for resizing the ring with the reader active
+//----------------------------------------------------------------------------------
+func TestStreamRingResize(t *testing.T) {
+	put := func(sr *StreamRing, i int) {
+		data := []byte(fmt.Sprintf("frame-%d", i))
+		sr.PutSlotInNext(NewStreamSlotByData(len(data), "text/plain", len(data), data))
+	}
+	read := func(rr *RingReader, slot *StreamSlot) string {
+		_, err := rr.ReadSlotTo(slot)
+		if err != nil {
+			return err.Error()
+		}
+		return string(slot.Content[:slot.Length])
+	}
+
+	for mode, sr := range map[string]*StreamRing{
+		"copy":   NewStreamRingWithSize(4, sb.KBYTE),
+		"shared": NewStreamRingShared(4, sb.KBYTE, "Resize shared ring"),
+	} {
+		t.Log("Resize", mode)
+		slot := NewStreamSlotBySize(sb.KBYTE)
+		for i := 0; i < 5; i++ {
+			put(sr, i)
+		}
+		rr := sr.NewRingReader()
+		assert.Equal(t, "frame-4", read(rr, slot))
+
+		// grow keeping the slots, the new ones have their own buffers
+		assert.Nil(t, sr.Resize(8))
+		assert.Equal(t, 8, sr.Len())
+		assert.Equal(t, 8, sr.Cap())
+		for i := 5; i < 10; i++ {
+			put(sr, i)
+		}
+		assert.Equal(t, int64(10), sr.Seq)
+		for i := 5; i < 10; i++ {
+			assert.Equal(t, fmt.Sprintf("frame-%d", i), read(rr, slot))
+		}
+		for seq := sr.oldest(); seq < sr.Seq; seq++ {
+			st, err := sr.GetSlotByPos(sr.posOfSeq(seq))
+			assert.Nil(t, err)
+			assert.Equal(t, fmt.Sprintf("frame-%d", seq), string(st.Content[:st.Length]))
+		}
+
+		// shrink evicting the older ones, the reader lagging is overrun
+		lag := sr.NewRingReader()
+		lag.Seq, lag.Pos = sr.oldest(), sr.posOfSeq(sr.oldest())
+		assert.Nil(t, sr.Resize(3))
+		assert.Equal(t, 3, sr.Len())
+		assert.Equal(t, int64(8), sr.oldest())
+		assert.Equal(t, sb.ErrOverrun.Error(), read(lag, slot))
+		assert.Equal(t, "frame-9", read(lag, slot))
+
+		put(sr, 10)
+		assert.Equal(t, "frame-10", read(rr, slot))
+		assert.Equal(t, "frame-10", read(lag, slot))
+
+		lag.Close()
+		rr.Close()
+		assert.NotNil(t, sr.Resize(1))
+		t.Log(sr.BaseString())
+	}
+
+	br := NewStreamRingWithBytes(4*sb.KBYTE, sb.KBYTE, "Resize bytes ring")
+	assert.Equal(t, sb.ErrSupport, br.Resize(8))
+}
+
+//----------------------------------------------------------------------------------
+// test for the reorder stage in front of the ring
+//----------------------------------------------------------------------------------
+func TestRingReorder(t *testing.T) {
+	sr := NewStreamRingWithSize(16, sb.KBYTE)
+	ro := NewRingReorder(sr, ORDER_SEQUENCE, 100*time.Millisecond)
+
+	put := func(seq int64, now time.Time) error {
+		data := []byte(fmt.Sprintf("frame-%d", seq))
+		slot := NewStreamSlotByData(len(data), "text/plain", len(data), data)
+		slot.Seq = seq
+		return ro.putSlot(slot, now)
+	}
+
+	// held in the window, then published in order
+	t0 := time.Now()
+	assert.Nil(t, put(2, t0))
+	assert.Nil(t, put(1, t0.Add(10*time.Millisecond)))
+	assert.Equal(t, sb.ErrDup, put(2, t0.Add(20*time.Millisecond)))
+	assert.Nil(t, put(3, t0.Add(30*time.Millisecond)))
+	assert.Equal(t, int64(0), sr.Seq)
+
+	// the ones before the earliest arrival over the window
+	assert.Nil(t, put(5, t0.Add(120*time.Millisecond)))
+	assert.Equal(t, int64(2), sr.Seq)
+
+	// older or same as the ones published
+	assert.Equal(t, sb.ErrLate, put(0, t0.Add(130*time.Millisecond)))
+	assert.Equal(t, sb.ErrDup, put(2, t0.Add(130*time.Millisecond)))
+	assert.Nil(t, put(4, t0.Add(140*time.Millisecond)))
+	assert.Equal(t, int64(3), sr.Seq)
+	assert.Nil(t, ro.Flush())
+	assert.Equal(t, int64(5), sr.Seq)
+	assert.Equal(t, 0, len(ro.arrivals))
+
+	rr := sr.NewRingReader()
+	rr.Seq, rr.Pos = 0, sr.posOfSeq(0)
+	slot := NewStreamSlotBySize(sb.KBYTE)
+	for i := 1; i <= 5; i++ {
+		_, err := rr.ReadSlotTo(slot)
+		assert.Nil(t, err)
+		assert.Equal(t, fmt.Sprintf("frame-%d", i), string(slot.Content[:slot.Length]))
+	}
+	rr.Close()
+
+	stats := sr.Stats()
+	assert.Equal(t, int64(2), stats.Late)
+	assert.Equal(t, int64(3), stats.DropsOrd)
+	t.Log(ro)
+	t.Log(stats.BaseString())
+
+	// the arrivals popped are dropped, not growing with the frames put
+	mr := NewStreamRingWithSize(128, sb.KBYTE)
+	ro = NewRingReorder(mr, ORDER_SEQUENCE, time.Hour)
+	ro.Max = 4
+	for i := int64(0); i < 100; i++ {
+		slot := NewStreamSlotByData(1, "text/plain", 1, []byte("x"))
+		slot.Seq = i ^ 1
+		assert.Nil(t, ro.putSlot(slot, t0.Add(time.Duration(i)*time.Millisecond)))
+	}
+	assert.Equal(t, int64(96), mr.Seq)
+	assert.True(t, len(ro.arrivals) <= 2*ro.Max)
+	assert.Equal(t, int64(99), ro.newest)
+
+	// by timestamp, published by Run after the window
+	tr := NewStreamRingWithSize(16, sb.KBYTE)
+	ro = NewRingReorder(tr, ORDER_TIMESTAMP, 20*time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- ro.Run(ctx) }()
+
+	for _, ts := range []int64{300, 100, 200} {
+		slot := NewStreamSlotByData(1, "text/plain", 1, []byte("x"))
+		slot.Timestamp = ts
+		assert.Nil(t, ro.PutSlot(slot))
+	}
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	assert.Nil(t, <-done)
+	assert.Equal(t, int64(3), tr.Seq)
+	for i, ts := range []int64{100, 200, 300} {
+		st, err := tr.GetSlotByPos(i)
+		assert.Nil(t, err)
+		assert.Equal(t, ts, st.Timestamp)
+	}
+
+	// frames of the same timestamp are duplicates only if the same content
+	for _, data := range []string{"video", "audio", "video"} {
+		slot := NewStreamSlotByData(len(data), "text/plain", len(data), []byte(data))
+		slot.Timestamp = 400
+		err := ro.putSlot(slot, t0)
+		if data == "video" && err != nil {
+			assert.Equal(t, sb.ErrDup, err)
+		} else {
+			assert.Nil(t, err)
+		}
+	}
+	assert.Nil(t, ro.Flush())
+	assert.Equal(t, int64(5), tr.Seq)
+	slot = NewStreamSlotByData(5, "text/plain", 5, []byte("audio"))
+	slot.Timestamp = 400
+	assert.Equal(t, sb.ErrDup, ro.putSlot(slot, t0))
+
+	// not locked while the ring blocks the writer
+	br := NewStreamRingWithSize(4, sb.KBYTE)
+	br.SetPolicy(POLICY_BLOCK_WRITER, 300*time.Millisecond)
+	rr = br.NewRingReader()
+	rr.SetLossless(true)
+	defer rr.Close()
+	ro = NewRingReorder(br, ORDER_SEQUENCE, time.Second)
+	for i := int64(0); i < 4; i++ {
+		slot := NewStreamSlotByData(1, "text/plain", 1, []byte("x"))
+		slot.Seq = i
+		assert.Nil(t, ro.PutSlot(slot))
+	}
+	done = make(chan error)
+	go func() { done <- ro.Flush() }()
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int64(3), br.Stats().FramesIn)
+
+	start := time.Now()
+	slot = NewStreamSlotByData(1, "text/plain", 1, []byte("x"))
+	slot.Seq = 4
+	assert.Nil(t, ro.PutSlot(slot))
+	t.Log(ro)
+	assert.True(t, time.Since(start) < 100*time.Millisecond)
+	assert.Nil(t, <-done)
+	assert.Equal(t, int64(4), br.Seq)
+}
+
+//----------------------------------------------------------------------------------
+// test for the snapshot saved and restored
+//----------------------------------------------------------------------------------
+func TestStreamRingSnapshot(t *testing.T) {
+	sr := NewStreamRingWithSize(4, sb.KBYTE)
+	sr.Id = "lobby-cam"
+	sr.SetBoundary("myboundary")
+	for i := 0; i < 6; i++ {
+		data := []byte(fmt.Sprintf("frame-%d", i))
+		slot := NewStreamSlotByData(len(data), "text/plain", len(data), data)
+		slot.Keyframe = (i == 4)
+		slot.Origin = "cam"
+		sr.PutSlotInNext(slot)
+	}
+
+	var buf bytes.Buffer
+	assert.Nil(t, sr.WriteSnapshot(&buf))
+	snap := buf.Bytes()
+
+	// restored at the same position and sequence
+	rs := NewStreamRingWithSize(4, sb.KBYTE)
+	assert.Nil(t, rs.ReadSnapshot(bytes.NewReader(snap)))
+	assert.Equal(t, sr.Seq, rs.Seq)
+	assert.Equal(t, sr.In, rs.In)
+	assert.Equal(t, sr.KeySeq, rs.KeySeq)
+	assert.Equal(t, "myboundary", rs.GetBoundary())
+
+	// read from the last keyframe
+	rr := rs.NewRingReader()
+	slot := NewStreamSlotBySize(sb.KBYTE)
+	for i := 4; i < 6; i++ {
+		_, err := rr.ReadSlotTo(slot)
+		assert.Nil(t, err)
+		assert.Equal(t, fmt.Sprintf("frame-%d", i), string(slot.Content[:slot.Length]))
+		assert.Equal(t, "cam", slot.Origin)
+		assert.Equal(t, i == 4, slot.Keyframe)
+	}
+	rr.Close()
+
+	// invalid snapshots leave the ring as it is
+	broken := append([]byte(nil), snap...)
+	broken[len(broken)/2] ^= 0xff
+	assert.Equal(t, sb.ErrCheck, rs.ReadSnapshot(bytes.NewReader(broken)))
+	assert.Equal(t, sr.Seq, rs.Seq)
+
+	future := append([]byte(nil), snap...)
+	future[len(STR_SNAP_MAGIC)+1] = NUM_SNAP_VERSION + 1
+	assert.Equal(t, sb.ErrSupport, rs.ReadSnapshot(bytes.NewReader(future)))
+	assert.Equal(t, sb.ErrParse, rs.ReadSnapshot(bytes.NewReader([]byte("garbage"))))
+	assert.Equal(t, sb.ErrParse, rs.ReadSnapshot(bytes.NewReader(snap[:len(snap)/2])))
+
+	// the length of a slot over what is left or the budget is not allocated
+	huge := append([]byte(nil), snap...)
+	off := len(STR_SNAP_MAGIC) + 2 + binary.Size(snapHeader{})
+	off += 2 + len(sr.Id) + 2 + len("myboundary") + 2 + len(sr.Desc)
+	assert.Equal(t, uint32(len("frame-2")), binary.BigEndian.Uint32(huge[off+16:]))
+	binary.BigEndian.PutUint32(huge[off+16:], uint32(LEN_MAX_SLOT/2))
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	alloc := ms.TotalAlloc
+	assert.Equal(t, sb.ErrParse, rs.ReadSnapshot(bytes.NewReader(huge)))
+	assert.Equal(t, sb.ErrParse, rs.ReadSnapshot(struct{ io.Reader }{bytes.NewReader(huge)}))
+	runtime.ReadMemStats(&ms)
+	assert.True(t, ms.TotalAlloc-alloc < sb.MBYTE)
+	assert.Equal(t, sr.Seq, rs.Seq)
+
+	// saved to and loaded from the file
+	path := filepath.Join(t.TempDir(), "lobby-cam.snap")
+	assert.Nil(t, sr.SaveSnapshot(path))
+	fs := NewStreamRingWithSize(8, sb.KBYTE)
+	assert.Nil(t, fs.LoadSnapshot(path))
+	assert.Equal(t, sr.Seq, fs.Seq)
+	t.Log(fs.BaseString())
+}
+
+//----------------------------------------------------------------------------------
+// stress test for many writers and readers, to be run with -race
+// - every slot read must be whole, written by one writer only
+//----------------------------------------------------------------------------------
+func TestStreamRingStress(t *testing.T) {
+	rings := map[string]*StreamRing{
+		"copy":   NewStreamRingWithSize(8, sb.KBYTE),
+		"shared": NewStreamRingShared(8, sb.KBYTE, "Stress shared ring"),
+		"bytes":  NewStreamRingWithBytes(8*sb.KBYTE, sb.KBYTE, "Stress bytes ring"),
+	}
+
+	for mode, sr := range rings {
+		stressRing(t, mode, sr)
+	}
+}
+
+func stressRing(t *testing.T, mode string, sr *StreamRing) {
+	nw, nr, nf := 4, 8, 300
+
+	// slot filled with the id of the writer
+	fill := func(slot *StreamSlot, id int, n int) {
+		slot.Type = fmt.Sprintf("x/%d", id)
+		slot.Length = 1 + n%(sr.Size-1)
+		for i := 0; i < slot.Length; i++ {
+			slot.Content[i] = byte(id)
+		}
+	}
+
+	var torn, reads int64
+	check := func(slot *StreamSlot) {
+		id := slot.Content[0]
+		if slot.Type != fmt.Sprintf("x/%d", id) {
+			atomic.AddInt64(&torn, 1)
+			return
+		}
+		for _, c := range slot.Content[:slot.Length] {
+			if c != id {
+				atomic.AddInt64(&torn, 1)
+				return
+			}
+		}
+		atomic.AddInt64(&reads, 1)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var rwg, wwg sync.WaitGroup
+
+	// readers copying slots, checking the order of sequence
+	for i := 0; i < nr; i++ {
+		rwg.Add(1)
+		go func() {
+			defer rwg.Done()
+
+			rr := sr.NewRingReader()
+			defer rr.Close()
+
+			out := NewStreamSlotBySize(sr.Size)
+			last := int64(-1)
+			for ctx.Err() == nil {
+				_, err := rr.WaitSlotTo(ctx, 10*time.Millisecond, out)
+				if err != nil {
+					continue
+				}
+				if out.Seq <= last {
+					atomic.AddInt64(&torn, 1)
+				}
+				last = out.Seq
+				check(out)
+				out.Release()
+				if out.Content == nil {
+					out.Content = make([]byte, sr.Size)
+				}
+			}
+		}()
+	}
+
+	// writers copying slots into the ring
+	for i := 1; i <= nw; i++ {
+		wwg.Add(1)
+		go func(id int) {
+			defer wwg.Done()
+
+			in := NewStreamSlotBySize(sr.Size)
+			for n := 0; n < nf; n++ {
+				fill(in, id, n)
+				sr.PutSlotInNext(in)
+				if n%10 == 0 {
+					time.Sleep(time.Millisecond)
+				}
+			}
+		}(i)
+	}
+
+	// the caster writing in place, published at once
+	wwg.Add(1)
+	go func() {
+		defer wwg.Done()
+
+		for n := 0; n < nf; n++ {
+			slot, pos := sr.GetSlotIn()
+			fill(slot, 100, n)
+			sr.SetPosInByPos(pos + 1)
+			if n%10 == 0 {
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}()
+
+	// monitor and controller
+	wwg.Add(1)
+	go func() {
+		defer wwg.Done()
+
+		for n := 0; n < nf/10; n++ {
+			sr.SetStatusUsing()
+			_ = sr.BaseString()
+			_ = sr.Stats()
+			_ = sr.IsUsing()
+			_ = sr.GetPosIn()
+			_ = sr.Len()
+			sr.SetStatusIdle()
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	wwg.Wait()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	rwg.Wait()
+
+	t.Logf("%s: %d reads, %d torn, seq %d", mode, reads, torn, sr.Seq)
+	assert.Equal(t, int64(0), torn, mode)
+	assert.True(t, reads > 0, mode)
+	assert.Equal(t, int64((nw+1)*nf), sr.Seq, mode)
+}
+
+//----------------------------------------------------------------------------------
+// test for parts without length, over the max and with boundaries of both forms
+//----------------------------------------------------------------------------------
+func TestReadPartToSlot(t *testing.T) {
+	stream := "--myboundary\r\n" +
+		"Content-Type: image/jpeg\r\n\r\n" +
+		"no length\r\n" +
+		"--myboundary\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"Content-Length: 4\r\n" +
+		"X-Timestamp: 1234\r\n\r\n" +
+		"four\r\n" +
+		"--myboundary\r\n" +
+		"Content-Type: text/plain\r\n\r\n" +
+		"too long for the slot\r\n" +
+		"--myboundary\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"Content-Length: 30\r\n\r\n" +
+		"012345678901234567890123456789\r\n" +
+		"--myboundary--\r\n"
+
+	for _, boundary := range []string{"myboundary", "--myboundary"} {
+		mr := NewPartReader(bytes.NewBufferString(stream), boundary)
+		slot := NewStreamSlotBySize(16)
+
+		err := ReadPartToSlot(mr, slot)
+		assert.Nil(t, err, boundary)
+		assert.Equal(t, "no length", string(slot.Content[:slot.Length]))
+		assert.Equal(t, "image/jpeg", slot.Type)
+
+		err = ReadPartToSlot(mr, slot)
+		assert.Nil(t, err, boundary)
+		assert.Equal(t, "four", string(slot.Content[:slot.Length]))
+		assert.Equal(t, int64(1234), slot.Timestamp)
+
+		// over the max by scanning and by the length
+		err = ReadPartToSlot(mr, slot)
+		assert.Equal(t, sb.ErrSize, err, boundary)
+		assert.Equal(t, 0, slot.Length)
+		err = ReadPartToSlot(mr, slot)
+		assert.Equal(t, sb.ErrSize, err, boundary)
+
+		err = ReadPartToSlot(mr, slot)
+		assert.Equal(t, io.EOF, err, boundary)
+	}
+
+	// the boundary given with -- and put after -- in the stream as the spec
+	std := strings.Replace(stream, "--myboundary", "----myboundary", -1)
+	mr := NewPartReader(bytes.NewBufferString(std), "--myboundary")
+	slot := NewStreamSlotBySize(16)
+	err := ReadPartToSlot(mr, slot)
+	assert.Nil(t, err)
+	assert.Equal(t, "no length", string(slot.Content[:slot.Length]))
+}
+
 //----------------------------------------------------------------------------------
 // test for stream array (set of rings)
 //----------------------------------------------------------------------------------
@@ -0,0 +1,162 @@
+//==================================================================================
+// Author : Stoney Kang, sikang99@gmail.com, 2015
+// Time based seek and retention of the ring by slot timestamps
+//==================================================================================
+
+package streamring
+
+import (
+	"time"
+
+	sb "stoney/httpserver/src/streambase"
+)
+
+//----------------------------------------------------------------------------------
+// set the time window of slots kept in addition to the number of slots
+//----------------------------------------------------------------------------------
+func (sr *StreamRing) SetRetention(d time.Duration) {
+	sr.Lock()
+	defer sr.Unlock()
+
+	sr.Retention = d
+}
+
+//----------------------------------------------------------------------------------
+// evict the slots older than the window from the newest, must be called in lock
+//----------------------------------------------------------------------------------
+func (sr *StreamRing) expireSlots(newest int64) {
+	limit := newest - sb.GetTimestampByDuration(sr.Retention)
+
+	// keep the newest one at least
+	for sr.Tail < sr.Seq-1 {
+		if sr.Slots[sr.posOfSeq(sr.Tail)].Timestamp >= limit || !sr.canEvictTail() {
+			break
+		}
+		sr.evictTail()
+	}
+}
+
+//----------------------------------------------------------------------------------
+// find the slot at or just before the time, the oldest if all are after it,
+// and whether to look into the spill tier, must be called in lock
+//----------------------------------------------------------------------------------
+func (sr *StreamRing) seqByTime(ts int64) (int64, bool, error) {
+	old := sr.oldest()
+	if old < 0 {
+		old = 0
+	}
+	if sr.Seq == 0 || old >= sr.Seq {
+		return 0, false, sb.ErrEmpty
+	}
+
+	for seq := sr.Seq - 1; seq >= old; seq-- {
+		if sr.Slots[sr.posOfSeq(seq)].Timestamp <= ts {
+			return seq, false, nil
+		}
+	}
+
+	if sr.Spill == nil {
+		return old, false, nil
+	}
+
+	// older than the ring, the slots queued are newer than the spilled
+	for i := len(sr.spilling) - 1; i >= 0; i-- {
+		if sr.spilling[i].Timestamp <= ts {
+			return sr.spilling[i].Seq, false, nil
+		}
+	}
+	if len(sr.spilling) > 0 {
+		old = sr.spilling[0].Seq
+	}
+
+	return old, true, nil
+}
+
+//----------------------------------------------------------------------------------
+// find the slot at or just before the time, the spill tier is looked into
+// out of the lock
+//----------------------------------------------------------------------------------
+func (sr *StreamRing) SeqByTime(ts int64) (int64, error) {
+	sr.Lock()
+	seq, older, err := sr.seqByTime(ts)
+	sp := sr.Spill
+	sr.Unlock()
+
+	if err != nil || !older {
+		return seq, err
+	}
+
+	sseq, err := sp.SeqByTime(ts)
+	if err == nil {
+		return sseq, nil
+	}
+
+	return seq, nil
+}
+
+//----------------------------------------------------------------------------------
+// get the slot at or just before the time, a copy read from the spill tier
+// if it is older than the ring
+// - ErrFound : spilled but not kept any more
+//----------------------------------------------------------------------------------
+func (sr *StreamRing) GetSlotByTime(ts int64) (*StreamSlot, error) {
+	seq, err := sr.SeqByTime(ts)
+	if err != nil {
+		return nil, err
+	}
+
+	sr.Lock()
+	if seq >= sr.oldest() && seq < sr.Seq {
+		slot := &sr.Slots[sr.posOfSeq(seq)]
+		sr.Unlock()
+		return slot, nil
+	}
+	if slot := sr.getSpilling(seq); slot != nil {
+		sr.Unlock()
+		return slot, nil
+	}
+	sp := sr.Spill
+	sr.Unlock()
+
+	if sp == nil {
+		return nil, sb.ErrFound
+	}
+
+	slot := NewStreamSlotBySize(sr.Size)
+	err = sp.ReadSpilled(seq, slot)
+	if err != nil {
+		return nil, err
+	}
+
+	return slot, err
+}
+
+//----------------------------------------------------------------------------------
+// move the reader to the slot at or just before the time
+//----------------------------------------------------------------------------------
+func (rr *RingReader) SeekTime(ts int64) error {
+	sr := rr.Ring
+
+	seq, err := sr.SeqByTime(ts)
+	if err != nil {
+		return err
+	}
+
+	sr.Lock()
+	defer sr.Unlock()
+
+	rr.Seq = seq
+	rr.Pos = sr.posOfSeq(seq)
+	rr.Last = -1
+
+	return err
+}
+
+//----------------------------------------------------------------------------------
+// move the reader to the slot of the given duration ago
+//----------------------------------------------------------------------------------
+func (rr *RingReader) SeekAgo(d time.Duration) error {
+	return rr.SeekTime(sb.GetTimestampNow() - sb.GetTimestampByDuration(d))
+}
+
+// ---------------------------------E-----N-----D-----------------------------------
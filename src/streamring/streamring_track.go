@@ -0,0 +1,174 @@
+//==================================================================================
+// Author : Stoney Kang, sikang99@gmail.com, 2015
+// Track aware set of rings binding each track of a source to its own ring
+//==================================================================================
+
+package streamring
+
+import (
+	"fmt"
+	"sync"
+
+	sb "stoney/httpserver/src/streambase"
+	si "stoney/httpserver/src/streaminfo"
+)
+
+//==================================================================================
+// stream tracks struc
+//----------------------------------------------------------------------------------
+type StreamTracks struct {
+	sync.Mutex
+	Source *si.Source
+	Rings  []*StreamRing // ring of each track in the order of Source.Trks
+}
+
+//----------------------------------------------------------------------------------
+// make a new set of rings, one for each track of the source
+//----------------------------------------------------------------------------------
+func NewStreamTracks(src *si.Source, num int, size int) *StreamTracks {
+	st := &StreamTracks{
+		Source: src,
+		Rings:  make([]*StreamRing, len(src.Trks)),
+	}
+
+	for i := range src.Trks {
+		trk := &src.Trks[i]
+		st.Rings[i] = NewStreamRingWithParams(num, size, trk.Type+" track")
+		st.Rings[i].Id = trk.Id
+	}
+
+	return st
+}
+
+//----------------------------------------------------------------------------------
+// string information for the tracks
+//----------------------------------------------------------------------------------
+func (st *StreamTracks) String() string {
+	str := fmt.Sprintf("[StreamTracks] %s", st.Source.Id)
+	str += fmt.Sprintf("\tNtrk: %d", len(st.Rings))
+	str += fmt.Sprintf("\tDesc: %s\n", st.Source.Desc)
+	for i := range st.Rings {
+		str += fmt.Sprintf("\t[%s:%s] %s\n", st.Source.Trks[i].Id, st.Source.Trks[i].Type, st.Rings[i].BaseString())
+	}
+	return str
+}
+
+//----------------------------------------------------------------------------------
+// bind the track to the ring given instead of its own
+//----------------------------------------------------------------------------------
+func (st *StreamTracks) BindRing(id string, ring *StreamRing) error {
+	st.Lock()
+	defer st.Unlock()
+
+	for i := range st.Source.Trks {
+		if st.Source.Trks[i].Id == id {
+			st.Rings[i] = ring
+			return nil
+		}
+	}
+
+	return sb.ErrFound
+}
+
+//----------------------------------------------------------------------------------
+// get the ring of the track by its id
+//----------------------------------------------------------------------------------
+func (st *StreamTracks) GetRing(id string) (*StreamRing, error) {
+	st.Lock()
+	defer st.Unlock()
+
+	for i := range st.Source.Trks {
+		if st.Source.Trks[i].Id == id {
+			return st.Rings[i], nil
+		}
+	}
+
+	return nil, sb.ErrFound
+}
+
+//----------------------------------------------------------------------------------
+// get the ring of the first track carrying the content type
+//----------------------------------------------------------------------------------
+func (st *StreamTracks) GetRingByType(ctype string) (*StreamRing, error) {
+	st.Lock()
+	defer st.Unlock()
+
+	for i := range st.Source.Trks {
+		if st.Source.Trks[i].IsType(ctype) {
+			return st.Rings[i], nil
+		}
+	}
+
+	return nil, sb.ErrFound
+}
+
+//----------------------------------------------------------------------------------
+// get the rings of the tracks subscribed, all of them if no id is given
+//----------------------------------------------------------------------------------
+func (st *StreamTracks) GetRings(ids ...string) ([]*StreamRing, error) {
+	if len(ids) == 0 {
+		st.Lock()
+		defer st.Unlock()
+
+		return append([]*StreamRing(nil), st.Rings...), nil
+	}
+
+	var rings []*StreamRing
+	for _, id := range ids {
+		ring, err := st.GetRing(id)
+		if err != nil {
+			return nil, err
+		}
+		rings = append(rings, ring)
+	}
+
+	return rings, nil
+}
+
+//----------------------------------------------------------------------------------
+// demultiplex the slot into the ring of the track by its content type
+//----------------------------------------------------------------------------------
+func (st *StreamTracks) PutSlotByType(slot *StreamSlot) (*StreamSlot, error) {
+	ring, err := st.GetRingByType(slot.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	return ring.PutSlotInNext(slot)
+}
+
+//----------------------------------------------------------------------------------
+// set status of all rings of the tracks
+//----------------------------------------------------------------------------------
+func (st *StreamTracks) SetStatusUsing() error {
+	rings, _ := st.GetRings()
+	for i, ring := range rings {
+		err := ring.SetStatusUsing()
+		if err != nil {
+			for _, used := range rings[:i] {
+				used.SetStatusIdle()
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (st *StreamTracks) SetStatusIdle() {
+	rings, _ := st.GetRings()
+	for _, ring := range rings {
+		ring.SetStatusIdle()
+	}
+}
+
+func (st *StreamTracks) IsUsing() bool {
+	rings, _ := st.GetRings()
+	for _, ring := range rings {
+		if ring.IsUsing() {
+			return true
+		}
+	}
+	return false
+}
+
+// ---------------------------------E-----N-----D-----------------------------------